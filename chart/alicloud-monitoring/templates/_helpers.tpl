@@ -49,3 +49,11 @@ chart: {{ template "alicloudmonitoring.chart" . }}
 {{ include "alicloudmonitoring.matchLabels" . }}
 {{- end -}}
 {{- end -}}
+
+{{- define "alicloudmonitoring.probe" -}}
+{{- $probe := deepCopy .probe -}}
+{{- if and .tls $probe.httpGet -}}
+{{- $_ := set $probe.httpGet "scheme" "HTTPS" -}}
+{{- end -}}
+{{ toYaml $probe }}
+{{- end -}}
@@ -0,0 +1,244 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+	"github.com/allanhung/alicloud-monitoring/pkg/notify"
+	"github.com/allanhung/alicloud-monitoring/pkg/plan"
+)
+
+// pendingPlans is the last submitted plan of every blocked job.
+var pendingPlans = struct {
+	sync.Mutex
+	plans map[string]pendingPlan
+}{plans: map[string]pendingPlan{}}
+
+type pendingPlan struct {
+	plan *plan.Plan
+	path string
+}
+
+var applyFlags = struct {
+	Plan   string
+	DryRun bool
+}{}
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply a tag change plan blocked for approval.",
+	Long: `This tool will apply the tag changes of a plan written by a run that changed more instances than --maxchanges allows,
+the plan is marked as applied when all changes succeeded.
+
+example:
+  alicloud-monitoring apply --plan /var/lib/alicloud-monitoring/plans/updatek8stags-20201019-100000.json --dryrun
+  alicloud-monitoring apply --plan /var/lib/alicloud-monitoring/plans/updatek8stags-20201019-100000.json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if applyFlags.Plan == "" {
+			log.Logger.Errorf("no plan, set --plan")
+			os.Exit(1)
+		}
+		p, err := plan.Load(applyFlags.Plan)
+		if err != nil {
+			log.Logger.Errorf("%v", err)
+			os.Exit(1)
+		}
+		if p.Status != plan.StatusPending {
+			log.Logger.Errorf("plan %s is %s, only pending plans can be applied", p.Id, p.Status)
+			os.Exit(1)
+		}
+		aliClient := newAliClient(monitor.NewRegistry(false))
+		defer aliClient.Close()
+		if p.Region != aliClient.Region() {
+			log.Logger.Errorf("plan %s is for region %s, credentials are for region %s", p.Id, p.Region, aliClient.Region())
+			os.Exit(1)
+		}
+
+		jobLock := &joblock.JobLock{Name: cmd.Use}
+		jobLock.SetRun("Apply plan " + p.Id)
+		defer jobLock.DoneRun()
+		jobLock.Log().Infof("Running job: %s, %d changes of %s", jobLock.Kind, len(p.Changes), p.Job)
+		failed := 0
+		for _, change := range p.Changes {
+			if err := applyTagChange(jobLock, aliClient, change, applyFlags.DryRun); err != nil {
+				failed++
+			}
+		}
+		if applyFlags.DryRun {
+			return
+		}
+		if failed > 0 {
+			log.Logger.Errorf("%d of %d changes of plan %s failed, the plan stays pending", failed, len(p.Changes), p.Id)
+			os.Exit(1)
+		}
+		p.Status = plan.StatusApplied
+		p.Applied = time.Now()
+		if err := p.Save(applyFlags.Plan); err != nil {
+			log.Logger.Errorf("%v", err)
+			os.Exit(1)
+		}
+		jobLock.Log().Infof("Job Completed. plan %s applied", p.Id)
+	},
+}
+
+// tagChange returns the change adding ecsTags to instance.
+func tagChange(instance ecs.Instance, ecsTags []ecs.AddTagsTag) plan.Change {
+	add := map[string]string{}
+	for _, tag := range ecsTags {
+		add[tag.Key] = tag.Value
+	}
+	return plan.Change{
+		InstanceId:   instance.InstanceId,
+		InstanceName: instance.InstanceName,
+		PreviousTags: instanceTags(instance),
+		Add:          add,
+	}
+}
+
+// applyTagChange sets the added tags first and removes tags only when that succeeded, so a failure never loses a tag value.
+// With dryRun the change is only logged and audited.
+func applyTagChange(job *joblock.JobLock, aliClient *alicloud.AliClient, change plan.Change, dryRun bool) error {
+	instance := ecs.Instance{InstanceId: change.InstanceId, InstanceName: change.InstanceName}
+	logger := job.Log().WithFields(logrus.Fields{log.FieldInstanceID: change.InstanceId, log.FieldRegion: aliClient.Region()})
+	if len(change.Add) > 0 {
+		keys := []string{}
+		for key := range change.Add {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		ecsTags := []ecs.AddTagsTag{}
+		for _, key := range keys {
+			ecsTags = append(ecsTags, ecs.AddTagsTag{Key: key, Value: change.Add[key]})
+		}
+		addLogger := logger.WithField(log.FieldAPI, "AddTags")
+		if dryRun {
+			addLogger.Infof("dry run, would add %s", plan.Change{Add: change.Add})
+			auditTagChange(job, aliClient, change.InstanceId, "AddTags", change.PreviousTags, change.Result(false), true, "", nil)
+		} else {
+			requestId, err := alicloud.AddInstanceTags(aliClient, instance, ecsTags)
+			auditTagChange(job, aliClient, change.InstanceId, "AddTags", change.PreviousTags, change.Result(false), false, requestId, err)
+			if err != nil {
+				addLogger.Errorf("failed to add tag. error: %v", err)
+				return err
+			}
+			addLogger.WithField(log.FieldRequestID, requestId).Infof("instance: %s (%s) tags added", change.InstanceId, change.InstanceName)
+		}
+	}
+	if len(change.Remove) > 0 {
+		removeLogger := logger.WithField(log.FieldAPI, "RemoveTags")
+		if dryRun {
+			removeLogger.Infof("dry run, would remove %s", strings.Join(change.Remove, ", "))
+			auditTagChange(job, aliClient, change.InstanceId, "RemoveTags", change.Result(false), change.Result(true), true, "", nil)
+		} else {
+			requestId, err := alicloud.RemoveInstanceTags(aliClient, instance, change.Remove)
+			auditTagChange(job, aliClient, change.InstanceId, "RemoveTags", change.Result(false), change.Result(true), false, requestId, err)
+			if err != nil {
+				removeLogger.Errorf("failed to remove tag. error: %v", err)
+				return err
+			}
+			removeLogger.WithField(log.FieldRequestID, requestId).Infof("instance: %s (%s) tags removed", change.InstanceId, change.InstanceName)
+		}
+	}
+	return nil
+}
+
+func planGuard(queryFlags alicloud.QueryEcsFlags) plan.Guard {
+	return plan.Guard{
+		MaxChanges: queryFlags.MaxChanges,
+		Dir:        queryFlags.PlanDir,
+		Webhook:    queryFlags.PlanWebhook,
+	}
+}
+
+// guardChanges returns true when the changes exceed the maximum of guard, the changes are then submitted as a pending plan
+// instead of being applied.
+func guardChanges(job *joblock.JobLock, aliClient *alicloud.AliClient, guard plan.Guard, am *monitor.ApprovalMonitor, changes []plan.Change) bool {
+	if !guard.Exceeded(len(changes)) {
+		am.PendingChanges.WithLabelValues(job.Name).Set(0)
+		pendingPlans.Lock()
+		delete(pendingPlans.plans, job.Name)
+		pendingPlans.Unlock()
+		return false
+	}
+	am.BlockedRuns.WithLabelValues(job.Name).Inc()
+	am.PendingChanges.WithLabelValues(job.Name).Set(float64(len(changes)))
+	p, path := submitPlan(job, aliClient, guard, changes)
+	job.Log().Warnf("run blocked, %d changes exceed the maximum of %d, plan %s waits for approval", len(changes), guard.MaxChanges, p.Id)
+	lines := []string{fmt.Sprintf("%d changes exceed the maximum of %d", len(changes), guard.MaxChanges)}
+	if path != "" {
+		lines = append(lines, fmt.Sprintf("approve with: alicloud-monitoring apply --plan %s", path))
+	}
+	// every plan is notified once, runs blocked again with the same changes keep the plan
+	err := notifier.Notify(notify.Message{
+		Key:   "blocked/" + job.Name + "/" + p.Id,
+		Kind:  job.Name,
+		Title: fmt.Sprintf("run of %s blocked, plan %s waits for approval", job.Name, p.Id),
+		Lines: lines,
+	})
+	if err != nil {
+		job.Log().Errorf("failed to notify blocked run: %v", err)
+	}
+	return true
+}
+
+// submitPlan returns the pending plan of the job when it has the same changes and was not applied meanwhile,
+// otherwise a new plan is submitted.
+func submitPlan(job *joblock.JobLock, aliClient *alicloud.AliClient, guard plan.Guard, changes []plan.Change) (*plan.Plan, string) {
+	pendingPlans.Lock()
+	defer pendingPlans.Unlock()
+	if pending, ok := pendingPlans.plans[job.Name]; ok && plan.SameChanges(pending.plan.Changes, changes) {
+		if pending.path == "" {
+			return pending.plan, pending.path
+		}
+		if p, err := plan.Load(pending.path); err == nil && p.Status == plan.StatusPending {
+			return pending.plan, pending.path
+		}
+	}
+	p := plan.New(job.Name, aliClient.Region(), guard.MaxChanges, changes)
+	path, err := guard.Submit(p)
+	if err != nil {
+		job.Log().Errorf("failed to submit plan %s: %v", p.Id, err)
+		// a saved plan stays pending when only the webhook failed, otherwise the next run submits again
+		if path == "" {
+			delete(pendingPlans.plans, job.Name)
+			return p, path
+		}
+	}
+	pendingPlans.plans[job.Name] = pendingPlan{plan: p, path: path}
+	return p, path
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+	f := applyCmd.Flags()
+	f.StringVarP(&applyFlags.Plan, "plan", "p", "", "plan file to apply")
+	f.BoolVarP(&applyFlags.DryRun, "dryrun", "", false, "only log and audit the changes of the plan")
+}
@@ -0,0 +1,100 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/audit"
+	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+)
+
+var auditFile string
+var auditTrail *audit.Trail
+
+// identityRetryInterval is how long a failed caller identity lookup is not retried.
+const identityRetryInterval = 5 * time.Minute
+
+var (
+	callerIdentity       *alicloud.CallerIdentity
+	callerIdentityFailed time.Time
+	callerIdentityLock   sync.Mutex
+)
+
+// initAudit builds the audit trail from the "audit" section of the config file, --auditfile overrides its file.
+func initAudit() {
+	cfg := audit.Config{}
+	if err := viper.UnmarshalKey("audit", &cfg); err != nil {
+		log.Logger.Errorf("failed to load audit config: %v", err)
+		os.Exit(1)
+	}
+	if auditFile != "" {
+		cfg.File = auditFile
+	}
+	auditTrail = audit.NewTrail(cfg)
+}
+
+// auditIdentity looks up the account and RAM identity once, a failed lookup is retried after identityRetryInterval.
+func auditIdentity(aliClient *alicloud.AliClient) alicloud.CallerIdentity {
+	callerIdentityLock.Lock()
+	defer callerIdentityLock.Unlock()
+	if callerIdentity != nil {
+		return *callerIdentity
+	}
+	if time.Since(callerIdentityFailed) < identityRetryInterval {
+		return alicloud.CallerIdentity{}
+	}
+	identity, err := alicloud.QueryCallerIdentity(aliClient)
+	if err != nil {
+		callerIdentityFailed = time.Now()
+		log.Logger.Warnf("failed to get caller identity for audit records: %v", err)
+		return identity
+	}
+	callerIdentity = &identity
+	return identity
+}
+
+// auditTagChange records a write operation changing the tags of an instance from previousTags to newTags.
+func auditTagChange(job *joblock.JobLock, aliClient *alicloud.AliClient, instanceId string, operation string, previousTags map[string]string, newTags map[string]string, dryRun bool, requestId string, err error) {
+	if !auditTrail.Enabled() {
+		return
+	}
+	identity := auditIdentity(aliClient)
+	record := audit.Record{
+		Account:      identity.AccountId,
+		Region:       aliClient.Region(),
+		ResourceType: "instance",
+		ResourceId:   instanceId,
+		Operation:    operation,
+		PreviousTags: previousTags,
+		NewTags:      newTags,
+		Actor:        identity.Arn,
+		DryRun:       dryRun,
+		RequestId:    requestId,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if err := auditTrail.Record(record); err != nil {
+		job.Log().WithField(log.FieldInstanceID, instanceId).Errorf("%v", err)
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+)
+
+// newAliClient loads the credentials and creates the client used by the jobs of a command, exiting on failure.
+// Refresh results of expiring credentials are reported on reg.
+func newAliClient(reg prometheus.Registerer) *alicloud.AliClient {
+	// at 0 or below credentials are refreshed in a loop, at 1 or above only after they expired
+	if alicloud.RefreshFraction <= 0 || alicloud.RefreshFraction >= 1 {
+		log.Logger.Errorf("invalid --refreshfraction %v, must be between 0 and 1", alicloud.RefreshFraction)
+		os.Exit(1)
+	}
+	cfg := &alicloud.AliCloudConfig{}
+	err := cfg.GetCloudConfig()
+	if err != nil {
+		log.Logger.Errorf("failed to getCloudConfigFromStsToken: %v", err)
+		os.Exit(1)
+	}
+
+	aliClient, err := alicloud.NewAliClient(cfg)
+	if err != nil {
+		log.Logger.Errorf("failed to create aliClient: %v", err)
+		os.Exit(1)
+	}
+	monitor.SetCredentialsValid(true)
+
+	if refresher := aliClient.Refresher(); refresher != nil {
+		pm := monitor.NewCredentialMonitor(reg)
+		pm.Observe(refresher.Expiration(), nil)
+		refresher.OnRefresh(pm.Observe)
+	}
+	return aliClient
+}
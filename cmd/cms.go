@@ -0,0 +1,302 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/collector"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+	"github.com/allanhung/alicloud-monitoring/pkg/types"
+)
+
+// cmsPageSize is the number of datapoints requested per DescribeMetricList page.
+const cmsPageSize = 1000
+
+var cmsQueryFlags = alicloud.QueryCmsFlags{}
+
+var invalidMetricChars = regexp.MustCompile("[^a-z0-9_]")
+
+func metricName(parts ...string) string {
+	return invalidMetricChars.ReplaceAllString(strings.ToLower(strings.Join(parts, "_")), "_")
+}
+
+// cmsSeries is one exported metric, a cms metric name with one statistic.
+type cmsSeries struct {
+	desc       *prometheus.Desc
+	fqName     string
+	metric     alicloud.CmsMetric
+	name       string
+	statistic  string
+	joinEcs    bool
+	tagLabels  []string
+	dimensions []string
+}
+
+// cmsCmd represents the cms command
+var cmsCmd = &cobra.Command{
+	Use:   "cms",
+	Short: "Export cloud monitor metrics.",
+	Long: `This tool will export cloud monitor metrics configured in the "cms" section of the config file,
+queried when scraped.
+
+example config:
+  cms:
+    tagLabels: ["Environment"]
+    metrics:
+    - namespace: acs_ecs_dashboard
+      names: ["CPUUtilization", "memory_usedutilization"]
+      period: 60
+      statistics: ["Average", "Maximum"]
+    - namespace: acs_slb_dashboard
+      names: ["ActiveConnection"]
+      period: 60
+      dimensions: ["instanceId", "port", "vip"]
+
+example:
+  alicloud-monitoring cms --config /etc/alicloud-monitoring/config.yaml --cachettl 1m`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := viper.UnmarshalKey("cms.metrics", &cmsQueryFlags.Metrics); err != nil {
+			log.Logger.Errorf("failed to load cms config: %v", err)
+			os.Exit(1)
+		}
+		if len(cmsQueryFlags.TagLabels) == 0 {
+			cmsQueryFlags.TagLabels = append(cmsQueryFlags.TagLabels, viper.GetStringSlice("cms.tagLabels")...)
+		}
+		reg := monitor.NewRegistry(runtimeMetrics)
+		aliClient := newAliClient(reg)
+		defer aliClient.Close()
+
+		c, err := newCmsCollector(cmd.Use, aliClient, cmsQueryFlags)
+		if err != nil {
+			log.Logger.Errorf("%v", err)
+			os.Exit(1)
+		}
+		reg.MustRegister(c)
+		err = monitor.PrometheusBoot(reg, webListenAddress, webConfigFile)
+		if err != nil {
+			log.Logger.Errorf("failed to ListenAndServe: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func newCmsSeries(queryFlags alicloud.QueryCmsFlags) ([]cmsSeries, error) {
+	if len(queryFlags.Metrics) == 0 {
+		return nil, fmt.Errorf("no cms metrics configured")
+	}
+	seriesList := []cmsSeries{}
+	fqNames := map[string]bool{}
+	for _, metric := range queryFlags.Metrics {
+		if metric.Namespace == "" || len(metric.Names) == 0 {
+			return nil, fmt.Errorf("cms metric needs a namespace and names: %v", metric)
+		}
+		if metric.Period == 0 {
+			metric.Period = 60
+		}
+		if len(metric.Statistics) == 0 {
+			metric.Statistics = []string{"Average"}
+		}
+		if len(metric.Dimensions) == 0 {
+			metric.Dimensions = []string{"instanceId"}
+		}
+		labels := []string{}
+		joinEcs := false
+		for _, dimension := range metric.Dimensions {
+			labels = append(labels, metricName(dimension))
+			if dimension == "instanceId" {
+				joinEcs = true
+			}
+		}
+		tagLabels := []string{}
+		if joinEcs {
+			labels = append(labels, "name")
+			for _, tag := range queryFlags.TagLabels {
+				labels = append(labels, metricName("tag", tag))
+				tagLabels = append(tagLabels, tag)
+			}
+		}
+		seenLabels := map[string]bool{}
+		for _, label := range labels {
+			if seenLabels[label] {
+				return nil, fmt.Errorf("cms metrics of %s have the label %s twice, check the dimensions and tag labels", metric.Namespace, label)
+			}
+			seenLabels[label] = true
+		}
+		for _, name := range metric.Names {
+			for _, statistic := range metric.Statistics {
+				fqName := metricName(metric.Namespace, name, statistic)
+				if fqNames[fqName] {
+					return nil, fmt.Errorf("cms metric %s %s of %s is configured twice", statistic, name, metric.Namespace)
+				}
+				fqNames[fqName] = true
+				seriesList = append(seriesList, cmsSeries{
+					desc: prometheus.NewDesc(
+						fqName,
+						fmt.Sprintf("Cloud monitor metric %s %s of %s.", statistic, name, metric.Namespace),
+						labels, nil,
+					),
+					fqName:     fqName,
+					metric:     metric,
+					name:       name,
+					statistic:  statistic,
+					joinEcs:    joinEcs,
+					tagLabels:  tagLabels,
+					dimensions: metric.Dimensions,
+				})
+			}
+		}
+	}
+	return seriesList, nil
+}
+
+// queryCmsDatapoints returns the newest datapoint of every dimension set, with a lookback
+// the datapoints of that window are listed for namespaces reporting late.
+func queryCmsDatapoints(aliClient *alicloud.AliClient, metric alicloud.CmsMetric, name string) ([]alicloud.Datapoint, error) {
+	var points []alicloud.Datapoint
+	var err error
+	if metric.Lookback > 0 {
+		end := time.Now()
+		points, err = alicloud.QueryMetricList(aliClient, metric.Namespace, name, metric.Period, metric.Filter, end.Add(-metric.Lookback), end, cmsPageSize)
+	} else {
+		points, err = alicloud.QueryMetricLast(aliClient, metric.Namespace, name, metric.Period, metric.Filter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	latest := map[string]alicloud.Datapoint{}
+	keys := []string{}
+	for _, point := range points {
+		values := []string{}
+		for _, dimension := range metric.Dimensions {
+			values = append(values, point.Dimension(dimension))
+		}
+		key := strings.Join(values, "\xff")
+		current, ok := latest[key]
+		if !ok {
+			keys = append(keys, key)
+		}
+		if !ok || point.Timestamp() > current.Timestamp() {
+			latest[key] = point
+		}
+	}
+	result := []alicloud.Datapoint{}
+	for _, key := range keys {
+		result = append(result, latest[key])
+	}
+	return result, nil
+}
+
+// newCmsCollector exposes cloud monitor metrics, instance metrics are joined with the name and tags of the ecs instance.
+func newCmsCollector(name string, aliClient *alicloud.AliClient, queryFlags alicloud.QueryCmsFlags) (*collector.CachedCollector, error) {
+	seriesList, err := newCmsSeries(queryFlags)
+	if err != nil {
+		return nil, err
+	}
+	descs := []*prometheus.Desc{}
+	joinEcs := false
+	for _, series := range seriesList {
+		descs = append(descs, series.desc)
+		joinEcs = joinEcs || series.joinEcs
+	}
+	return collector.NewCachedCollector(name, queryFlags.CacheTTL, descs, func() ([]prometheus.Metric, error) {
+		instances := map[string]ecs.Instance{}
+		if joinEcs {
+			queryList, err := alicloud.QueryECS(aliClient, alicloud.QueryEcsFlags{PageSize: queryFlags.PageSize})
+			if err != nil {
+				return nil, err
+			}
+			for _, instance := range queryList {
+				instances[instance.InstanceId] = instance
+			}
+		}
+
+		metrics := []prometheus.Metric{}
+		points := map[string][]alicloud.Datapoint{}
+		for _, series := range seriesList {
+			key := fmt.Sprintf("%s/%s/%d/%s/%v", series.metric.Namespace, series.name, series.metric.Period, series.metric.Filter, series.metric.Lookback)
+			if _, ok := points[key]; !ok {
+				p, err := queryCmsDatapoints(aliClient, series.metric, series.name)
+				if err != nil {
+					return nil, err
+				}
+				points[key] = p
+			}
+			for _, point := range points[key] {
+				value, ok := point.Value(series.statistic)
+				if !ok {
+					continue
+				}
+				labelValues := []string{}
+				for _, dimension := range series.dimensions {
+					labelValues = append(labelValues, point.Dimension(dimension))
+				}
+				if series.joinEcs {
+					instance := instances[point.Dimension("instanceId")]
+					labelValues = append(labelValues, instance.InstanceName)
+					for _, tagKey := range series.tagLabels {
+						tagValue := ""
+						for _, tag := range instance.Tags.Tag {
+							if tag.TagKey == tagKey {
+								tagValue = tag.TagValue
+								break
+							}
+						}
+						labelValues = append(labelValues, tagValue)
+					}
+				}
+				metrics = append(metrics, prometheus.MustNewConstMetric(series.desc, prometheus.GaugeValue, value, labelValues...))
+			}
+		}
+		return metrics, nil
+	}), nil
+}
+
+func cmsServeJob(env *serveEnv, jobCfg serveJobConfig) func() error {
+	queryFlags := alicloud.QueryCmsFlags{
+		PageSize:  jobCfg.PageSize,
+		Metrics:   jobCfg.Metrics,
+		TagLabels: types.ArgList(jobCfg.TagLabels),
+		CacheTTL:  jobCfg.CacheTTL,
+	}
+	c, err := newCmsCollector(jobCfg.Name, env.aliClient, queryFlags)
+	if err != nil {
+		log.Logger.Errorf("job %s: %v", jobCfg.Name, err)
+		os.Exit(1)
+	}
+	env.reg.MustRegister(c)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(cmsCmd)
+	serveJobs["cms"] = cmsServeJob
+	f := cmsCmd.Flags()
+	f.IntVarP(&cmsQueryFlags.PageSize, "pagesize", "s", 10, "alicloud api pagesize for the ecs instance query")
+	f.VarP(&cmsQueryFlags.TagLabels, "taglabel", "", "ecs instance tag exported as label of instance metrics, overrides cms.tagLabels of the config file (can specify multiple)")
+	f.DurationVarP(&cmsQueryFlags.CacheTTL, "cachettl", "", time.Minute, "how long a query result is reused")
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/types"
+)
+
+func TestNewCmsSeries(t *testing.T) {
+	tests := []struct {
+		name      string
+		metrics   []alicloud.CmsMetric
+		tagLabels types.ArgList
+		want      []string
+		err       string
+	}{
+		{
+			name: "defaults",
+			metrics: []alicloud.CmsMetric{
+				{Namespace: "acs_ecs_dashboard", Names: []string{"CPUUtilization"}},
+			},
+			want: []string{"acs_ecs_dashboard_cpuutilization_average"},
+		},
+		{
+			name: "statistics",
+			metrics: []alicloud.CmsMetric{
+				{Namespace: "acs_slb_dashboard", Names: []string{"ActiveConnection"}, Statistics: []string{"Average", "Maximum"}, Dimensions: []string{"instanceId", "port"}},
+			},
+			tagLabels: types.ArgList{"Environment"},
+			want:      []string{"acs_slb_dashboard_activeconnection_average", "acs_slb_dashboard_activeconnection_maximum"},
+		},
+		{
+			name: "same metric twice",
+			metrics: []alicloud.CmsMetric{
+				{Namespace: "acs_ecs_dashboard", Names: []string{"CPUUtilization"}, Period: 60},
+				{Namespace: "acs_ecs_dashboard", Names: []string{"CPUUtilization"}, Period: 300},
+			},
+			err: "configured twice",
+		},
+		{
+			name: "dimensions with the same label",
+			metrics: []alicloud.CmsMetric{
+				{Namespace: "acs_slb_dashboard", Names: []string{"ActiveConnection"}, Dimensions: []string{"instanceId", "InstanceId"}},
+			},
+			err: "label instanceid twice",
+		},
+		{
+			name: "tag label colliding with a dimension",
+			metrics: []alicloud.CmsMetric{
+				{Namespace: "acs_ecs_dashboard", Names: []string{"CPUUtilization"}, Dimensions: []string{"instanceId", "name"}},
+			},
+			err: "label name twice",
+		},
+		{
+			name:    "no metrics",
+			metrics: nil,
+			err:     "no cms metrics configured",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seriesList, err := newCmsSeries(alicloud.QueryCmsFlags{Metrics: tt.metrics, TagLabels: tt.tagLabels})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("newCmsSeries() error = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newCmsSeries() error = %v", err)
+			}
+			got := []string{}
+			for _, series := range seriesList {
+				got = append(got, series.fqName)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("newCmsSeries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+)
+
+const (
+	// gaugeMode updates gauges on a cron schedule.
+	gaugeMode = "gauge"
+	// collectorMode queries alicloud when the metrics endpoint is scraped.
+	collectorMode = "collector"
+)
+
+func validateMode(mode string) error {
+	switch mode {
+	case gaugeMode, collectorMode:
+		return nil
+	default:
+		return fmt.Errorf("unknown mode: %s, must be %s or %s", mode, gaugeMode, collectorMode)
+	}
+}
+
+// metricSources maps the job kinds to the metrics they export, kinds sharing a monitor share a source.
+// A source can only be registered once, so a collector mode job cannot run next to another job of its source.
+var metricSources = map[string]string{
+	"ecs":           "tags",
+	"updatek8stags": "tags",
+	"spotprice":     "spotprice",
+	"quota":         "quota",
+	"stock":         "stock",
+	"vswitch":       "vswitch",
+}
+
+type metricSourceClaim struct {
+	job       string
+	collector bool
+}
+
+// jobMetricSources returns the metric sources of a serve job, a cms job claims every metric of its config
+// so cms jobs exporting different metrics can run together.
+func jobMetricSources(jobCfg serveJobConfig) []string {
+	if jobCfg.Kind == "cms" {
+		seriesList, err := newCmsSeries(alicloud.QueryCmsFlags{Metrics: jobCfg.Metrics})
+		if err != nil {
+			// the job reports its config error when it is created
+			return nil
+		}
+		sources := []string{}
+		for _, series := range seriesList {
+			sources = append(sources, "cms "+series.fqName)
+		}
+		return sources
+	}
+	if source, ok := metricSources[jobCfg.Kind]; ok {
+		return []string{source}
+	}
+	return nil
+}
+
+// claimMetricSource records the metric sources of a serve job and returns an error when one conflicts with
+// a job claimed before. cms jobs always collect on scrape.
+func claimMetricSource(claims map[string]metricSourceClaim, jobCfg serveJobConfig) error {
+	collector := jobCfg.Mode == collectorMode || jobCfg.Kind == "cms"
+	for _, source := range jobMetricSources(jobCfg) {
+		if prev, ok := claims[source]; ok && (prev.collector || collector) {
+			return fmt.Errorf("jobs %s and %s export the same %s metrics, a job in %s mode must be the only one exporting them", prev.job, jobCfg.Name, source, collectorMode)
+		}
+		claims[source] = metricSourceClaim{job: jobCfg.Name, collector: collector}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/compliance"
+	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+)
+
+var complianceQueryFlags = alicloud.QueryEcsFlags{}
+
+// complianceCmd represents the compliance command
+var complianceCmd = &cobra.Command{
+	Use:   "compliance",
+	Short: "Score ecs instances against the required tag schema.",
+	Long: `This tool will check the tags of ecs instances against the rules in the compliance section of the config file
+and export a score per instance and the compliance ratio per group and vpc.
+
+example:
+  alicloud-monitoring compliance --config config.yaml
+  alicloud-monitoring compliance --re 'worker-k8s.*' --cron '0 */10 * * * *'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		reg := monitor.NewRegistry(runtimeMetrics)
+		pm := monitor.NewComplianceMonitor(reg)
+		var c *cron.Cron
+		jobLock := &joblock.JobLock{Name: cmd.Use}
+		complianceQueryFlags.Normalizer = tagNormalizer
+		schema, err := newComplianceSchema()
+		if err != nil {
+			log.Logger.Errorf("%v", err)
+			os.Exit(1)
+		}
+
+		aliClient := newAliClient(reg)
+		defer aliClient.Close()
+
+		if complianceQueryFlags.Cron == "" {
+			err := queryCompliance(jobLock, aliClient, complianceQueryFlags, schema, pm)
+			monitor.SetJobResult(cmd.Use, err)
+			if err != nil {
+				log.Logger.Errorf("%v", err)
+				os.Exit(1)
+			}
+		} else {
+			c = cron.New(cron.WithSeconds())
+			c.AddFunc(complianceQueryFlags.Cron, func() {
+				err := queryCompliance(jobLock, aliClient, complianceQueryFlags, schema, pm)
+				if err != nil {
+					log.Logger.Errorf("%v", err)
+				}
+				monitor.SetJobResult(cmd.Use, err)
+			})
+			fmt.Printf("start: %v", time.Now())
+			c.Start()
+		}
+		err = monitor.PrometheusBoot(reg, webListenAddress, webConfigFile)
+		if c != nil {
+			c.Stop()
+		}
+		if err != nil {
+			log.Logger.Errorf("failed to ListenAndServe: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// newComplianceSchema builds the schema from the "compliance" section of the config file,
+// without it the Environment tag is required. Keys and values are rewritten to their canonical form.
+func newComplianceSchema() (*compliance.Schema, error) {
+	cfg := compliance.DefaultConfig()
+	if viper.IsSet("compliance") {
+		cfg = compliance.Config{}
+		if err := viper.UnmarshalKey("compliance", &cfg); err != nil {
+			return nil, fmt.Errorf("failed to load compliance config: %v", err)
+		}
+	}
+	// instance tags are normalized before evaluation, so the schema has to use the canonical keys and values too
+	rules := []compliance.Rule{}
+	for _, rule := range cfg.Rules {
+		rule.Key = tagNormalizer.CanonicalKey(rule.Key)
+		values := []string{}
+		for _, value := range rule.Values {
+			values = append(values, tagNormalizer.CanonicalValue(rule.Key, value))
+		}
+		if len(values) > 0 {
+			rule.Values = values
+		}
+		rules = append(rules, rule)
+	}
+	cfg.Rules = rules
+	groupBy := []string{}
+	for _, key := range cfg.GroupBy {
+		groupBy = append(groupBy, tagNormalizer.CanonicalKey(key))
+	}
+	cfg.GroupBy = groupBy
+	schema, err := compliance.NewSchema(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compliance schema: %v", err)
+	}
+	return schema, nil
+}
+
+// evaluateCompliance scores the normalized tags of every instance matching queryFlags.
+func evaluateCompliance(aliClient *alicloud.AliClient, queryFlags alicloud.QueryEcsFlags, schema *compliance.Schema) ([]compliance.Result, error) {
+	vpcs, err := alicloud.QueryVpc(aliClient, queryFlags.PageSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VPC information: %v", err)
+	}
+	vpcNames := map[string]string{}
+	for _, vpc := range vpcs {
+		vpcNames[vpc.VpcId] = vpc.VpcName
+	}
+	instances, err := alicloud.QueryECS(aliClient, queryFlags)
+	if err != nil {
+		return nil, err
+	}
+	results := []compliance.Result{}
+	for _, instance := range instances {
+		tags := tagNormalizer.Normalize(instanceTags(instance))
+		score, violations := schema.Evaluate(tags)
+		results = append(results, compliance.Result{
+			InstanceId:   instance.InstanceId,
+			InstanceName: instance.InstanceName,
+			VpcId:        instance.VpcAttributes.VpcId,
+			VpcName:      vpcNames[instance.VpcAttributes.VpcId],
+			Group:        schema.Group(tags),
+			Score:        score,
+			Violations:   violations,
+		})
+	}
+	return results, nil
+}
+
+func queryCompliance(job *joblock.JobLock, aliClient *alicloud.AliClient, queryFlags alicloud.QueryEcsFlags, schema *compliance.Schema, pm *monitor.ComplianceMonitor) error {
+	if job.IsRunning {
+		return fmt.Errorf("job is still running: %s", job.Kind)
+	} else {
+		job.SetRun("Tag compliance")
+	}
+	defer job.DoneRun()
+	job.Log().Infof("Running job: %s", job.Kind)
+
+	results, err := evaluateCompliance(aliClient, queryFlags, schema)
+	if err != nil {
+		return err
+	}
+	violationCount := map[compliance.Violation]int{}
+	for _, result := range results {
+		pm.ScoreSeries.Set(job.Name, prometheus.Labels{"id": result.InstanceId, "name": result.InstanceName, "vpcid": result.VpcId, "group": result.Group}, result.Score)
+		for _, violation := range result.Violations {
+			violationCount[compliance.Violation{Key: violation.Key, Reason: violation.Reason}]++
+			job.Log().WithField(log.FieldInstanceID, result.InstanceId).Debugf("instance: %s (%s) %s", result.InstanceId, result.InstanceName, violation)
+		}
+	}
+	for violation, count := range violationCount {
+		pm.ViolationsSeries.Set(job.Name, prometheus.Labels{"key": violation.Key, "reason": violation.Reason}, float64(count))
+	}
+	report := compliance.NewReport(results)
+	for _, summary := range report.Summaries {
+		pm.RatioSeries.Set(job.Name, prometheus.Labels{"group": summary.Group, "vpcid": summary.VpcId}, summary.Ratio())
+	}
+	pm.ScoreSeries.EndRun(job.Name)
+	pm.ViolationsSeries.EndRun(job.Name)
+	pm.RatioSeries.EndRun(job.Name)
+	total := report.Total()
+	job.Log().Infof("Job Completed. %d of %d instances compliant", total.Compliant, total.Instances)
+	return nil
+}
+
+func complianceServeJob(env *serveEnv, jobCfg serveJobConfig) func() error {
+	queryFlags := jobCfg.queryEcsFlags()
+	schema, err := newComplianceSchema()
+	if err != nil {
+		log.Logger.Errorf("job %s: %v", jobCfg.Name, err)
+		os.Exit(1)
+	}
+	pm := env.getComplianceMonitor()
+	jobLock := &joblock.JobLock{Name: jobCfg.Name}
+	return func() error {
+		return queryCompliance(jobLock, env.aliClient, queryFlags, schema, pm)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(complianceCmd)
+	serveJobs["compliance"] = complianceServeJob
+	f := complianceCmd.Flags()
+	f.IntVarP(&complianceQueryFlags.PageSize, "pagesize", "s", 10, "alicloud api pagesize")
+	f.VarP(&complianceQueryFlags.Tag, "tag", "t", "filter by ecs instance tag example: cluster=prod (can specify multiple)")
+	f.VarP(&complianceQueryFlags.ReName, "re", "", "filter by ecs instance name with regular expression  example: ecs.* (can specify multiple, will use or operator)")
+	f.StringVarP(&complianceQueryFlags.Cron, "cron", "c", "", "cron scheduler")
+}
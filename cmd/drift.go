@@ -0,0 +1,227 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/iac"
+	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+	"github.com/allanhung/alicloud-monitoring/pkg/notify"
+	"github.com/allanhung/alicloud-monitoring/pkg/types"
+)
+
+// defaultIgnoreKeys are the tags alicloud services add to instances.
+var defaultIgnoreKeys = []string{"^acs:"}
+
+var driftQueryFlags = alicloud.QueryDriftFlags{}
+
+// driftCmd represents the drift command
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "Compare ecs tags with terraform state.",
+	Long: `This tool will compare the tags of ecs instances declared in terraform state files with their actual tags
+and export the instances managed by terraform and every tag that drifted.
+
+example:
+  alicloud-monitoring drift --tfstate terraform.tfstate
+  alicloud-monitoring drift --tfstate prod.tfstate --tfstate stage.tfstate --ignorekey '^acs:' --cron '0 0 * * * *'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		reg := monitor.NewRegistry(runtimeMetrics)
+		pm := monitor.NewDriftMonitor(reg)
+		var c *cron.Cron
+		jobLock := &joblock.JobLock{Name: cmd.Use}
+		if len(driftQueryFlags.TerraformState) == 0 {
+			log.Logger.Errorf("no terraform state, set --tfstate")
+			os.Exit(1)
+		}
+		if len(driftQueryFlags.IgnoreKeys) == 0 {
+			driftQueryFlags.IgnoreKeys = append(driftQueryFlags.IgnoreKeys, defaultIgnoreKeys...)
+		}
+
+		aliClient := newAliClient(reg)
+		defer aliClient.Close()
+
+		if driftQueryFlags.Cron == "" {
+			err := queryDrift(jobLock, aliClient, driftQueryFlags, pm)
+			monitor.SetJobResult(cmd.Use, err)
+			if err != nil {
+				log.Logger.Errorf("%v", err)
+				os.Exit(1)
+			}
+		} else {
+			c = cron.New(cron.WithSeconds())
+			c.AddFunc(driftQueryFlags.Cron, func() {
+				err := queryDrift(jobLock, aliClient, driftQueryFlags, pm)
+				if err != nil {
+					log.Logger.Errorf("%v", err)
+				}
+				monitor.SetJobResult(cmd.Use, err)
+			})
+			fmt.Printf("start: %v", time.Now())
+			c.Start()
+		}
+		err := monitor.PrometheusBoot(reg, webListenAddress, webConfigFile)
+		if c != nil {
+			c.Stop()
+		}
+		if err != nil {
+			log.Logger.Errorf("failed to ListenAndServe: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func queryDrift(job *joblock.JobLock, aliClient *alicloud.AliClient, queryFlags alicloud.QueryDriftFlags, pm *monitor.DriftMonitor) error {
+	if job.IsRunning {
+		return fmt.Errorf("job is still running: %s", job.Kind)
+	} else {
+		job.SetRun("Terraform drift")
+	}
+	defer job.DoneRun()
+	job.Log().Infof("Running job: %s", job.Kind)
+
+	ignore := []*regexp.Regexp{}
+	for _, key := range queryFlags.IgnoreKeys {
+		re, err := regexp.Compile(key)
+		if err != nil {
+			return fmt.Errorf("invalid ignore key %s: %v", key, err)
+		}
+		ignore = append(ignore, re)
+	}
+	state, err := iac.LoadState(queryFlags.TerraformState)
+	if err != nil {
+		return err
+	}
+	instances, err := alicloud.QueryECS(aliClient, alicloud.QueryEcsFlags{
+		PageSize: queryFlags.PageSize,
+		Tag:      queryFlags.Tag,
+		ReName:   queryFlags.ReName,
+	})
+	if err != nil {
+		return err
+	}
+	managed := 0
+	drifted := 0
+	for _, instance := range instances {
+		declared, ok := state.Managed(instance.InstanceId)
+		if !ok {
+			continue
+		}
+		managed++
+		pm.ManagedSeries.Set(job.Name, prometheus.Labels{"id": instance.InstanceId, "name": instance.InstanceName, "address": declared.Address}, 1)
+		drifts := iac.Compare(declared.Tags, instanceTags(instance), ignore)
+		if len(drifts) > 0 {
+			drifted++
+		}
+		for _, drift := range drifts {
+			pm.TagDriftSeries.Set(job.Name, prometheus.Labels{"id": instance.InstanceId, "name": instance.InstanceName, "address": declared.Address, "key": drift.Key, "direction": drift.Direction}, 1)
+			job.Log().WithField(log.FieldInstanceID, instance.InstanceId).Infof("instance: %s (%s) tag %s %s, declared %q actual %q in %s", instance.InstanceId, instance.InstanceName, drift.Key, drift.Direction, drift.Declared, drift.Actual, declared.Address)
+		}
+	}
+	pm.ManagedSeries.EndRun(job.Name)
+	pm.TagDriftSeries.EndRun(job.Name)
+	job.Log().Infof("Job Completed. %d of %d instances managed by terraform, %d with tag drift", managed, len(instances), drifted)
+	return nil
+}
+
+// loadManagedInstances loads the terraform state of a tag writing job, nil without state files.
+func loadManagedInstances(queryFlags alicloud.QueryEcsFlags) (*iac.State, error) {
+	switch queryFlags.IacMode {
+	case "", iac.ModeSkip, iac.ModeReport:
+	default:
+		return nil, fmt.Errorf("unknown iac mode: %s, must be %s or %s", queryFlags.IacMode, iac.ModeSkip, iac.ModeReport)
+	}
+	if len(queryFlags.TerraformState) == 0 {
+		return nil, nil
+	}
+	return iac.LoadState(queryFlags.TerraformState)
+}
+
+// skipManaged returns true for instances declared in terraform state, in report mode the tags that were not written are
+// logged and returned as notification line.
+func skipManaged(job *joblock.JobLock, state *iac.State, queryFlags alicloud.QueryEcsFlags, instanceId string, instanceName string, tags string) (bool, string) {
+	declared, ok := state.Managed(instanceId)
+	if !ok {
+		return false, ""
+	}
+	logger := job.Log().WithField(log.FieldInstanceID, instanceId)
+	if queryFlags.IacMode != iac.ModeReport {
+		logger.Debugf("instance: %s (%s) is managed by terraform at %s, skipped", instanceId, instanceName, declared.Address)
+		return true, ""
+	}
+	logger.Warnf("instance: %s (%s) is managed by terraform at %s, not changed: %s", instanceId, instanceName, declared.Address, tags)
+	return true, fmt.Sprintf("%s (%s) %s: %s", instanceId, instanceName, declared.Address, tags)
+}
+
+func notifyManaged(job *joblock.JobLock, kind string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	sort.Strings(lines)
+	err := notifier.Notify(notify.Message{
+		Key:   "iac/" + strings.Join(lines, ","),
+		Kind:  kind,
+		Title: fmt.Sprintf("%d instances managed by terraform not changed, update their terraform code", len(lines)),
+		Lines: lines,
+	})
+	if err != nil {
+		job.Log().Errorf("failed to notify terraform managed instances: %v", err)
+	}
+}
+
+func driftServeJob(env *serveEnv, jobCfg serveJobConfig) func() error {
+	queryFlags := alicloud.QueryDriftFlags{
+		PageSize:       jobCfg.PageSize,
+		Tag:            types.ArgList(jobCfg.Tag),
+		ReName:         types.ArgList(jobCfg.ReName),
+		TerraformState: types.ArgList(jobCfg.TerraformState),
+		IgnoreKeys:     types.ArgList(jobCfg.IgnoreKeys),
+		Cron:           jobCfg.Cron,
+	}
+	if len(queryFlags.IgnoreKeys) == 0 {
+		queryFlags.IgnoreKeys = append(queryFlags.IgnoreKeys, defaultIgnoreKeys...)
+	}
+	pm := env.getDriftMonitor()
+	jobLock := &joblock.JobLock{Name: jobCfg.Name}
+	return func() error {
+		return queryDrift(jobLock, env.aliClient, queryFlags, pm)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(driftCmd)
+	serveJobs["drift"] = driftServeJob
+	f := driftCmd.Flags()
+	f.IntVarP(&driftQueryFlags.PageSize, "pagesize", "s", 10, "alicloud api pagesize")
+	f.VarP(&driftQueryFlags.Tag, "tag", "t", "filter by ecs instance tag example: cluster=prod (can specify multiple)")
+	f.VarP(&driftQueryFlags.ReName, "re", "", "filter by ecs instance name with regular expression  example: ecs.* (can specify multiple, will use or operator)")
+	f.VarP(&driftQueryFlags.TerraformState, "tfstate", "", "local terraform state file (can specify multiple)")
+	f.VarP(&driftQueryFlags.IgnoreKeys, "ignorekey", "", "tag keys with regular expression not reported as unexpected, default ^acs: (can specify multiple)")
+	f.StringVarP(&driftQueryFlags.Cron, "cron", "c", "", "cron scheduler")
+}
@@ -18,6 +18,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
@@ -26,13 +27,28 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/collector"
+	"github.com/allanhung/alicloud-monitoring/pkg/envmap"
+	"github.com/allanhung/alicloud-monitoring/pkg/inventory"
 	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
 	"github.com/allanhung/alicloud-monitoring/pkg/log"
 	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+	"github.com/allanhung/alicloud-monitoring/pkg/notify"
 )
 
 var ecsCmdFlags = alicloud.QueryEcsFlags{}
 
+// untaggedInstances are the untagged instances found by the last query. Nothing is known before the first
+// successful query, so that query only seeds them instead of reporting the whole untagged fleet as new.
+type untaggedInstances struct {
+	instances map[string]ecs.Instance
+	seeded    bool
+}
+
+func newUntaggedInstances() *untaggedInstances {
+	return &untaggedInstances{instances: map[string]ecs.Instance{}}
+}
+
 // ecsCmd represents the ecs command
 var ecsCmd = &cobra.Command{
 	Use:   "ecs",
@@ -43,99 +59,236 @@ example:
   alicloud-monitoring ecs --regname 'worker-k8s.*' --logfile /tmp/ecs_update.log --loglevel debug
   alicloud-monitoring ecs --regname 'worker-k8s.*' --notagk Environment --cron '* * * * * *'`,
 	Run: func(cmd *cobra.Command, args []string) {
-		pm := monitor.NewTagsMonitor()
+		reg := monitor.NewRegistry(runtimeMetrics)
+		var pm *monitor.TagsMonitor
 		var c *cron.Cron
-		jobLock := joblock.JobLock{}
-		instanceList := map[string]ecs.Instance{}
-
-		cfg := &alicloud.AliCloudConfig{}
-		err := cfg.GetCloudConfig()
-		if err != nil {
-			log.Logger.Errorf("failed to getCloudConfigFromStsToken: %v", err)
+		jobLock := &joblock.JobLock{Name: cmd.Use}
+		instanceList := newUntaggedInstances()
+		var inv *inventory.Store
+		ecsCmdFlags.Normalizer = tagNormalizer
+		if err := validateMode(ecsCmdFlags.Mode); err != nil {
+			log.Logger.Errorf("%v", err)
 			os.Exit(1)
 		}
-
-		aliClient, err := alicloud.NewAliClient(cfg)
-		if err != nil {
-			log.Logger.Errorf("failed to create aliClient: %v", err)
-			os.Exit(1)
+		if ecsCmdFlags.Mode != collectorMode {
+			pm = monitor.NewTagsMonitor(reg)
+			if ecsCmdFlags.InventoryFile != "" || ecsCmdFlags.InventoryWebhook != "" {
+				inv = inventory.NewStore(ecsCmdFlags.InventoryFile, ecsCmdFlags.InventoryWebhook, monitor.NewInventoryMonitor(reg))
+			}
 		}
 
-		if ecsCmdFlags.Cron == "" {
-			err := queryECStag(jobLock, aliClient, ecsCmdFlags, pm, instanceList)
+		aliClient := newAliClient(reg)
+		defer aliClient.Close()
+
+		if ecsCmdFlags.Mode == collectorMode {
+			reg.MustRegister(newECStagCollector(cmd.Use, aliClient, ecsCmdFlags))
+		} else if ecsCmdFlags.Cron == "" {
+			err := queryECStag(jobLock, aliClient, ecsCmdFlags, pm, instanceList, inv)
+			monitor.SetJobResult(cmd.Use, err)
 			if err != nil {
 				log.Logger.Errorf("%v", err)
 				os.Exit(1)
 			}
-			log.Logger.Debugf("%v", instanceList)
+			log.Logger.Debugf("%v", instanceList.instances)
 		} else {
 			c = cron.New(cron.WithSeconds())
-			c.AddFunc(ecsCmdFlags.Cron, func() { queryECStag(jobLock, aliClient, ecsCmdFlags, pm, instanceList) })
+			c.AddFunc(ecsCmdFlags.Cron, func() {
+				err := queryECStag(jobLock, aliClient, ecsCmdFlags, pm, instanceList, inv)
+				if err != nil {
+					log.Logger.Errorf("%v", err)
+				}
+				monitor.SetJobResult(cmd.Use, err)
+			})
 			fmt.Printf("start: %v", time.Now())
 			c.Start()
 		}
-		err = monitor.PrometheusBoot()
-		c.Stop()
+		err := monitor.PrometheusBoot(reg, webListenAddress, webConfigFile)
+		if c != nil {
+			c.Stop()
+		}
 		if err != nil {
 			log.Logger.Errorf("failed to ListenAndServe: %v", err)
 			os.Exit(1)
 		}
 	}}
 
-func queryECStag(job joblock.JobLock, aliClient *alicloud.AliClient, queryFlags alicloud.QueryEcsFlags, pm *monitor.TagsMonitor, instanceList map[string]ecs.Instance) error {
+func queryECStag(job *joblock.JobLock, aliClient *alicloud.AliClient, queryFlags alicloud.QueryEcsFlags, pm *monitor.TagsMonitor, instanceList *untaggedInstances, inv *inventory.Store) error {
 
 	if job.IsRunning {
 		return fmt.Errorf("job is still running: %s", job.Kind)
 	} else {
 		job.SetRun("Query")
 	}
+	defer job.DoneRun()
 
-	log.Logger.Infof("Running job: %s", job.Kind)
-	vpcMap, err := getVPCInfo(aliClient, ecsCmdFlags.PageSize)
+	job.Log().Infof("Running job: %s", job.Kind)
+	_, err := queryUntagged(job, aliClient, queryFlags, pm, instanceList, inv)
+	return err
+}
+
+// queryUntagged refreshes instanceList with the instances missing the tag and returns the environments of the vpcs,
+// the caller holds the job lock so the whole run logs one run id.
+func queryUntagged(job *joblock.JobLock, aliClient *alicloud.AliClient, queryFlags alicloud.QueryEcsFlags, pm *monitor.TagsMonitor, instanceList *untaggedInstances, inv *inventory.Store) (map[string]envmap.Environment, error) {
+	vpcMap, err := getVPCInfo(aliClient, queryFlags.PageSize)
 	if err != nil {
-		return fmt.Errorf("failed to get VPC information: %v", err)
+		return nil, fmt.Errorf("failed to get VPC information: %v", err)
 	}
 
-	for k, ecsInstance := range instanceList {
-		pm.NoEnvTag.With(prometheus.Labels{"id": ecsInstance.InstanceId, "vpc": vpcMap[ecsInstance.VpcAttributes.VpcId], "name": ecsInstance.InstanceName}).Set(0)
-		delete(instanceList, k)
+	seen := map[string]bool{}
+	for k := range instanceList.instances {
+		seen[k] = true
 	}
 
-	queryList, err := alicloud.QueryECS(aliClient, queryFlags)
+	// the inventory diffs all instances so an instance that gets tagged is a modification and not a deletion
+	allFlags := queryFlags
+	if inv != nil {
+		allFlags.NoTagKey = nil
+		allFlags.NoTagValue = nil
+	}
+	allInstances, err := alicloud.QueryECS(aliClient, allFlags)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	queryList := allInstances
+	if inv != nil {
+		queryList = []ecs.Instance{}
+		for _, ecsInstance := range allInstances {
+			if alicloud.MatchNoTag(ecsInstance, queryFlags) {
+				queryList = append(queryList, ecsInstance)
+			}
+		}
+	}
+
+	instanceList.instances = map[string]ecs.Instance{}
+	for _, ecsInstance := range queryList {
+		instanceList.instances[ecsInstance.InstanceId] = ecsInstance
+		env := vpcMap[ecsInstance.VpcAttributes.VpcId]
+		pm.NoEnvTagSeries.Set(job.Name, prometheus.Labels{"id": ecsInstance.InstanceId, "vpc": env.Name, "name": ecsInstance.InstanceName}, 1)
+		if env.Resolved {
+			job.Log().Infof("instance: %s (%s) is in environment %s", ecsInstance.InstanceId, ecsInstance.InstanceName, env.Name)
+		} else {
+			pm.UnresolvedEnvSeries.Set(job.Name, prometheus.Labels{"id": ecsInstance.InstanceId, "vpcid": ecsInstance.VpcAttributes.VpcId, "name": ecsInstance.InstanceName}, 1)
+			job.Log().Warnf("instance: %s (%s) in vpc %s has no environment", ecsInstance.InstanceId, ecsInstance.InstanceName, ecsInstance.VpcAttributes.VpcId)
+		}
 	}
+	pm.NoEnvTagSeries.EndRun(job.Name)
+	pm.UnresolvedEnvSeries.EndRun(job.Name)
 
+	newIds := []string{}
+	newLines := []string{}
 	for _, ecsInstance := range queryList {
-		instanceList[ecsInstance.InstanceId] = ecsInstance
-		pm.NoEnvTag.With(prometheus.Labels{"id": ecsInstance.InstanceId, "vpc": vpcMap[ecsInstance.VpcAttributes.VpcId], "name": ecsInstance.InstanceName}).Set(1)
-		log.Logger.Infof("instance: %s (%s) is in environment %s", ecsInstance.InstanceId, ecsInstance.InstanceName, vpcMap[ecsInstance.VpcAttributes.VpcId])
+		if instanceList.seeded && !seen[ecsInstance.InstanceId] {
+			newIds = append(newIds, ecsInstance.InstanceId)
+			newLines = append(newLines, fmt.Sprintf("%s (%s) in %s", ecsInstance.InstanceId, ecsInstance.InstanceName, vpcMap[ecsInstance.VpcAttributes.VpcId].Name))
+		}
+	}
+	if !instanceList.seeded {
+		job.Log().Infof("%d untagged instances recorded as baseline", len(queryList))
 	}
-	job.DoneRun()
-	return nil
+	instanceList.seeded = true
+	if len(newIds) > 0 {
+		err := notifier.Notify(notify.Message{
+			Key:   "untagged/" + strings.Join(newIds, ","),
+			Kind:  "ecs",
+			Title: fmt.Sprintf("%d new instances without tag %s", len(newIds), strings.Join(queryFlags.NoTagKey, ",")),
+			Lines: newLines,
+		})
+		if err != nil {
+			job.Log().Errorf("failed to notify untagged instances: %v", err)
+		}
+	}
+
+	if inv != nil {
+		if _, err := inv.Update(allInstances); err != nil {
+			job.Log().Errorf("failed to update inventory: %v", err)
+		}
+	}
+	return vpcMap, nil
 }
 
-func getVPCInfo(aliClient *alicloud.AliClient, pageSize int) (map[string]string, error) {
-	vpcMap := map[string]string{}
+var (
+	noEnvTagDesc = prometheus.NewDesc(
+		"notag",
+		"No environment tag on ecs instance.",
+		[]string{"id", "vpc", "name"}, nil,
+	)
+	unresolvedEnvDesc = prometheus.NewDesc(
+		"unresolvedenvironment",
+		"Environment of ecs instance could not be resolved from its vpc.",
+		[]string{"id", "vpcid", "name"}, nil,
+	)
+)
+
+// newECStagCollector exposes the same notag and unresolvedenvironment series as queryECStag, but only for instances found by the latest query.
+func newECStagCollector(name string, aliClient *alicloud.AliClient, queryFlags alicloud.QueryEcsFlags) *collector.CachedCollector {
+	return collector.NewCachedCollector(name, queryFlags.CacheTTL, []*prometheus.Desc{noEnvTagDesc, unresolvedEnvDesc}, func() ([]prometheus.Metric, error) {
+		vpcMap, err := getVPCInfo(aliClient, queryFlags.PageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get VPC information: %v", err)
+		}
+		queryList, err := alicloud.QueryECS(aliClient, queryFlags)
+		if err != nil {
+			return nil, err
+		}
+		metrics := []prometheus.Metric{}
+		for _, ecsInstance := range queryList {
+			env := vpcMap[ecsInstance.VpcAttributes.VpcId]
+			metrics = append(metrics, prometheus.MustNewConstMetric(noEnvTagDesc, prometheus.GaugeValue, 1, ecsInstance.InstanceId, env.Name, ecsInstance.InstanceName))
+			if !env.Resolved {
+				metrics = append(metrics, prometheus.MustNewConstMetric(unresolvedEnvDesc, prometheus.GaugeValue, 1, ecsInstance.InstanceId, ecsInstance.VpcAttributes.VpcId, ecsInstance.InstanceName))
+			}
+		}
+		return metrics, nil
+	})
+}
+
+// getVPCInfo resolves the environment of every vpc with envMapper.
+func getVPCInfo(aliClient *alicloud.AliClient, pageSize int) (map[string]envmap.Environment, error) {
+	vpcMap := map[string]envmap.Environment{}
 	allVpcs, err := alicloud.QueryVpc(aliClient, pageSize)
 	if err != nil {
 		return vpcMap, err
 	}
+	vpcTags := map[string]map[string]string{}
+	if envMapper.TagKey() != "" {
+		vpcTags, err = alicloud.QueryVpcTags(aliClient, pageSize)
+		if err != nil {
+			return vpcMap, err
+		}
+	}
 
 	for _, Vpc := range allVpcs {
-		switch Vpc.VpcName {
-		case "dev":
-			vpcMap[Vpc.VpcId] = "develop"
-		default:
-			vpcMap[Vpc.VpcId] = Vpc.VpcName
+		env := envMapper.Resolve(envmap.VPC{ID: Vpc.VpcId, Name: Vpc.VpcName, Tags: vpcTags[Vpc.VpcId]})
+		if !env.Resolved {
+			log.Logger.Warnf("vpc: %s (%s) has no environment mapping", Vpc.VpcId, Vpc.VpcName)
 		}
+		vpcMap[Vpc.VpcId] = env
 	}
 
 	return vpcMap, nil
 }
 
+func ecsServeJob(env *serveEnv, jobCfg serveJobConfig) func() error {
+	queryFlags := jobCfg.queryEcsFlags()
+	if queryFlags.Mode == collectorMode {
+		env.reg.MustRegister(newECStagCollector(jobCfg.Name, env.aliClient, queryFlags))
+		return nil
+	}
+	pm := env.getTagsMonitor()
+	jobLock := &joblock.JobLock{Name: jobCfg.Name}
+	instanceList := newUntaggedInstances()
+	var inv *inventory.Store
+	if queryFlags.InventoryFile != "" || queryFlags.InventoryWebhook != "" {
+		inv = inventory.NewStore(queryFlags.InventoryFile, queryFlags.InventoryWebhook, env.getInventoryMonitor())
+	}
+	return func() error {
+		return queryECStag(jobLock, env.aliClient, queryFlags, pm, instanceList, inv)
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(ecsCmd)
+	serveJobs["ecs"] = ecsServeJob
 	f := ecsCmd.Flags()
 	f.StringVarP(&ecsCmdFlags.InstanceName, "instancename", "n", "", "filter by instance name")
 	f.IntVarP(&ecsCmdFlags.PageSize, "pagesize", "s", 10, "alicloud api pagesize")
@@ -144,4 +297,8 @@ func init() {
 	f.VarP(&ecsCmdFlags.NoTagKey, "notagk", "", "filter by ecs instance tag key not contain keyword with regular expression example: acs:autoscaling.* (can specify multiple)")
 	f.VarP(&ecsCmdFlags.NoTagValue, "notagv", "", "filter by ecs instance tag value not contain keyword with regular expression example: autoScale (can specify multiple)")
 	f.StringVarP(&ecsCmdFlags.Cron, "cron", "c", "", "cron scheduler")
+	f.StringVarP(&ecsCmdFlags.InventoryFile, "inventoryfile", "", "", "persist inventory snapshot to this file and report changes between runs")
+	f.StringVarP(&ecsCmdFlags.Mode, "mode", "", gaugeMode, "gauge: query on cron schedule, collector: query when scraped")
+	f.DurationVarP(&ecsCmdFlags.CacheTTL, "cachettl", "", time.Minute, "how long a query result is reused in collector mode")
+	f.StringVarP(&ecsCmdFlags.InventoryWebhook, "inventorywebhook", "", "", "post inventory change events as json to this url")
 }
@@ -0,0 +1,46 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/viper"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/envmap"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+)
+
+var envMapper *envmap.Mapper
+
+// initEnvMapper builds the vpc to environment mapping from the "environment" section of the config file,
+// without the section the vpc "dev" is mapped to "develop" and every other vpc to its name.
+func initEnvMapper() {
+	cfg := envmap.DefaultConfig()
+	if viper.IsSet("environment") {
+		cfg = envmap.Config{}
+		if err := viper.UnmarshalKey("environment", &cfg); err != nil {
+			log.Logger.Errorf("failed to load environment config: %v", err)
+			os.Exit(1)
+		}
+	}
+	m, err := envmap.NewMapper(cfg)
+	if err != nil {
+		log.Logger.Errorf("failed to create environment mapping: %v", err)
+		os.Exit(1)
+	}
+	envMapper = m
+}
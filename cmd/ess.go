@@ -0,0 +1,380 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ess"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+	"github.com/allanhung/alicloud-monitoring/pkg/notify"
+	"github.com/allanhung/alicloud-monitoring/pkg/plan"
+	"github.com/allanhung/alicloud-monitoring/pkg/types"
+)
+
+// essActivityPageSize is the number of latest scaling activities checked per scaling group.
+const essActivityPageSize = 20
+
+var essQueryFlags = alicloud.QueryEssFlags{}
+
+// essState remembers the failed activities and spot fallbacks already notified between runs
+// and the health and lifecycle states reported for the instances of every scaling group.
+// It only keeps what the latest run still saw.
+type essState struct {
+	initialized      bool
+	failedActivities map[string]bool
+	spotFallbacks    map[string]bool
+	instanceStates   map[string]map[[2]string]bool
+}
+
+func newEssState() *essState {
+	return &essState{
+		failedActivities: map[string]bool{},
+		spotFallbacks:    map[string]bool{},
+		instanceStates:   map[string]map[[2]string]bool{},
+	}
+}
+
+// essCmd represents the ess command
+var essCmd = &cobra.Command{
+	Use:   "ess",
+	Short: "Query auto scaling groups.",
+	Long: `This tool will query auto scaling groups, their configurations, instances and activities,
+report capacity, failed scaling activities and spot instances replaced by pay-as-you-go instances,
+and optionally tag instances with the tags of their scaling group.
+
+example:
+  alicloud-monitoring ess --tag --tagkey Environment,stack
+  alicloud-monitoring ess --cron '0 */5 * * * *'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := essPlanGuard(essQueryFlags).Validate(); err != nil {
+			log.Logger.Errorf("%v", err)
+			os.Exit(1)
+		}
+		reg := monitor.NewRegistry(runtimeMetrics)
+		pm := monitor.NewEssMonitor(reg)
+		am := monitor.NewApprovalMonitor(reg)
+		var c *cron.Cron
+		jobLock := &joblock.JobLock{Name: cmd.Use}
+		state := newEssState()
+
+		aliClient := newAliClient(reg)
+		defer aliClient.Close()
+
+		if essQueryFlags.Cron == "" {
+			err := queryEss(jobLock, aliClient, essQueryFlags, pm, am, state)
+			monitor.SetJobResult(cmd.Use, err)
+			if err != nil {
+				log.Logger.Errorf("%v", err)
+				os.Exit(1)
+			}
+		} else {
+			c = cron.New(cron.WithSeconds())
+			c.AddFunc(essQueryFlags.Cron, func() {
+				err := queryEss(jobLock, aliClient, essQueryFlags, pm, am, state)
+				if err != nil {
+					log.Logger.Errorf("%v", err)
+				}
+				monitor.SetJobResult(cmd.Use, err)
+			})
+			fmt.Printf("start: %v", time.Now())
+			c.Start()
+		}
+		err := monitor.PrometheusBoot(reg, webListenAddress, webConfigFile)
+		if c != nil {
+			c.Stop()
+		}
+		if err != nil {
+			log.Logger.Errorf("failed to ListenAndServe: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func queryEss(job *joblock.JobLock, aliClient *alicloud.AliClient, queryFlags alicloud.QueryEssFlags, pm *monitor.EssMonitor, am *monitor.ApprovalMonitor, state *essState) error {
+	if job.IsRunning {
+		return fmt.Errorf("job is still running: %s", job.Kind)
+	} else {
+		job.SetRun("Checking scaling groups")
+	}
+	defer job.DoneRun()
+	job.Log().Infof("Running job: %s", job.Kind)
+
+	groups, err := alicloud.QueryScalingGroups(aliClient, queryFlags.PageSize)
+	if err != nil {
+		return err
+	}
+	groupIds := []string{}
+	for _, group := range groups {
+		groupIds = append(groupIds, group.ScalingGroupId)
+	}
+	groupTags := map[string]map[string]string{}
+	if queryFlags.TagInstances && len(groupIds) > 0 {
+		groupTags, err = alicloud.QueryScalingGroupTags(aliClient, groupIds)
+		if err != nil {
+			return err
+		}
+	}
+	queryList, err := alicloud.QueryECS(aliClient, alicloud.QueryEcsFlags{PageSize: queryFlags.PageSize})
+	if err != nil {
+		return err
+	}
+	ecsInstances := map[string]ecs.Instance{}
+	for _, instance := range queryList {
+		ecsInstances[instance.InstanceId] = instance
+	}
+
+	failedActivities := map[string]bool{}
+	spotFallbacks := map[string]bool{}
+	instanceStates := map[string]map[[2]string]bool{}
+	newFailed := []string{}
+	newFallbacks := []string{}
+	changes := []plan.Change{}
+	for _, group := range groups {
+		setEssGroupCapacity(job, pm, group)
+
+		configurations, err := alicloud.QueryScalingConfigurations(aliClient, group.ScalingGroupId, queryFlags.PageSize)
+		if err != nil {
+			return err
+		}
+		configMap := map[string]ess.ScalingConfiguration{}
+		for _, config := range configurations {
+			configMap[config.ScalingConfigurationId] = config
+			instanceType := config.InstanceType
+			if instanceType == "" {
+				instanceType = strings.Join(config.InstanceTypes.InstanceType, ",")
+			}
+			active := 0.0
+			if config.ScalingConfigurationId == group.ActiveScalingConfigurationId {
+				active = 1
+			}
+			pm.ConfigurationSeries.Set(job.Name, prometheus.Labels{"groupid": group.ScalingGroupId, "id": config.ScalingConfigurationId, "name": config.ScalingConfigurationName, "type": instanceType, "spotstrategy": config.SpotStrategy}, active)
+		}
+
+		scalingInstances, err := alicloud.QueryScalingInstances(aliClient, group.ScalingGroupId, queryFlags.PageSize)
+		if err != nil {
+			return err
+		}
+		instanceCount := map[[2]string]int{}
+		fallbacks := 0
+		for _, scalingInstance := range scalingInstances {
+			instanceCount[[2]string{scalingInstance.HealthStatus, scalingInstance.LifecycleState}]++
+			ecsInstance, ok := ecsInstances[scalingInstance.InstanceId]
+			if !ok {
+				continue
+			}
+			config, ok := configMap[scalingInstance.ScalingConfigurationId]
+			if ok && strings.HasPrefix(config.SpotStrategy, "Spot") && !isSpot(ecsInstance) {
+				fallbacks++
+				spotFallbacks[scalingInstance.InstanceId] = true
+				if !state.spotFallbacks[scalingInstance.InstanceId] {
+					newFallbacks = append(newFallbacks, fmt.Sprintf("%s (%s) in %s", ecsInstance.InstanceId, ecsInstance.InstanceName, group.ScalingGroupName))
+				}
+			}
+			if queryFlags.TagInstances {
+				if change, ok := scalingInstanceChange(ecsInstance, essInstanceTags(groupTags[group.ScalingGroupId], queryFlags.TagKeys)); ok {
+					changes = append(changes, change)
+				}
+			}
+		}
+		// states the group had instances in before are reported as 0 instead of the last count
+		for key := range state.instanceStates[group.ScalingGroupId] {
+			if _, ok := instanceCount[key]; !ok {
+				instanceCount[key] = 0
+			}
+		}
+		instanceStates[group.ScalingGroupId] = map[[2]string]bool{}
+		for key, count := range instanceCount {
+			instanceStates[group.ScalingGroupId][key] = true
+			pm.InstancesSeries.Set(job.Name, prometheus.Labels{"id": group.ScalingGroupId, "name": group.ScalingGroupName, "health": key[0], "lifecycle": key[1]}, float64(count))
+		}
+		pm.SpotFallbackSeries.Set(job.Name, prometheus.Labels{"id": group.ScalingGroupId, "name": group.ScalingGroupName}, float64(fallbacks))
+
+		activities, err := alicloud.QueryScalingActivities(aliClient, group.ScalingGroupId, essActivityPageSize)
+		if err != nil {
+			return err
+		}
+		failed := 0
+		for _, activity := range activities {
+			if activity.StatusCode != "Failed" {
+				continue
+			}
+			failed++
+			failedActivities[activity.ScalingActivityId] = true
+			if !state.failedActivities[activity.ScalingActivityId] {
+				newFailed = append(newFailed, fmt.Sprintf("%s %s: %s", group.ScalingGroupName, activity.StartTime, activity.StatusMessage))
+				job.Log().Warnf("scaling group: %s (%s) activity %s failed: %s", group.ScalingGroupId, group.ScalingGroupName, activity.ScalingActivityId, activity.StatusMessage)
+			}
+		}
+		pm.FailedSeries.Set(job.Name, prometheus.Labels{"id": group.ScalingGroupId, "name": group.ScalingGroupName}, float64(failed))
+	}
+	pm.GroupCapacitySeries.EndRun(job.Name)
+	pm.ConfigurationSeries.EndRun(job.Name)
+	pm.InstancesSeries.EndRun(job.Name)
+	pm.FailedSeries.EndRun(job.Name)
+	pm.SpotFallbackSeries.EndRun(job.Name)
+
+	// the first run only learns what already happened, later runs notify about new events
+	if state.initialized {
+		notifyEss("failed/", fmt.Sprintf("%d scaling activities failed", len(newFailed)), newFailed)
+		notifyEss("spotfallback/", fmt.Sprintf("%d spot instances replaced by pay-as-you-go instances", len(newFallbacks)), newFallbacks)
+	}
+	state.initialized = true
+	state.failedActivities = failedActivities
+	state.spotFallbacks = spotFallbacks
+	state.instanceStates = instanceStates
+
+	if guardChanges(job, aliClient, essPlanGuard(queryFlags), am, changes) {
+		job.Log().Infof("Job Completed. %d scaling groups", len(groups))
+		return nil
+	}
+	failed := 0
+	for _, change := range changes {
+		if err := applyTagChange(job, aliClient, change, queryFlags.DryRun); err != nil {
+			failed++
+		}
+	}
+	job.Log().Infof("Job Completed. %d scaling groups, %d instances tagged, %d failed", len(groups), len(changes)-failed, failed)
+	return nil
+}
+
+func setEssGroupCapacity(job *joblock.JobLock, pm *monitor.EssMonitor, group ess.ScalingGroup) {
+	capacity := map[string]int{
+		"desired":  group.DesiredCapacity,
+		"total":    group.TotalCapacity,
+		"active":   group.ActiveCapacity,
+		"pending":  group.PendingCapacity,
+		"removing": group.RemovingCapacity,
+		"standby":  group.StandbyCapacity,
+		"stopped":  group.StoppedCapacity,
+		"min":      group.MinSize,
+		"max":      group.MaxSize,
+	}
+	for name, value := range capacity {
+		pm.GroupCapacitySeries.Set(job.Name, prometheus.Labels{"id": group.ScalingGroupId, "name": group.ScalingGroupName, "capacity": name}, float64(value))
+	}
+}
+
+// essInstanceTags returns the scaling group tags to copy to its instances, tagKeys limits the keys,
+// without tagKeys every tag except the reserved acs: and aliyun tags is copied.
+func essInstanceTags(groupTags map[string]string, tagKeys types.ArgList) map[string]string {
+	tags := map[string]string{}
+	for k, v := range groupTags {
+		if len(tagKeys) > 0 {
+			if stringInList(k, tagKeys) {
+				tags[k] = v
+			}
+		} else if !strings.HasPrefix(k, "acs:") && !strings.HasPrefix(k, "aliyun") {
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
+// scalingInstanceChange returns the change adding the tags the instance is missing or has with another value,
+// false when the instance has all tags.
+func scalingInstanceChange(instance ecs.Instance, tags map[string]string) (plan.Change, bool) {
+	current := instanceTags(instance)
+	keys := []string{}
+	for k, v := range tags {
+		if current[k] != v {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return plan.Change{}, false
+	}
+	sort.Strings(keys)
+	ecsTags := []ecs.AddTagsTag{}
+	for _, k := range keys {
+		ecsTags = append(ecsTags, ecs.AddTagsTag{Key: k, Value: tags[k]})
+	}
+	return tagChange(instance, ecsTags), true
+}
+
+func essPlanGuard(queryFlags alicloud.QueryEssFlags) plan.Guard {
+	return plan.Guard{
+		MaxChanges: queryFlags.MaxChanges,
+		Dir:        queryFlags.PlanDir,
+		Webhook:    queryFlags.PlanWebhook,
+	}
+}
+
+func notifyEss(key string, title string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	sort.Strings(lines)
+	err := notifier.Notify(notify.Message{
+		Key:   key + strings.Join(lines, ","),
+		Kind:  "ess",
+		Title: title,
+		Lines: lines,
+	})
+	if err != nil {
+		log.Logger.Errorf("failed to notify scaling events: %v", err)
+	}
+}
+
+func essServeJob(env *serveEnv, jobCfg serveJobConfig) func() error {
+	queryFlags := alicloud.QueryEssFlags{
+		PageSize:     jobCfg.PageSize,
+		TagInstances: jobCfg.TagInstances,
+		TagKeys:      types.ArgList(jobCfg.TagKeys),
+		Cron:         jobCfg.Cron,
+		DryRun:       jobCfg.DryRun,
+		MaxChanges:   jobCfg.MaxChanges,
+		PlanDir:      jobCfg.PlanDir,
+		PlanWebhook:  jobCfg.PlanWebhook,
+	}
+	if err := essPlanGuard(queryFlags).Validate(); err != nil {
+		log.Logger.Errorf("job %s: %v", jobCfg.Name, err)
+		os.Exit(1)
+	}
+	pm := env.getEssMonitor()
+	am := env.getApprovalMonitor()
+	jobLock := &joblock.JobLock{Name: jobCfg.Name}
+	state := newEssState()
+	return func() error {
+		return queryEss(jobLock, env.aliClient, queryFlags, pm, am, state)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(essCmd)
+	serveJobs["ess"] = essServeJob
+	f := essCmd.Flags()
+	f.IntVarP(&essQueryFlags.PageSize, "pagesize", "s", 10, "alicloud api pagesize")
+	f.BoolVarP(&essQueryFlags.TagInstances, "tag", "", false, "tag instances with the tags of their scaling group")
+	f.VarP(&essQueryFlags.TagKeys, "tagkey", "", "scaling group tag copied to instances, default all except acs: and aliyun tags (can specify multiple)")
+	f.StringVarP(&essQueryFlags.Cron, "cron", "c", "", "cron scheduler")
+	f.BoolVarP(&essQueryFlags.DryRun, "dryrun", "", false, "only log and audit the scaling group tags that would be added")
+	f.IntVarP(&essQueryFlags.MaxChanges, "maxchanges", "", 0, "block runs tagging more instances than this until the plan is approved with apply, 0 disables the limit")
+	f.StringVarP(&essQueryFlags.PlanDir, "plandir", "", "", "write plans of blocked runs to this directory")
+	f.StringVarP(&essQueryFlags.PlanWebhook, "planwebhook", "", "", "post plans of blocked runs as json to this url")
+}
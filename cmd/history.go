@@ -0,0 +1,137 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/audit"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+)
+
+var historyFlags = struct {
+	InstanceId string
+	Since      string
+	Until      string
+	Output     string
+}{}
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Query the audit trail.",
+	Long: `This tool will list the write operations recorded in the audit file,
+--since and --until take a RFC3339 time or a duration before now.
+
+example:
+  alicloud-monitoring history --auditfile /var/lib/alicloud-monitoring/audit.jsonl --instanceid i-xxxxxxxx
+  alicloud-monitoring history --since 24h --output json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		path := auditFile
+		if path == "" {
+			path = viper.GetString("audit.file")
+		}
+		if path == "" {
+			log.Logger.Errorf("no audit file, set --auditfile or audit.file in the config file")
+			os.Exit(1)
+		}
+		now := time.Now()
+		since, err := parseHistoryTime(historyFlags.Since, now)
+		if err != nil {
+			log.Logger.Errorf("invalid --since: %v", err)
+			os.Exit(1)
+		}
+		until, err := parseHistoryTime(historyFlags.Until, now)
+		if err != nil {
+			log.Logger.Errorf("invalid --until: %v", err)
+			os.Exit(1)
+		}
+		records, err := audit.Read(path, audit.Filter{ResourceId: historyFlags.InstanceId, Since: since, Until: until})
+		if err != nil {
+			log.Logger.Errorf("%v", err)
+			os.Exit(1)
+		}
+		if err := printHistory(records, historyFlags.Output); err != nil {
+			log.Logger.Errorf("%v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// parseHistoryTime accepts a RFC3339 time or a duration before now, empty means no limit.
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func printHistory(records []audit.Record, output string) error {
+	switch output {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		for _, r := range records {
+			if err := encoder.Encode(r); err != nil {
+				return err
+			}
+		}
+	case "text":
+		for _, r := range records {
+			status := "ok"
+			if r.DryRun {
+				status = "dry run"
+			}
+			if r.Error != "" {
+				status = "failed: " + r.Error
+			}
+			fmt.Printf("%s %s %s %s %s %s -> %s (%s) actor=%s request=%s\n",
+				r.Timestamp.Format(time.RFC3339), r.Account, r.Region, r.ResourceId, r.Operation,
+				formatTags(r.PreviousTags), formatTags(r.NewTags), status, r.Actor, r.RequestId)
+		}
+	default:
+		return fmt.Errorf("unknown output %s, expected text or json", output)
+	}
+	return nil
+}
+
+func formatTags(tags map[string]string) string {
+	pairs := []string{}
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	f := historyCmd.Flags()
+	f.StringVarP(&historyFlags.InstanceId, "instanceid", "i", "", "filter by instance id")
+	f.StringVarP(&historyFlags.Since, "since", "", "", "only records at or after this time")
+	f.StringVarP(&historyFlags.Until, "until", "", "", "only records at or before this time")
+	f.StringVarP(&historyFlags.Output, "output", "o", "text", "output format [text, json]")
+}
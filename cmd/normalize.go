@@ -0,0 +1,226 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+	"github.com/allanhung/alicloud-monitoring/pkg/notify"
+	"github.com/allanhung/alicloud-monitoring/pkg/plan"
+	"github.com/allanhung/alicloud-monitoring/pkg/tagnorm"
+)
+
+var normalizeQueryFlags = alicloud.QueryEcsFlags{}
+
+// normalizeCmd represents the normalize command
+var normalizeCmd = &cobra.Command{
+	Use:     "normalize",
+	Aliases: []string{"normalise"},
+	Short:   "Rewrite ecs tags to their canonical keys and values.",
+	Long: `This tool will rewrite tag key aliases and value synonyms configured in the normalize section of the config file
+to the canonical key and value, e.g. env=prd to Environment=production.
+
+example:
+  alicloud-monitoring normalize --config config.yaml --dryrun
+  alicloud-monitoring normalize --config config.yaml --re 'worker-k8s.*' --cron '0 0 * * * *'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := planGuard(normalizeQueryFlags).Validate(); err != nil {
+			log.Logger.Errorf("%v", err)
+			os.Exit(1)
+		}
+		reg := monitor.NewRegistry(runtimeMetrics)
+		pm := monitor.NewNormalizeMonitor(reg)
+		am := monitor.NewApprovalMonitor(reg)
+		var c *cron.Cron
+		jobLock := &joblock.JobLock{Name: cmd.Use}
+		normalizeQueryFlags.Normalizer = tagNormalizer
+
+		aliClient := newAliClient(reg)
+		defer aliClient.Close()
+
+		if normalizeQueryFlags.Cron == "" {
+			err := normalizeTags(jobLock, aliClient, normalizeQueryFlags, pm, am)
+			monitor.SetJobResult(cmd.Use, err)
+			if err != nil {
+				log.Logger.Errorf("%v", err)
+				os.Exit(1)
+			}
+		} else {
+			c = cron.New(cron.WithSeconds())
+			c.AddFunc(normalizeQueryFlags.Cron, func() {
+				err := normalizeTags(jobLock, aliClient, normalizeQueryFlags, pm, am)
+				if err != nil {
+					log.Logger.Errorf("%v", err)
+				}
+				monitor.SetJobResult(cmd.Use, err)
+			})
+			fmt.Printf("start: %v", time.Now())
+			c.Start()
+		}
+		err := monitor.PrometheusBoot(reg, webListenAddress, webConfigFile)
+		if c != nil {
+			c.Stop()
+		}
+		if err != nil {
+			log.Logger.Errorf("failed to ListenAndServe: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func normalizeTags(job *joblock.JobLock, aliClient *alicloud.AliClient, queryFlags alicloud.QueryEcsFlags, pm *monitor.NormalizeMonitor, am *monitor.ApprovalMonitor) error {
+	if job.IsRunning {
+		return fmt.Errorf("job is still running: %s", job.Kind)
+	} else {
+		job.SetRun("Tag normalization")
+	}
+	defer job.DoneRun()
+	job.Log().Infof("Running job: %s", job.Kind)
+
+	instances, err := alicloud.QueryECS(aliClient, queryFlags)
+	if err != nil {
+		return err
+	}
+	state, err := loadManagedInstances(queryFlags)
+	if err != nil {
+		return err
+	}
+	changes := []plan.Change{}
+	managedLines := []string{}
+	for _, instance := range instances {
+		tags := instanceTags(instance)
+		rewrite := queryFlags.Normalizer.Plan(tags)
+		for key, conflict := range rewrite.Conflicts {
+			pm.TagConflictSeries.Set(job.Name, prometheus.Labels{"id": instance.InstanceId, "name": instance.InstanceName, "key": key}, 1)
+			job.Log().WithField(log.FieldInstanceID, instance.InstanceId).Warnf("instance: %s (%s) tag %s not normalized, %s", instance.InstanceId, instance.InstanceName, key, conflict)
+		}
+		if rewrite.Empty() {
+			continue
+		}
+		for _, key := range nonCanonicalKeys(tags, rewrite) {
+			pm.NonCanonicalTagSeries.Set(job.Name, prometheus.Labels{"id": instance.InstanceId, "name": instance.InstanceName, "key": key}, 1)
+		}
+		change := plan.Change{
+			InstanceId:   instance.InstanceId,
+			InstanceName: instance.InstanceName,
+			PreviousTags: tags,
+			Add:          rewrite.Add,
+			Remove:       rewrite.Remove,
+		}
+		if skip, line := skipManaged(job, state, queryFlags, instance.InstanceId, instance.InstanceName, change.String()); skip {
+			if line != "" {
+				managedLines = append(managedLines, line)
+			}
+			continue
+		}
+		changes = append(changes, change)
+	}
+	notifyManaged(job, "normalize", managedLines)
+	pm.NonCanonicalTagSeries.EndRun(job.Name)
+	pm.TagConflictSeries.EndRun(job.Name)
+	if guardChanges(job, aliClient, planGuard(queryFlags), am, changes) {
+		return nil
+	}
+
+	changedIds := []string{}
+	changedLines := []string{}
+	failed := []string{}
+	for _, change := range changes {
+		line := fmt.Sprintf("%s (%s) %s", change.InstanceId, change.InstanceName, change)
+		if err := applyTagChange(job, aliClient, change, queryFlags.DryRun); err != nil {
+			line = fmt.Sprintf("%s failed: %v", line, err)
+			failed = append(failed, fmt.Sprintf("%s: %v", change.InstanceId, err))
+		} else if queryFlags.DryRun {
+			line = line + " (dry run)"
+		}
+		changedIds = append(changedIds, change.InstanceId)
+		changedLines = append(changedLines, line)
+	}
+
+	if len(changedIds) > 0 {
+		sort.Strings(changedIds)
+		sort.Strings(changedLines)
+		err := notifier.Notify(notify.Message{
+			Key:   "normalized/" + strings.Join(changedIds, ","),
+			Kind:  "normalize",
+			Title: fmt.Sprintf("%d instances with normalized tags", len(changedIds)),
+			Lines: changedLines,
+		})
+		if err != nil {
+			job.Log().Errorf("failed to notify normalized instances: %v", err)
+		}
+	}
+	job.Log().Infof("Job Completed. %d of %d instances normalized", len(changedIds)-len(failed), len(instances))
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("%d of %d changes failed: %s", len(failed), len(changes), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// nonCanonicalKeys returns the keys of tags that are an alias or have a non-canonical value.
+func nonCanonicalKeys(tags map[string]string, rewrite tagnorm.Plan) []string {
+	keys := append([]string{}, rewrite.Remove...)
+	for key := range rewrite.Add {
+		if _, ok := tags[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func normalizeServeJob(env *serveEnv, jobCfg serveJobConfig) func() error {
+	queryFlags := jobCfg.queryEcsFlags()
+	if err := planGuard(queryFlags).Validate(); err != nil {
+		log.Logger.Errorf("job %s: %v", jobCfg.Name, err)
+		os.Exit(1)
+	}
+	pm := env.getNormalizeMonitor()
+	am := env.getApprovalMonitor()
+	jobLock := &joblock.JobLock{Name: jobCfg.Name}
+	return func() error {
+		return normalizeTags(jobLock, env.aliClient, queryFlags, pm, am)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(normalizeCmd)
+	serveJobs["normalize"] = normalizeServeJob
+	f := normalizeCmd.Flags()
+	f.StringVarP(&normalizeQueryFlags.InstanceName, "instancename", "n", "", "filter by instance name")
+	f.IntVarP(&normalizeQueryFlags.PageSize, "pagesize", "s", 10, "alicloud api pagesize")
+	f.VarP(&normalizeQueryFlags.Tag, "tag", "t", "filter by ecs instance tag example: cluster=prod (can specify multiple)")
+	f.VarP(&normalizeQueryFlags.ReName, "re", "", "filter by ecs instance name with regular expression  example: ecs.* (can specify multiple, will use or operator)")
+	f.StringVarP(&normalizeQueryFlags.Cron, "cron", "c", "", "cron scheduler")
+	f.BoolVarP(&normalizeQueryFlags.DryRun, "dryrun", "", false, "only log and audit the tags that would be rewritten")
+	f.IntVarP(&normalizeQueryFlags.MaxChanges, "maxchanges", "", 0, "block runs rewriting tags of more instances than this until the plan is approved with apply, 0 disables the limit")
+	f.StringVarP(&normalizeQueryFlags.PlanDir, "plandir", "", "", "write plans of blocked runs to this directory")
+	f.StringVarP(&normalizeQueryFlags.PlanWebhook, "planwebhook", "", "", "post plans of blocked runs as json to this url")
+	f.VarP(&normalizeQueryFlags.TerraformState, "tfstate", "", "local terraform state file, instances declared in it are not changed (can specify multiple)")
+	f.StringVarP(&normalizeQueryFlags.IacMode, "iacmode", "", "skip", "skip: leave terraform managed instances out, report: also log and notify the tags that would be rewritten")
+}
@@ -0,0 +1,42 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/viper"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/notify"
+)
+
+var notifier *notify.Notifier
+
+// initNotifier builds the notifier from the "notify" section of the config file.
+func initNotifier() {
+	cfg := notify.Config{}
+	if err := viper.UnmarshalKey("notify", &cfg); err != nil {
+		log.Logger.Errorf("failed to load notify config: %v", err)
+		os.Exit(1)
+	}
+	n, err := notify.NewNotifier(cfg)
+	if err != nil {
+		log.Logger.Errorf("failed to create notifier: %v", err)
+		os.Exit(1)
+	}
+	notifier = n
+}
@@ -0,0 +1,255 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+	"github.com/allanhung/alicloud-monitoring/pkg/operator"
+	"github.com/allanhung/alicloud-monitoring/pkg/plan"
+)
+
+var operatorQueryFlags = alicloud.QueryEcsFlags{}
+
+var operatorFlags = struct {
+	Kubeconfig string
+	Namespace  string
+	Resync     time.Duration
+}{}
+
+// operatorCmd represents the operator command
+var operatorCmd = &cobra.Command{
+	Use:   "operator",
+	Short: "Reconcile ecs tags declared by TagPolicy resources.",
+	Long: `This tool will watch TagPolicy resources in kubernetes, apply their tags to the selected ecs instances
+when a policy changes and on its schedule, and write the result to the status of the policy.
+
+example:
+  alicloud-monitoring operator
+  alicloud-monitoring operator --kubeconfig ~/.kube/config --namespace infra --maxchanges 20 --plandir /var/lib/alicloud-monitoring/plans`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := planGuard(operatorQueryFlags).Validate(); err != nil {
+			log.Logger.Errorf("%v", err)
+			os.Exit(1)
+		}
+		reg := monitor.NewRegistry(runtimeMetrics)
+		om := monitor.NewOperatorMonitor(reg)
+		am := monitor.NewApprovalMonitor(reg)
+		operatorQueryFlags.Normalizer = tagNormalizer
+
+		config, err := clientcmd.BuildConfigFromFlags("", operatorFlags.Kubeconfig)
+		if err != nil {
+			log.Logger.Errorf("failed to load kubernetes config: %v", err)
+			os.Exit(1)
+		}
+		client, err := dynamic.NewForConfig(config)
+		if err != nil {
+			log.Logger.Errorf("failed to create kubernetes client: %v", err)
+			os.Exit(1)
+		}
+
+		aliClient := newAliClient(reg)
+		defer aliClient.Close()
+
+		reconciler := &tagPolicyReconciler{
+			aliClient:  aliClient,
+			queryFlags: operatorQueryFlags,
+			om:         om,
+			am:         am,
+		}
+		stopCh := make(chan struct{})
+		go func() {
+			err := operator.New(client, operatorFlags.Namespace, operatorFlags.Resync, reconciler).Run(stopCh)
+			monitor.SetJobResult(cmd.Use, err)
+			if err != nil {
+				log.Logger.Errorf("%v", err)
+			}
+		}()
+		err = monitor.PrometheusBoot(reg, webListenAddress, webConfigFile)
+		close(stopCh)
+		if err != nil {
+			log.Logger.Errorf("failed to ListenAndServe: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// tagPolicyReconciler applies the tags of a TagPolicy with the same guard, audit and terraform checks as the other tag jobs.
+type tagPolicyReconciler struct {
+	aliClient  *alicloud.AliClient
+	queryFlags alicloud.QueryEcsFlags
+	om         *monitor.OperatorMonitor
+	am         *monitor.ApprovalMonitor
+}
+
+func (r *tagPolicyReconciler) Reconcile(policy *operator.TagPolicy) (operator.Result, error) {
+	result, err := r.reconcile(policy)
+	r.om.PolicyMatched.WithLabelValues(policy.Namespace, policy.Name).Set(float64(result.Matched))
+	r.om.PolicyChanged.WithLabelValues(policy.Namespace, policy.Name).Set(float64(result.Changed))
+	if err != nil {
+		r.om.PolicyErrors.WithLabelValues(policy.Namespace, policy.Name).Inc()
+	}
+	return result, err
+}
+
+// Delete removes the series of a deleted policy.
+func (r *tagPolicyReconciler) Delete(namespace string, name string) {
+	r.om.PolicyMatched.DeleteLabelValues(namespace, name)
+	r.om.PolicyChanged.DeleteLabelValues(namespace, name)
+	r.om.PolicyErrors.DeleteLabelValues(namespace, name)
+}
+
+func (r *tagPolicyReconciler) reconcile(policy *operator.TagPolicy) (operator.Result, error) {
+	result := operator.Result{}
+	job := &joblock.JobLock{Name: "tagpolicy/" + policy.Key()}
+	job.SetRun("Reconcile TagPolicy")
+	defer job.DoneRun()
+	job.Log().Infof("Running job: %s", job.Kind)
+
+	if len(policy.Spec.Tags) == 0 {
+		return result, fmt.Errorf("spec.tags is empty")
+	}
+	selector := policy.Spec.Selector
+	if len(selector.InstanceIds) == 0 && len(selector.NamePatterns) == 0 && len(selector.Tags) == 0 {
+		return result, fmt.Errorf("spec.selector is empty, it would select every instance")
+	}
+	for _, pattern := range policy.Spec.Selector.NamePatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return result, fmt.Errorf("invalid name pattern %s: %v", pattern, err)
+		}
+	}
+	queryFlags := r.queryFlags
+	queryFlags.DryRun = queryFlags.DryRun || policy.Spec.DryRun
+	result.DryRun = queryFlags.DryRun
+	queryFlags.ReName = append([]string{}, policy.Spec.Selector.NamePatterns...)
+	queryFlags.Tag = nil
+	for key, value := range policy.Spec.Selector.Tags {
+		queryFlags.Tag = append(queryFlags.Tag, key+"="+value)
+	}
+	instances, err := alicloud.QueryECS(r.aliClient, queryFlags)
+	if err != nil {
+		return result, err
+	}
+	instances = selectInstanceIds(instances, policy.Spec.Selector.InstanceIds)
+	result.Matched = len(instances)
+	state, err := loadManagedInstances(queryFlags)
+	if err != nil {
+		return result, err
+	}
+
+	ecsTags := policyTags(policy.Spec.Tags)
+	changes := []plan.Change{}
+	managedLines := []string{}
+	for _, instance := range instances {
+		current := instanceTags(instance)
+		missing := []ecs.AddTagsTag{}
+		for _, tag := range ecsTags {
+			if value, ok := current[tag.Key]; !ok || value != tag.Value {
+				missing = append(missing, tag)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		change := tagChange(instance, missing)
+		if skip, line := skipManaged(job, state, queryFlags, instance.InstanceId, instance.InstanceName, change.String()); skip {
+			if line != "" {
+				managedLines = append(managedLines, line)
+			}
+			continue
+		}
+		changes = append(changes, change)
+	}
+	notifyManaged(job, "tagpolicy", managedLines)
+	if guardChanges(job, r.aliClient, planGuard(queryFlags), r.am, changes) {
+		return result, fmt.Errorf("%d changes exceed the maximum of %d, waiting for approval", len(changes), queryFlags.MaxChanges)
+	}
+
+	failed := []string{}
+	for _, change := range changes {
+		if err := applyTagChange(job, r.aliClient, change, queryFlags.DryRun); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", change.InstanceId, err))
+			continue
+		}
+		result.Changed++
+	}
+	job.Log().Infof("Job Completed. %d of %d instances changed", result.Changed, result.Matched)
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return result, fmt.Errorf("%d of %d changes failed: %s", len(failed), len(changes), strings.Join(failed, "; "))
+	}
+	return result, nil
+}
+
+// selectInstanceIds keeps the instances in ids, all instances when ids is empty.
+func selectInstanceIds(instances []ecs.Instance, ids []string) []ecs.Instance {
+	if len(ids) == 0 {
+		return instances
+	}
+	selected := map[string]bool{}
+	for _, id := range ids {
+		selected[id] = true
+	}
+	filtered := []ecs.Instance{}
+	for _, instance := range instances {
+		if selected[instance.InstanceId] {
+			filtered = append(filtered, instance)
+		}
+	}
+	return filtered
+}
+
+// policyTags returns the desired tags with canonical keys and values, sorted by key.
+func policyTags(tags map[string]string) []ecs.AddTagsTag {
+	ecsTags := []ecs.AddTagsTag{}
+	for key, value := range tags {
+		key = tagNormalizer.CanonicalKey(key)
+		ecsTags = append(ecsTags, ecs.AddTagsTag{Key: key, Value: tagNormalizer.CanonicalValue(key, value)})
+	}
+	sort.Slice(ecsTags, func(i, j int) bool {
+		return ecsTags[i].Key < ecsTags[j].Key
+	})
+	return ecsTags
+}
+
+func init() {
+	rootCmd.AddCommand(operatorCmd)
+	f := operatorCmd.Flags()
+	f.StringVarP(&operatorFlags.Kubeconfig, "kubeconfig", "", "", "kubeconfig file, the in-cluster config is used when empty")
+	f.StringVarP(&operatorFlags.Namespace, "namespace", "", "", "watch TagPolicy resources in this namespace, all namespaces when empty")
+	f.DurationVarP(&operatorFlags.Resync, "resync", "", 10*time.Minute, "interval to resync the TagPolicy resources from the api server")
+	f.IntVarP(&operatorQueryFlags.PageSize, "pagesize", "s", 10, "alicloud api pagesize")
+	f.BoolVarP(&operatorQueryFlags.DryRun, "dryrun", "", false, "only log and audit the tags that would be added, regardless of the policies")
+	f.IntVarP(&operatorQueryFlags.MaxChanges, "maxchanges", "", 0, "block reconciles tagging more instances than this until the plan is approved with apply, 0 disables the limit")
+	f.StringVarP(&operatorQueryFlags.PlanDir, "plandir", "", "", "write plans of blocked reconciles to this directory")
+	f.StringVarP(&operatorQueryFlags.PlanWebhook, "planwebhook", "", "", "post plans of blocked reconciles as json to this url")
+	f.VarP(&operatorQueryFlags.TerraformState, "tfstate", "", "local terraform state file, instances declared in it are not tagged (can specify multiple)")
+	f.StringVarP(&operatorQueryFlags.IacMode, "iacmode", "", "skip", "skip: leave terraform managed instances out, report: also log and notify the tags they are missing")
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+	"github.com/allanhung/alicloud-monitoring/pkg/operator"
+	"github.com/allanhung/alicloud-monitoring/pkg/plan"
+)
+
+func TestMain(m *testing.M) {
+	log.InitLogger("error", "", "text", log.Rotation{})
+	os.Exit(m.Run())
+}
+
+func TestReconcileEmptySelector(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	r := &tagPolicyReconciler{
+		om: monitor.NewOperatorMonitor(reg),
+		am: monitor.NewApprovalMonitor(reg),
+	}
+	policy := &operator.TagPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "infra", Name: "all"},
+		Spec:       operator.TagPolicySpec{Tags: map[string]string{"role": "worker"}},
+	}
+	// the reconciler has no client, it must refuse the policy before querying instances
+	result, err := r.Reconcile(policy)
+	if err == nil {
+		t.Fatalf("Reconcile() of a policy without selector did not fail")
+	}
+	if result.Matched != 0 || result.Changed != 0 {
+		t.Errorf("Reconcile() = %+v, want nothing matched", result)
+	}
+}
+
+func TestGuardChangesPlanDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plans")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	job := &joblock.JobLock{Name: "tagpolicy/infra/k8s-workers"}
+	guard := plan.Guard{MaxChanges: 1, Dir: filepath.Join(dir, "pending")}
+	am := monitor.NewApprovalMonitor(prometheus.NewRegistry())
+	changes := []plan.Change{
+		{InstanceId: "i-1", PreviousTags: map[string]string{}, Add: map[string]string{"role": "worker"}},
+		{InstanceId: "i-2", PreviousTags: map[string]string{}, Add: map[string]string{"role": "worker"}},
+	}
+	defer func() {
+		pendingPlans.Lock()
+		delete(pendingPlans.plans, job.Name)
+		pendingPlans.Unlock()
+	}()
+
+	if !guardChanges(job, &alicloud.AliClient{}, guard, am, changes) {
+		t.Fatalf("guardChanges() = false, want the run blocked")
+	}
+	files, err := filepath.Glob(filepath.Join(guard.Dir, "*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("plan files = %v, %v, want one plan in %s", files, err, guard.Dir)
+	}
+	p, err := plan.Load(files[0])
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if p.Job != job.Name || p.Status != plan.StatusPending || len(p.Changes) != 2 {
+		t.Errorf("Load() = %+v, want a pending plan of %s with 2 changes", p, job.Name)
+	}
+
+	// blocked again with the same changes, the pending plan is kept
+	if !guardChanges(job, &alicloud.AliClient{}, guard, am, changes) {
+		t.Fatalf("guardChanges() = false, want the run blocked")
+	}
+	if files, _ := filepath.Glob(filepath.Join(guard.Dir, "*.json")); len(files) != 1 {
+		t.Errorf("plan files = %v, want the pending plan only", files)
+	}
+}
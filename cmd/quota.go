@@ -0,0 +1,274 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/collector"
+	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+	"github.com/allanhung/alicloud-monitoring/pkg/types"
+)
+
+var quotaQueryFlags = alicloud.QueryQuotaFlags{}
+
+func isSpot(instance ecs.Instance) bool {
+	return strings.HasPrefix(instance.SpotStrategy, "Spot")
+}
+
+// quotaUsage derives the usage of an account attribute from the instance inventory.
+var quotaUsage = map[string]func(instances []ecs.Instance) float64{
+	"max-spot-instances": func(instances []ecs.Instance) float64 {
+		used := 0
+		for _, instance := range instances {
+			if isSpot(instance) {
+				used++
+			}
+		}
+		return float64(used)
+	},
+	"max-spot-instance-vcpu-count": func(instances []ecs.Instance) float64 {
+		used := 0
+		for _, instance := range instances {
+			if isSpot(instance) {
+				used += instance.Cpu
+			}
+		}
+		return float64(used)
+	},
+	"max-postpaid-instance-vcpu-count": func(instances []ecs.Instance) float64 {
+		used := 0
+		for _, instance := range instances {
+			if instance.InstanceChargeType == "PostPaid" && !isSpot(instance) {
+				used += instance.Cpu
+			}
+		}
+		return float64(used)
+	},
+}
+
+func quotaNames() []string {
+	names := []string{}
+	for name := range quotaUsage {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type quotaState struct {
+	name  string
+	limit float64
+	used  float64
+}
+
+func (q quotaState) utilization() float64 {
+	if q.limit <= 0 {
+		return 0
+	}
+	return q.used / q.limit
+}
+
+// quotaCmd represents the quota command
+var quotaCmd = &cobra.Command{
+	Use:   "quota",
+	Short: "Query ecs account quota usage.",
+	Long: `This tool will compare ecs account quotas with the usage derived from the instance inventory.
+
+example:
+  alicloud-monitoring quota --quota max-spot-instances,max-postpaid-instance-vcpu-count
+  alicloud-monitoring quota --cron '0 */10 * * * *'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		reg := monitor.NewRegistry(runtimeMetrics)
+		var pm *monitor.QuotaMonitor
+		var c *cron.Cron
+		jobLock := &joblock.JobLock{Name: cmd.Use}
+
+		if err := validateMode(quotaQueryFlags.Mode); err != nil {
+			log.Logger.Errorf("%v", err)
+			os.Exit(1)
+		}
+		if len(quotaQueryFlags.Quotas) == 0 {
+			quotaQueryFlags.Quotas = append(quotaQueryFlags.Quotas, quotaNames()...)
+		}
+		if quotaQueryFlags.Mode != collectorMode {
+			pm = monitor.NewQuotaMonitor(reg)
+		}
+		aliClient := newAliClient(reg)
+		defer aliClient.Close()
+
+		if quotaQueryFlags.Mode == collectorMode {
+			reg.MustRegister(newQuotaCollector(cmd.Use, aliClient, quotaQueryFlags))
+		} else if quotaQueryFlags.Cron == "" {
+			err := queryQuota(jobLock, aliClient, quotaQueryFlags, pm)
+			monitor.SetJobResult(cmd.Use, err)
+			if err != nil {
+				log.Logger.Errorf("%v", err)
+				os.Exit(1)
+			}
+		} else {
+			c = cron.New(cron.WithSeconds())
+			c.AddFunc(quotaQueryFlags.Cron, func() {
+				err := queryQuota(jobLock, aliClient, quotaQueryFlags, pm)
+				if err != nil {
+					log.Logger.Errorf("%v", err)
+				}
+				monitor.SetJobResult(cmd.Use, err)
+			})
+			fmt.Printf("start: %v", time.Now())
+			c.Start()
+		}
+		err := monitor.PrometheusBoot(reg, webListenAddress, webConfigFile)
+		if c != nil {
+			c.Stop()
+		}
+		if err != nil {
+			log.Logger.Errorf("failed to ListenAndServe: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func getQuotaStates(aliClient *alicloud.AliClient, queryFlags alicloud.QueryQuotaFlags) ([]quotaState, error) {
+	for _, name := range queryFlags.Quotas {
+		if _, ok := quotaUsage[name]; !ok {
+			return nil, fmt.Errorf("unknown quota: %s, must be one of %s", name, strings.Join(quotaNames(), ", "))
+		}
+	}
+	limits, err := alicloud.QueryAccountAttributes(aliClient, queryFlags.Quotas)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := alicloud.QueryECS(aliClient, alicloud.QueryEcsFlags{PageSize: queryFlags.PageSize})
+	if err != nil {
+		return nil, err
+	}
+	states := []quotaState{}
+	for _, name := range queryFlags.Quotas {
+		limit, ok := limits[name]
+		if !ok {
+			log.Logger.Warnf("quota %s not returned for region %s", name, aliClient.Region())
+			continue
+		}
+		state := quotaState{name: name, limit: limit, used: quotaUsage[name](instances)}
+		log.Logger.Debugf("quota: %s, region: %s, used: %v, limit: %v", name, aliClient.Region(), state.used, state.limit)
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+func queryQuota(job *joblock.JobLock, aliClient *alicloud.AliClient, queryFlags alicloud.QueryQuotaFlags, pm *monitor.QuotaMonitor) error {
+	if job.IsRunning {
+		return fmt.Errorf("job is still running: %s", job.Kind)
+	} else {
+		job.SetRun("Checking quota")
+	}
+	defer job.DoneRun()
+	job.Log().Infof("Running job: %s", job.Kind)
+
+	states, err := getQuotaStates(aliClient, queryFlags)
+	if err != nil {
+		return err
+	}
+	for _, state := range states {
+		labels := prometheus.Labels{"region": aliClient.Region(), "quota": state.name}
+		pm.QuotaLimit.With(labels).Set(state.limit)
+		pm.QuotaUsed.With(labels).Set(state.used)
+		pm.QuotaUtilization.With(labels).Set(state.utilization())
+	}
+	job.Log().Infof("Job Completed.")
+	return nil
+}
+
+var (
+	quotaLimitDesc = prometheus.NewDesc(
+		"quotalimit",
+		"Limit of ecs account quota.",
+		[]string{"region", "quota"}, nil,
+	)
+	quotaUsedDesc = prometheus.NewDesc(
+		"quotaused",
+		"Usage of ecs account quota derived from the instance inventory.",
+		[]string{"region", "quota"}, nil,
+	)
+	quotaUtilDesc = prometheus.NewDesc(
+		"quotautilization",
+		"Ratio of usage to limit of ecs account quota.",
+		[]string{"region", "quota"}, nil,
+	)
+)
+
+// newQuotaCollector exposes the same quota series as queryQuota, queried when scraped.
+func newQuotaCollector(name string, aliClient *alicloud.AliClient, queryFlags alicloud.QueryQuotaFlags) *collector.CachedCollector {
+	return collector.NewCachedCollector(name, queryFlags.CacheTTL, []*prometheus.Desc{quotaLimitDesc, quotaUsedDesc, quotaUtilDesc}, func() ([]prometheus.Metric, error) {
+		states, err := getQuotaStates(aliClient, queryFlags)
+		if err != nil {
+			return nil, err
+		}
+		metrics := []prometheus.Metric{}
+		for _, state := range states {
+			metrics = append(metrics, prometheus.MustNewConstMetric(quotaLimitDesc, prometheus.GaugeValue, state.limit, aliClient.Region(), state.name))
+			metrics = append(metrics, prometheus.MustNewConstMetric(quotaUsedDesc, prometheus.GaugeValue, state.used, aliClient.Region(), state.name))
+			metrics = append(metrics, prometheus.MustNewConstMetric(quotaUtilDesc, prometheus.GaugeValue, state.utilization(), aliClient.Region(), state.name))
+		}
+		return metrics, nil
+	})
+}
+
+func quotaServeJob(env *serveEnv, jobCfg serveJobConfig) func() error {
+	queryFlags := alicloud.QueryQuotaFlags{
+		PageSize: jobCfg.PageSize,
+		Quotas:   types.ArgList(jobCfg.Quotas),
+		Cron:     jobCfg.Cron,
+		Mode:     jobCfg.Mode,
+		CacheTTL: jobCfg.CacheTTL,
+	}
+	if len(queryFlags.Quotas) == 0 {
+		queryFlags.Quotas = append(queryFlags.Quotas, quotaNames()...)
+	}
+	if queryFlags.Mode == collectorMode {
+		env.reg.MustRegister(newQuotaCollector(jobCfg.Name, env.aliClient, queryFlags))
+		return nil
+	}
+	pm := env.getQuotaMonitor()
+	jobLock := &joblock.JobLock{Name: jobCfg.Name}
+	return func() error {
+		return queryQuota(jobLock, env.aliClient, queryFlags, pm)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(quotaCmd)
+	serveJobs["quota"] = quotaServeJob
+	f := quotaCmd.Flags()
+	f.IntVarP(&quotaQueryFlags.PageSize, "pagesize", "s", 10, "alicloud api pagesize")
+	f.VarP(&quotaQueryFlags.Quotas, "quota", "q", fmt.Sprintf("quota to check, default all of: %s (can specify multiple)", strings.Join(quotaNames(), ", ")))
+	f.StringVarP(&quotaQueryFlags.Cron, "cron", "c", "", "cron scheduler")
+	f.StringVarP(&quotaQueryFlags.Mode, "mode", "", gaugeMode, "gauge: query on cron schedule, collector: query when scraped")
+	f.DurationVarP(&quotaQueryFlags.CacheTTL, "cachettl", "", time.Minute, "how long a query result is reused in collector mode")
+}
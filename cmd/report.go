@@ -0,0 +1,91 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/compliance"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+)
+
+var reportQueryFlags = alicloud.QueryEcsFlags{}
+
+var reportFlags = struct {
+	Format string
+	Output string
+}{}
+
+// reportCmd represents the report command
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Render a tag compliance report.",
+	Long: `This tool will render the tag compliance of ecs instances grouped by team and vpc,
+the schema and the team tags are taken from the compliance section of the config file.
+
+example:
+  alicloud-monitoring report --format html --output /tmp/compliance.html
+  alicloud-monitoring report --format csv --re 'worker-k8s.*' > compliance.csv`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := compliance.ValidateFormat(reportFlags.Format); err != nil {
+			log.Logger.Errorf("%v", err)
+			os.Exit(1)
+		}
+		schema, err := newComplianceSchema()
+		if err != nil {
+			log.Logger.Errorf("%v", err)
+			os.Exit(1)
+		}
+		reportQueryFlags.Normalizer = tagNormalizer
+		aliClient := newAliClient(monitor.NewRegistry(false))
+		defer aliClient.Close()
+
+		results, err := evaluateCompliance(aliClient, reportQueryFlags, schema)
+		if err != nil {
+			log.Logger.Errorf("%v", err)
+			os.Exit(1)
+		}
+		var w io.Writer = os.Stdout
+		if reportFlags.Output != "" {
+			file, err := os.Create(reportFlags.Output)
+			if err != nil {
+				log.Logger.Errorf("failed to create report: %v", err)
+				os.Exit(1)
+			}
+			defer file.Close()
+			w = file
+		}
+		if err := compliance.Render(w, reportFlags.Format, compliance.NewReport(results)); err != nil {
+			log.Logger.Errorf("failed to render report: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	f := reportCmd.Flags()
+	f.IntVarP(&reportQueryFlags.PageSize, "pagesize", "s", 10, "alicloud api pagesize")
+	f.VarP(&reportQueryFlags.Tag, "tag", "t", "filter by ecs instance tag example: cluster=prod (can specify multiple)")
+	f.VarP(&reportQueryFlags.ReName, "re", "", "filter by ecs instance name with regular expression  example: ecs.* (can specify multiple, will use or operator)")
+	f.StringVarP(&reportFlags.Format, "format", "f", compliance.FormatMarkdown, "report format [html, markdown, csv]")
+	f.StringVarP(&reportFlags.Output, "output", "o", "", "write the report to this file instead of stdout")
+}
@@ -23,12 +23,19 @@ import (
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
 
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
 	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
 )
 
 var cfgFile string
 var logLevel string
 var logFile string
+var logFormat string
+var logRotation log.Rotation
+var webListenAddress string
+var webConfigFile string
+var runtimeMetrics bool
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -52,6 +59,10 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 	cobra.OnInitialize(initLogger)
+	cobra.OnInitialize(initNotifier)
+	cobra.OnInitialize(initEnvMapper)
+	cobra.OnInitialize(initTagNormalizer)
+	cobra.OnInitialize(initAudit)
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
@@ -59,7 +70,19 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.ali-ecs-tag-update.yaml)")
 	rootCmd.PersistentFlags().StringVar(&logFile, "logfile", "", "log file")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "logformat", "text", "log format [text, json]")
+	rootCmd.PersistentFlags().IntVar(&logRotation.MaxSize, "logmaxsize", 0, "rotate the log file when it reaches this size in megabytes, 0 disables rotation")
+	rootCmd.PersistentFlags().IntVar(&logRotation.MaxAge, "logmaxage", 0, "days to keep rotated log files, 0 keeps them all")
+	rootCmd.PersistentFlags().IntVar(&logRotation.MaxBackups, "logmaxbackups", 0, "number of rotated log files to keep, 0 keeps them all")
 	rootCmd.PersistentFlags().StringVar(&logLevel, "loglevel", "info", "log level  [trace, debug, info, warn, error, fatal, panic] (default info)")
+	rootCmd.PersistentFlags().StringVar(&auditFile, "auditfile", "", "append an audit record of every write operation to this json lines file (overrides audit.file of the config file)")
+	rootCmd.PersistentFlags().StringVar(&alicloud.CredentialFile, "credentialfile", "", "json or yaml file with regionId, accessKeyId and accessKeySecret")
+	rootCmd.PersistentFlags().StringVar(&alicloud.Profile, "profile", "", "aliyun cli profile in ~/.aliyun/config.json (default is the current profile)")
+	rootCmd.PersistentFlags().Float64Var(&alicloud.RefreshFraction, "refreshfraction", 0.8, "refresh expiring credentials after this fraction of their lifetime, between 0 and 1")
+	rootCmd.PersistentFlags().StringVar(&webListenAddress, "web.listen-address", "0.0.0.0:9085", "address to expose metrics and health endpoints on")
+	rootCmd.PersistentFlags().IntVar(&monitor.StaleRuns, "staleruns", 3, "delete series of instances, zones and types missing for this many consecutive runs")
+	rootCmd.PersistentFlags().BoolVar(&runtimeMetrics, "web.runtime-metrics", false, "also expose go runtime and process metrics")
+	rootCmd.PersistentFlags().StringVar(&webConfigFile, "web.config.file", "", "path to web config file with tls and basic auth settings (exporter-toolkit format)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -93,5 +116,5 @@ func initConfig() {
 }
 
 func initLogger() {
-	log.InitLogger(logLevel, logFile)
+	log.InitLogger(logLevel, logFile, logFormat, logRotation)
 }
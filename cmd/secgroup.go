@@ -0,0 +1,282 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+	"github.com/allanhung/alicloud-monitoring/pkg/types"
+)
+
+const (
+	findingOpenPort = "open_port"
+	findingUnused   = "unused"
+	findingNoTag    = "no_tag"
+)
+
+var defaultSensitivePorts = []string{"22", "3389", "3306", "5432", "1433", "1521", "6379", "27017", "9200"}
+
+var secGroupQueryFlags = alicloud.QuerySecGroupFlags{}
+
+type secGroupFinding struct {
+	SecurityGroupId   string `json:"securityGroupId"`
+	SecurityGroupName string `json:"securityGroupName"`
+	VpcId             string `json:"vpcId"`
+	Finding           string `json:"finding"`
+	Port              string `json:"port,omitempty"`
+	Detail            string `json:"detail"`
+}
+
+type secGroupReport struct {
+	Timestamp      time.Time         `json:"timestamp"`
+	SecurityGroups int               `json:"securityGroups"`
+	Findings       []secGroupFinding `json:"findings"`
+}
+
+// secGroupCmd represents the secgroup command
+var secGroupCmd = &cobra.Command{
+	Use:   "secgroup",
+	Short: "Audit security groups.",
+	Long: `This tool will audit security groups for rules opening sensitive ports to the internet,
+groups without instances and groups without the environment tag.
+
+example:
+  alicloud-monitoring secgroup --report /tmp/secgroup.json
+  alicloud-monitoring secgroup --port 22,3389,8080 --cron '0 0 * * * *'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		reg := monitor.NewRegistry(runtimeMetrics)
+		pm := monitor.NewSecGroupMonitor(reg)
+		var c *cron.Cron
+		jobLock := &joblock.JobLock{Name: cmd.Use}
+		if len(secGroupQueryFlags.Ports) == 0 {
+			secGroupQueryFlags.Ports = append(secGroupQueryFlags.Ports, defaultSensitivePorts...)
+		}
+
+		aliClient := newAliClient(reg)
+		defer aliClient.Close()
+
+		if secGroupQueryFlags.Cron == "" {
+			err := querySecGroup(jobLock, aliClient, secGroupQueryFlags, pm)
+			monitor.SetJobResult(cmd.Use, err)
+			if err != nil {
+				log.Logger.Errorf("%v", err)
+				os.Exit(1)
+			}
+		} else {
+			c = cron.New(cron.WithSeconds())
+			c.AddFunc(secGroupQueryFlags.Cron, func() {
+				err := querySecGroup(jobLock, aliClient, secGroupQueryFlags, pm)
+				if err != nil {
+					log.Logger.Errorf("%v", err)
+				}
+				monitor.SetJobResult(cmd.Use, err)
+			})
+			fmt.Printf("start: %v", time.Now())
+			c.Start()
+		}
+		err := monitor.PrometheusBoot(reg, webListenAddress, webConfigFile)
+		if c != nil {
+			c.Stop()
+		}
+		if err != nil {
+			log.Logger.Errorf("failed to ListenAndServe: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func querySecGroup(job *joblock.JobLock, aliClient *alicloud.AliClient, queryFlags alicloud.QuerySecGroupFlags, pm *monitor.SecGroupMonitor) error {
+	if job.IsRunning {
+		return fmt.Errorf("job is still running: %s", job.Kind)
+	} else {
+		job.SetRun("Security group audit")
+	}
+	defer job.DoneRun()
+	job.Log().Infof("Running job: %s", job.Kind)
+
+	ports := []int{}
+	for _, port := range queryFlags.Ports {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return fmt.Errorf("invalid port %s: %v", port, err)
+		}
+		ports = append(ports, p)
+	}
+
+	groups, err := alicloud.QuerySecurityGroups(aliClient, queryFlags.PageSize)
+	if err != nil {
+		return err
+	}
+	report := secGroupReport{
+		Timestamp:      time.Now(),
+		SecurityGroups: len(groups),
+		Findings:       []secGroupFinding{},
+	}
+	for _, group := range groups {
+		rules, err := alicloud.QuerySecurityGroupRules(aliClient, group.SecurityGroupId)
+		if err != nil {
+			return err
+		}
+		report.Findings = append(report.Findings, auditSecGroup(group, rules, ports, queryFlags.TagKey)...)
+	}
+
+	pm.SecGroups.Set(float64(len(groups)))
+	for _, finding := range report.Findings {
+		pm.FindingSeries.Set(job.Name, prometheus.Labels{"id": finding.SecurityGroupId, "name": finding.SecurityGroupName, "vpc": finding.VpcId, "finding": finding.Finding, "port": finding.Port}, 1)
+		job.Log().Infof("security group: %s (%s) %s: %s", finding.SecurityGroupId, finding.SecurityGroupName, finding.Finding, finding.Detail)
+	}
+	pm.FindingSeries.EndRun(job.Name)
+
+	if queryFlags.Report != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode security group report: %v", err)
+		}
+		if err := ioutil.WriteFile(queryFlags.Report, data, 0644); err != nil {
+			return fmt.Errorf("failed to write security group report: %v", err)
+		}
+	}
+	job.Log().Infof("Job Completed. %d security groups, %d findings", len(groups), len(report.Findings))
+	return nil
+}
+
+func auditSecGroup(group ecs.SecurityGroup, rules []ecs.Permission, ports []int, tagKey string) []secGroupFinding {
+	findings := []secGroupFinding{}
+	newFinding := func(finding string, port string, detail string) secGroupFinding {
+		return secGroupFinding{
+			SecurityGroupId:   group.SecurityGroupId,
+			SecurityGroupName: group.SecurityGroupName,
+			VpcId:             group.VpcId,
+			Finding:           finding,
+			Port:              port,
+			Detail:            detail,
+		}
+	}
+
+	// rules opening the same port, e.g. for tcp and udp, are one finding per port
+	openRules := map[int][]string{}
+	for _, rule := range rules {
+		if !strings.EqualFold(rule.Policy, "accept") && rule.Policy != "" {
+			continue
+		}
+		if rule.SourceCidrIp != "0.0.0.0/0" && rule.Ipv6SourceCidrIp != "::/0" {
+			continue
+		}
+		protocol := strings.ToLower(rule.IpProtocol)
+		if protocol != "tcp" && protocol != "udp" && protocol != "all" {
+			continue
+		}
+		from, to, ok := parsePortRange(rule.PortRange)
+		if !ok {
+			continue
+		}
+		for _, port := range ports {
+			if port >= from && port <= to {
+				openRules[port] = append(openRules[port], fmt.Sprintf("%s %s", protocol, rule.PortRange))
+			}
+		}
+	}
+	for _, port := range ports {
+		if openRules[port] == nil {
+			continue
+		}
+		findings = append(findings, newFinding(findingOpenPort, strconv.Itoa(port), fmt.Sprintf("%s open to the internet", strings.Join(openRules[port], ", "))))
+		delete(openRules, port)
+	}
+	if group.EcsCount == 0 {
+		findings = append(findings, newFinding(findingUnused, "", "no instances in security group"))
+	}
+	if tagKey != "" {
+		tagged := false
+		for _, tag := range group.Tags.Tag {
+			if tag.TagKey == tagKey {
+				tagged = true
+				break
+			}
+		}
+		if !tagged {
+			findings = append(findings, newFinding(findingNoTag, "", fmt.Sprintf("no %s tag", tagKey)))
+		}
+	}
+	return findings
+}
+
+// parsePortRange parses a port range like 22/22, -1/-1 means all ports.
+func parsePortRange(portRange string) (int, int, bool) {
+	parts := strings.Split(portRange, "/")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	from, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	to, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	if from == -1 && to == -1 {
+		return 1, 65535, true
+	}
+	return from, to, true
+}
+
+func secGroupServeJob(env *serveEnv, jobCfg serveJobConfig) func() error {
+	queryFlags := alicloud.QuerySecGroupFlags{
+		PageSize: jobCfg.PageSize,
+		Ports:    types.ArgList(jobCfg.Ports),
+		TagKey:   jobCfg.TagKey,
+		Report:   jobCfg.Report,
+		Cron:     jobCfg.Cron,
+	}
+	if len(queryFlags.Ports) == 0 {
+		queryFlags.Ports = append(queryFlags.Ports, defaultSensitivePorts...)
+	}
+	if queryFlags.TagKey == "" {
+		queryFlags.TagKey = "Environment"
+	}
+	pm := env.getSecGroupMonitor()
+	jobLock := &joblock.JobLock{Name: jobCfg.Name}
+	return func() error {
+		return querySecGroup(jobLock, env.aliClient, queryFlags, pm)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(secGroupCmd)
+	serveJobs["secgroup"] = secGroupServeJob
+	f := secGroupCmd.Flags()
+	f.IntVarP(&secGroupQueryFlags.PageSize, "pagesize", "s", 10, "alicloud api pagesize")
+	f.VarP(&secGroupQueryFlags.Ports, "port", "p", "sensitive ports which must not be open to 0.0.0.0/0, defaults to ssh, rdp and common database ports (can specify multiple)")
+	f.StringVarP(&secGroupQueryFlags.TagKey, "tagkey", "", "Environment", "tag key every security group must have")
+	f.StringVarP(&secGroupQueryFlags.Report, "report", "", "", "write findings as json to this file")
+	f.StringVarP(&secGroupQueryFlags.Cron, "cron", "c", "", "cron scheduler")
+}
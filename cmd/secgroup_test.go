@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		portRange string
+		from      int
+		to        int
+		ok        bool
+	}{
+		{"22/22", 22, 22, true},
+		{"1000/2000", 1000, 2000, true},
+		{"-1/-1", 1, 65535, true},
+		{"22", 0, 0, false},
+		{"a/22", 0, 0, false},
+		{"22/b", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		from, to, ok := parsePortRange(tt.portRange)
+		if from != tt.from || to != tt.to || ok != tt.ok {
+			t.Errorf("parsePortRange(%q) = %d, %d, %v, want %d, %d, %v", tt.portRange, from, to, ok, tt.from, tt.to, tt.ok)
+		}
+	}
+}
+
+func TestAuditSecGroup(t *testing.T) {
+	group := ecs.SecurityGroup{SecurityGroupId: "sg-1", SecurityGroupName: "web", VpcId: "vpc-1", EcsCount: 2}
+	group.Tags.Tag = []ecs.Tag{{TagKey: "Environment", TagValue: "production"}}
+	finding := func(kind string, port string, detail string) secGroupFinding {
+		return secGroupFinding{SecurityGroupId: "sg-1", SecurityGroupName: "web", VpcId: "vpc-1", Finding: kind, Port: port, Detail: detail}
+	}
+	ports := []int{22, 3306}
+
+	tests := []struct {
+		name     string
+		group    ecs.SecurityGroup
+		rules    []ecs.Permission
+		tagKey   string
+		findings []secGroupFinding
+	}{
+		{
+			name:  "private and denied rules",
+			group: group,
+			rules: []ecs.Permission{
+				{Policy: "Accept", IpProtocol: "TCP", PortRange: "22/22", SourceCidrIp: "10.0.0.0/8"},
+				{Policy: "Drop", IpProtocol: "TCP", PortRange: "22/22", SourceCidrIp: "0.0.0.0/0"},
+				{Policy: "Accept", IpProtocol: "ICMP", PortRange: "-1/-1", SourceCidrIp: "0.0.0.0/0"},
+				{Policy: "Accept", IpProtocol: "TCP", PortRange: "80/443", SourceCidrIp: "0.0.0.0/0"},
+			},
+			tagKey:   "Environment",
+			findings: []secGroupFinding{},
+		},
+		{
+			name:  "open ports",
+			group: group,
+			rules: []ecs.Permission{
+				{Policy: "Accept", IpProtocol: "TCP", PortRange: "22/22", SourceCidrIp: "0.0.0.0/0"},
+				{IpProtocol: "TCP", PortRange: "3000/4000", Ipv6SourceCidrIp: "::/0"},
+			},
+			findings: []secGroupFinding{
+				finding(findingOpenPort, "22", "tcp 22/22 open to the internet"),
+				finding(findingOpenPort, "3306", "tcp 3000/4000 open to the internet"),
+			},
+		},
+		{
+			name:  "tcp and udp on the same port are one finding",
+			group: group,
+			rules: []ecs.Permission{
+				{Policy: "Accept", IpProtocol: "TCP", PortRange: "22/22", SourceCidrIp: "0.0.0.0/0"},
+				{Policy: "Accept", IpProtocol: "UDP", PortRange: "22/22", SourceCidrIp: "0.0.0.0/0"},
+				{Policy: "Accept", IpProtocol: "ALL", PortRange: "-1/-1", SourceCidrIp: "0.0.0.0/0"},
+			},
+			findings: []secGroupFinding{
+				finding(findingOpenPort, "22", "tcp 22/22, udp 22/22, all -1/-1 open to the internet"),
+				finding(findingOpenPort, "3306", "all -1/-1 open to the internet"),
+			},
+		},
+		{
+			name:   "unused and untagged",
+			group:  ecs.SecurityGroup{SecurityGroupId: "sg-1", SecurityGroupName: "web", VpcId: "vpc-1"},
+			tagKey: "Environment",
+			findings: []secGroupFinding{
+				finding(findingUnused, "", "no instances in security group"),
+				finding(findingNoTag, "", "no Environment tag"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := auditSecGroup(tt.group, tt.rules, ports, tt.tagKey); !reflect.DeepEqual(got, tt.findings) {
+				t.Errorf("auditSecGroup() = %+v, want %+v", got, tt.findings)
+			}
+		})
+	}
+}
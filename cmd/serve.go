@@ -0,0 +1,334 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+	"github.com/allanhung/alicloud-monitoring/pkg/types"
+)
+
+// serveJobConfig is one entry of the "jobs" section in the config file.
+type serveJobConfig struct {
+	Name             string               `mapstructure:"-"`
+	Kind             string               `mapstructure:"kind"`
+	Enabled          bool                 `mapstructure:"enabled"`
+	Cron             string               `mapstructure:"cron"`
+	PageSize         int                  `mapstructure:"pageSize"`
+	Region           string               `mapstructure:"region"`
+	InstanceId       string               `mapstructure:"instanceId"`
+	InstanceName     string               `mapstructure:"instanceName"`
+	Tag              []string             `mapstructure:"tag"`
+	ReName           []string             `mapstructure:"re"`
+	NoTagKey         []string             `mapstructure:"notagk"`
+	NoTagValue       []string             `mapstructure:"notagv"`
+	InventoryFile    string               `mapstructure:"inventoryFile"`
+	InventoryWebhook string               `mapstructure:"inventoryWebhook"`
+	Mode             string               `mapstructure:"mode"`
+	CacheTTL         time.Duration        `mapstructure:"cacheTTL"`
+	Ports            []string             `mapstructure:"ports"`
+	TagKey           string               `mapstructure:"tagKey"`
+	Report           string               `mapstructure:"report"`
+	VpcId            string               `mapstructure:"vpcId"`
+	Quotas           []string             `mapstructure:"quotas"`
+	Metrics          []alicloud.CmsMetric `mapstructure:"metrics"`
+	TagLabels        []string             `mapstructure:"tagLabels"`
+	InstanceTypes    []string             `mapstructure:"instanceTypes"`
+	ChargeTypes      []string             `mapstructure:"chargeTypes"`
+	TagInstances     bool                 `mapstructure:"tagInstances"`
+	TagKeys          []string             `mapstructure:"tagKeys"`
+	DryRun           bool                 `mapstructure:"dryRun"`
+	MaxChanges       int                  `mapstructure:"maxChanges"`
+	PlanDir          string               `mapstructure:"planDir"`
+	PlanWebhook      string               `mapstructure:"planWebhook"`
+	TerraformState   []string             `mapstructure:"tfState"`
+	IacMode          string               `mapstructure:"iacMode"`
+	IgnoreKeys       []string             `mapstructure:"ignoreKeys"`
+	MinHeadroom      float64              `mapstructure:"minHeadroom"`
+}
+
+func (j serveJobConfig) queryEcsFlags() alicloud.QueryEcsFlags {
+	return alicloud.QueryEcsFlags{
+		InstanceId:       j.InstanceId,
+		InstanceName:     j.InstanceName,
+		PageSize:         j.PageSize,
+		Tag:              types.ArgList(j.Tag),
+		ReName:           types.ArgList(j.ReName),
+		NoTagKey:         types.ArgList(j.NoTagKey),
+		NoTagValue:       types.ArgList(j.NoTagValue),
+		Cron:             j.Cron,
+		InventoryFile:    j.InventoryFile,
+		InventoryWebhook: j.InventoryWebhook,
+		Mode:             j.Mode,
+		CacheTTL:         j.CacheTTL,
+		DryRun:           j.DryRun,
+		Normalizer:       tagNormalizer,
+		MaxChanges:       j.MaxChanges,
+		PlanDir:          j.PlanDir,
+		PlanWebhook:      j.PlanWebhook,
+		TerraformState:   types.ArgList(j.TerraformState),
+		IacMode:          j.IacMode,
+	}
+}
+
+// serveEnv is shared by all jobs of the serve command, monitors are created on first use
+// so that jobs of the same kind report into the same metrics.
+type serveEnv struct {
+	aliClient         *alicloud.AliClient
+	reg               prometheus.Registerer
+	tagsMonitor       *monitor.TagsMonitor
+	spotMonitor       *monitor.SpotMonitor
+	inventoryMonitor  *monitor.InventoryMonitor
+	secGroupMonitor   *monitor.SecGroupMonitor
+	vpcMonitor        *monitor.VpcMonitor
+	quotaMonitor      *monitor.QuotaMonitor
+	stockMonitor      *monitor.StockMonitor
+	essMonitor        *monitor.EssMonitor
+	complianceMonitor *monitor.ComplianceMonitor
+	normalizeMonitor  *monitor.NormalizeMonitor
+	approvalMonitor   *monitor.ApprovalMonitor
+	driftMonitor      *monitor.DriftMonitor
+	spotBidMonitor    *monitor.SpotBidMonitor
+}
+
+func (e *serveEnv) getTagsMonitor() *monitor.TagsMonitor {
+	if e.tagsMonitor == nil {
+		e.tagsMonitor = monitor.NewTagsMonitor(e.reg)
+	}
+	return e.tagsMonitor
+}
+
+func (e *serveEnv) getSpotMonitor() *monitor.SpotMonitor {
+	if e.spotMonitor == nil {
+		e.spotMonitor = monitor.NewSpotMonitor(e.reg)
+	}
+	return e.spotMonitor
+}
+
+func (e *serveEnv) getInventoryMonitor() *monitor.InventoryMonitor {
+	if e.inventoryMonitor == nil {
+		e.inventoryMonitor = monitor.NewInventoryMonitor(e.reg)
+	}
+	return e.inventoryMonitor
+}
+
+func (e *serveEnv) getSecGroupMonitor() *monitor.SecGroupMonitor {
+	if e.secGroupMonitor == nil {
+		e.secGroupMonitor = monitor.NewSecGroupMonitor(e.reg)
+	}
+	return e.secGroupMonitor
+}
+
+func (e *serveEnv) getVpcMonitor() *monitor.VpcMonitor {
+	if e.vpcMonitor == nil {
+		e.vpcMonitor = monitor.NewVpcMonitor(e.reg)
+	}
+	return e.vpcMonitor
+}
+
+func (e *serveEnv) getQuotaMonitor() *monitor.QuotaMonitor {
+	if e.quotaMonitor == nil {
+		e.quotaMonitor = monitor.NewQuotaMonitor(e.reg)
+	}
+	return e.quotaMonitor
+}
+
+func (e *serveEnv) getStockMonitor() *monitor.StockMonitor {
+	if e.stockMonitor == nil {
+		e.stockMonitor = monitor.NewStockMonitor(e.reg)
+	}
+	return e.stockMonitor
+}
+
+func (e *serveEnv) getEssMonitor() *monitor.EssMonitor {
+	if e.essMonitor == nil {
+		e.essMonitor = monitor.NewEssMonitor(e.reg)
+	}
+	return e.essMonitor
+}
+
+func (e *serveEnv) getComplianceMonitor() *monitor.ComplianceMonitor {
+	if e.complianceMonitor == nil {
+		e.complianceMonitor = monitor.NewComplianceMonitor(e.reg)
+	}
+	return e.complianceMonitor
+}
+
+func (e *serveEnv) getNormalizeMonitor() *monitor.NormalizeMonitor {
+	if e.normalizeMonitor == nil {
+		e.normalizeMonitor = monitor.NewNormalizeMonitor(e.reg)
+	}
+	return e.normalizeMonitor
+}
+
+func (e *serveEnv) getApprovalMonitor() *monitor.ApprovalMonitor {
+	if e.approvalMonitor == nil {
+		e.approvalMonitor = monitor.NewApprovalMonitor(e.reg)
+	}
+	return e.approvalMonitor
+}
+
+func (e *serveEnv) getDriftMonitor() *monitor.DriftMonitor {
+	if e.driftMonitor == nil {
+		e.driftMonitor = monitor.NewDriftMonitor(e.reg)
+	}
+	return e.driftMonitor
+}
+
+func (e *serveEnv) getSpotBidMonitor() *monitor.SpotBidMonitor {
+	if e.spotBidMonitor == nil {
+		e.spotBidMonitor = monitor.NewSpotBidMonitor(e.reg)
+	}
+	return e.spotBidMonitor
+}
+
+// serveJobs maps a job kind to the function building its run function, each command registers its own kind.
+// A nil run function means the job registered a collector and is queried on scrape instead.
+var serveJobs = map[string]func(env *serveEnv, jobCfg serveJobConfig) func() error{}
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run all configured jobs in one process.",
+	Long: `This tool will run the jobs configured in the "jobs" section of the config file,
+each on its own schedule, sharing one alicloud client and one metrics endpoint.
+
+example config:
+  jobs:
+    ecs:
+      enabled: true
+      cron: "0 */10 * * * *"
+      notagk: ["Environment"]
+    updatek8stags:
+      enabled: true
+      cron: "0 * * * * *"
+    spotprice:
+      enabled: true
+      cron: "0 */5 * * * *"
+
+example:
+  alicloud-monitoring serve --config /etc/alicloud-monitoring/config.yaml`,
+	Run: func(cmd *cobra.Command, args []string) {
+		jobs := map[string]serveJobConfig{}
+		if err := viper.UnmarshalKey("jobs", &jobs); err != nil {
+			log.Logger.Errorf("failed to load jobs config: %v", err)
+			os.Exit(1)
+		}
+		if len(jobs) == 0 {
+			log.Logger.Errorf("no jobs configured, add a jobs section to the config file")
+			os.Exit(1)
+		}
+
+		reg := monitor.NewRegistry(runtimeMetrics)
+		aliClient := newAliClient(reg)
+		defer aliClient.Close()
+		env := &serveEnv{
+			aliClient: aliClient,
+			reg:       reg,
+		}
+
+		names := []string{}
+		for name := range jobs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		claims := map[string]metricSourceClaim{}
+		c := cron.New(cron.WithSeconds())
+		for _, name := range names {
+			jobCfg := jobs[name]
+			if !jobCfg.Enabled {
+				log.Logger.Infof("job %s is disabled", name)
+				continue
+			}
+			jobCfg.Name = name
+			if jobCfg.Kind == "" {
+				jobCfg.Kind = name
+			}
+			if jobCfg.PageSize == 0 {
+				jobCfg.PageSize = 10
+			}
+			if jobCfg.Mode == "" {
+				jobCfg.Mode = gaugeMode
+			}
+			if jobCfg.CacheTTL == 0 {
+				jobCfg.CacheTTL = time.Minute
+			}
+			if err := validateMode(jobCfg.Mode); err != nil {
+				log.Logger.Errorf("job %s: %v", name, err)
+				os.Exit(1)
+			}
+			newJob, ok := serveJobs[jobCfg.Kind]
+			if !ok {
+				log.Logger.Errorf("job %s has unknown kind: %s", name, jobCfg.Kind)
+				os.Exit(1)
+			}
+			if err := claimMetricSource(claims, jobCfg); err != nil {
+				log.Logger.Errorf("%v", err)
+				os.Exit(1)
+			}
+			runJob := newJob(env, jobCfg)
+			if runJob == nil {
+				log.Logger.Infof("job %s collects on scrape with cache ttl %v", name, jobCfg.CacheTTL)
+				continue
+			}
+			run := scheduledJob(name, runJob)
+			if jobCfg.Cron == "" {
+				log.Logger.Infof("job %s has no cron, running once", name)
+				go run()
+				continue
+			}
+			if _, err := c.AddFunc(jobCfg.Cron, run); err != nil {
+				log.Logger.Errorf("job %s has invalid cron %s: %v", name, jobCfg.Cron, err)
+				os.Exit(1)
+			}
+			log.Logger.Infof("job %s scheduled with cron %s", name, jobCfg.Cron)
+		}
+		c.Start()
+		defer c.Stop()
+
+		err := monitor.PrometheusBoot(reg, webListenAddress, webConfigFile)
+		if err != nil {
+			log.Logger.Errorf("failed to ListenAndServe: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// scheduledJob wraps run so that its result is logged and reported to the readiness endpoint.
+func scheduledJob(name string, run func() error) func() {
+	return func() {
+		err := run()
+		if err != nil {
+			log.Logger.Errorf("job %s failed: %v", name, err)
+		}
+		monitor.SetJobResult(name, err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
+	"github.com/allanhung/alicloud-monitoring/pkg/notify"
+	"github.com/allanhung/alicloud-monitoring/pkg/spotalert"
+)
+
+// newSpotAlertEvaluator builds the evaluator from the "spotalert" section of the config file,
+// without it from the thresholds of queryFlags applying to all types and zones.
+func newSpotAlertEvaluator(queryFlags alicloud.QuerySpotPriceFlags) (*spotalert.Evaluator, error) {
+	cfg := spotalert.Config{}
+	if viper.IsSet("spotalert") {
+		if err := viper.UnmarshalKey("spotalert", &cfg); err != nil {
+			return nil, fmt.Errorf("failed to load spotalert config: %v", err)
+		}
+	} else if queryFlags.MinDiscount > 0 || queryFlags.MaxPrice > 0 {
+		cfg.Rules = []spotalert.Rule{{
+			MinDiscount: queryFlags.MinDiscount,
+			MaxPrice:    queryFlags.MaxPrice,
+			Hysteresis:  queryFlags.Hysteresis,
+			For:         queryFlags.AlertFor,
+		}}
+	}
+	evaluator, err := spotalert.NewEvaluator(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spot alert evaluator: %v", err)
+	}
+	return evaluator, nil
+}
+
+// notifySpotAlerts sends one notification for the alerts that fired and one for the alerts that resolved in a run.
+func notifySpotAlerts(job *joblock.JobLock, transitions []spotalert.Transition) {
+	fired := []string{}
+	resolved := []string{}
+	for _, t := range transitions {
+		line := t.Alert.String()
+		if t.Resolved {
+			job.Log().Infof("spot price alert resolved: %s", line)
+			resolved = append(resolved, line)
+		} else {
+			job.Log().Warnf("spot price alert firing: %s", line)
+			fired = append(fired, fmt.Sprintf("%s since %s", line, t.Alert.Since.Format("2006-01-02 15:04:05")))
+		}
+	}
+	for _, msg := range []struct {
+		state string
+		lines []string
+	}{{"firing", fired}, {"resolved", resolved}} {
+		if len(msg.lines) == 0 {
+			continue
+		}
+		sort.Strings(msg.lines)
+		err := notifier.Notify(notify.Message{
+			Key:   "spotprice/" + msg.state + "/" + strings.Join(msg.lines, ","),
+			Kind:  "spotprice",
+			Title: fmt.Sprintf("%d spot price alerts %s", len(msg.lines), msg.state),
+			Lines: msg.lines,
+		})
+		if err != nil {
+			job.Log().Errorf("failed to notify %s spot price alerts: %v", msg.state, err)
+		}
+	}
+}
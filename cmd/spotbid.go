@@ -0,0 +1,211 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+	"github.com/allanhung/alicloud-monitoring/pkg/notify"
+	"github.com/allanhung/alicloud-monitoring/pkg/spotalert"
+	"github.com/allanhung/alicloud-monitoring/pkg/types"
+)
+
+// defaultMinHeadroom is the percent below the price limit the spot price is warned about.
+const defaultMinHeadroom = 10
+
+var spotBidQueryFlags = alicloud.QuerySpotBidFlags{}
+
+// spotBidCmd represents the spotbid command
+var spotBidCmd = &cobra.Command{
+	Use:   "spotbid",
+	Short: "Compare the price limit of spot instances with the spot price.",
+	Long: `This tool will compare the price limit of running spot instances launched with SpotWithPriceLimit with the current
+spot price of their instance type and zone, and warn when the spot price approaches the limit.
+
+example:
+  alicloud-monitoring spotbid --minheadroom 10
+  alicloud-monitoring spotbid --re 'worker-k8s.*' --cron '0 */5 * * * *'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		reg := monitor.NewRegistry(runtimeMetrics)
+		pm := monitor.NewSpotBidMonitor(reg)
+		var c *cron.Cron
+		jobLock := &joblock.JobLock{Name: cmd.Use}
+
+		aliClient := newAliClient(reg)
+		defer aliClient.Close()
+
+		if spotBidQueryFlags.Cron == "" {
+			err := querySpotBid(jobLock, aliClient, spotBidQueryFlags, pm)
+			monitor.SetJobResult(cmd.Use, err)
+			if err != nil {
+				log.Logger.Errorf("%v", err)
+				os.Exit(1)
+			}
+		} else {
+			c = cron.New(cron.WithSeconds())
+			c.AddFunc(spotBidQueryFlags.Cron, func() {
+				err := querySpotBid(jobLock, aliClient, spotBidQueryFlags, pm)
+				if err != nil {
+					log.Logger.Errorf("%v", err)
+				}
+				monitor.SetJobResult(cmd.Use, err)
+			})
+			fmt.Printf("start: %v", time.Now())
+			c.Start()
+		}
+		err := monitor.PrometheusBoot(reg, webListenAddress, webConfigFile)
+		if c != nil {
+			c.Stop()
+		}
+		if err != nil {
+			log.Logger.Errorf("failed to ListenAndServe: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func querySpotBid(job *joblock.JobLock, aliClient *alicloud.AliClient, queryFlags alicloud.QuerySpotBidFlags, pm *monitor.SpotBidMonitor) error {
+	if job.IsRunning {
+		return fmt.Errorf("job is still running: %s", job.Kind)
+	} else {
+		job.SetRun("Spot bid advisor")
+	}
+	defer job.DoneRun()
+	job.Log().Infof("Running job: %s", job.Kind)
+
+	instances, err := alicloud.QueryECS(aliClient, alicloud.QueryEcsFlags{
+		PageSize: queryFlags.PageSize,
+		Tag:      queryFlags.Tag,
+		ReName:   queryFlags.ReName,
+	})
+	if err != nil {
+		return err
+	}
+	// latest spot price per instance type and zone, each instance type is queried once
+	zonePrices := map[string]map[string]float64{}
+	spotInstances := 0
+	warnLines := []string{}
+	warnIds := []string{}
+	for _, instance := range instances {
+		if instance.SpotStrategy != "SpotWithPriceLimit" || instance.Status != "Running" || instance.SpotPriceLimit <= 0 {
+			continue
+		}
+		if _, ok := zonePrices[instance.InstanceType]; !ok {
+			spotPrices, err := alicloud.QuerySpotPrice(aliClient, instance.InstanceType)
+			if err != nil {
+				return err
+			}
+			zonePrices[instance.InstanceType] = latestZonePrices(spotPrices)
+		}
+		logger := job.Log().WithField(log.FieldInstanceID, instance.InstanceId)
+		price, ok := zonePrices[instance.InstanceType][instance.ZoneId]
+		if !ok {
+			logger.Warnf("no spot price for %s in %s", instance.InstanceType, instance.ZoneId)
+			continue
+		}
+		spotInstances++
+		headroom, near := nearPriceLimit(instance.SpotPriceLimit, price, queryFlags.MinHeadroom)
+		labels := prometheus.Labels{"id": instance.InstanceId, "name": instance.InstanceName, "type": instance.InstanceType, "zoneid": instance.ZoneId}
+		pm.PriceLimitSeries.Set(job.Name, labels, instance.SpotPriceLimit)
+		pm.MarketPriceSeries.Set(job.Name, labels, price)
+		pm.HeadroomSeries.Set(job.Name, labels, headroom)
+		logger.Debugf("instance: %s (%s) %s in %s price limit %v spot price %v headroom %.2f%%", instance.InstanceId, instance.InstanceName, instance.InstanceType, instance.ZoneId, instance.SpotPriceLimit, price, headroom)
+		if near {
+			logger.Warnf("instance: %s (%s) spot price %v of %s in %s is within %.2f%% of the price limit %v", instance.InstanceId, instance.InstanceName, price, instance.InstanceType, instance.ZoneId, headroom, instance.SpotPriceLimit)
+			warnIds = append(warnIds, instance.InstanceId)
+			warnLines = append(warnLines, fmt.Sprintf("%s (%s) %s in %s: spot price %v, price limit %v, headroom %.2f%%", instance.InstanceId, instance.InstanceName, instance.InstanceType, instance.ZoneId, price, instance.SpotPriceLimit, headroom))
+		}
+	}
+	pm.PriceLimitSeries.EndRun(job.Name)
+	pm.MarketPriceSeries.EndRun(job.Name)
+	pm.HeadroomSeries.EndRun(job.Name)
+
+	if len(warnLines) > 0 {
+		sort.Strings(warnLines)
+		// keyed by the instances only, changing prices of the same instances are not notified again
+		sort.Strings(warnIds)
+		err := notifier.Notify(notify.Message{
+			Key:   "spotbid/" + strings.Join(warnIds, ","),
+			Kind:  "spotbid",
+			Title: fmt.Sprintf("%d spot instances with spot price within %.0f%% of their price limit, update the launch templates", len(warnLines), queryFlags.MinHeadroom),
+			Lines: warnLines,
+		})
+		if err != nil {
+			job.Log().Errorf("failed to notify spot price limits: %v", err)
+		}
+	}
+	job.Log().Infof("Job Completed. %d spot instances with price limit, %d near their limit", spotInstances, len(warnLines))
+	return nil
+}
+
+// nearPriceLimit returns the headroom of the spot price below the price limit and whether it is less than minHeadroom.
+func nearPriceLimit(priceLimit float64, price float64, minHeadroom float64) (float64, bool) {
+	headroom := spotalert.Headroom(priceLimit, price)
+	return headroom, headroom < minHeadroom
+}
+
+// latestZonePrices returns the latest spot price per zone of prices sorted newest first.
+func latestZonePrices(spotPrices []ecs.SpotPriceType) map[string]float64 {
+	prices := map[string]float64{}
+	for _, spotPrice := range spotPrices {
+		if _, ok := prices[spotPrice.ZoneId]; !ok {
+			prices[spotPrice.ZoneId] = spotPrice.SpotPrice
+		}
+	}
+	return prices
+}
+
+func spotBidServeJob(env *serveEnv, jobCfg serveJobConfig) func() error {
+	queryFlags := alicloud.QuerySpotBidFlags{
+		PageSize:    jobCfg.PageSize,
+		Tag:         types.ArgList(jobCfg.Tag),
+		ReName:      types.ArgList(jobCfg.ReName),
+		MinHeadroom: jobCfg.MinHeadroom,
+		Cron:        jobCfg.Cron,
+	}
+	if queryFlags.MinHeadroom == 0 {
+		queryFlags.MinHeadroom = defaultMinHeadroom
+	}
+	pm := env.getSpotBidMonitor()
+	jobLock := &joblock.JobLock{Name: jobCfg.Name}
+	return func() error {
+		return querySpotBid(jobLock, env.aliClient, queryFlags, pm)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(spotBidCmd)
+	serveJobs["spotbid"] = spotBidServeJob
+	f := spotBidCmd.Flags()
+	f.IntVarP(&spotBidQueryFlags.PageSize, "pagesize", "s", 10, "alicloud api pagesize")
+	f.VarP(&spotBidQueryFlags.Tag, "tag", "t", "filter by ecs instance tag example: cluster=prod (can specify multiple)")
+	f.VarP(&spotBidQueryFlags.ReName, "re", "", "filter by ecs instance name with regular expression  example: ecs.* (can specify multiple, will use or operator)")
+	f.Float64VarP(&spotBidQueryFlags.MinHeadroom, "minheadroom", "", defaultMinHeadroom, "warn when the spot price is less than this percent below the price limit")
+	f.StringVarP(&spotBidQueryFlags.Cron, "cron", "c", "", "cron scheduler")
+}
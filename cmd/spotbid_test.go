@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+)
+
+func TestLatestZonePrices(t *testing.T) {
+	tests := []struct {
+		name       string
+		spotPrices []ecs.SpotPriceType
+		want       map[string]float64
+	}{
+		{"empty", nil, map[string]float64{}},
+		{
+			name: "newest first per zone",
+			spotPrices: []ecs.SpotPriceType{
+				{ZoneId: "cn-hangzhou-h", SpotPrice: 0.3, Timestamp: "2020-01-01T02:00:00Z"},
+				{ZoneId: "cn-hangzhou-i", SpotPrice: 0.5, Timestamp: "2020-01-01T02:00:00Z"},
+				{ZoneId: "cn-hangzhou-h", SpotPrice: 0.2, Timestamp: "2020-01-01T01:00:00Z"},
+				{ZoneId: "cn-hangzhou-i", SpotPrice: 0.4, Timestamp: "2020-01-01T01:00:00Z"},
+			},
+			want: map[string]float64{"cn-hangzhou-h": 0.3, "cn-hangzhou-i": 0.5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := latestZonePrices(tt.spotPrices); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("latestZonePrices() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNearPriceLimit(t *testing.T) {
+	tests := []struct {
+		name         string
+		priceLimit   float64
+		price        float64
+		minHeadroom  float64
+		wantHeadroom float64
+		wantNear     bool
+	}{
+		{"far below", 1, 0.5, 10, 50, false},
+		{"at minimum", 1, 0.875, 12.5, 12.5, false},
+		{"within minimum", 1, 0.9375, 10, 6.25, true},
+		{"above limit", 1, 1.25, 10, -25, true},
+		{"zero minimum", 1, 0.9375, 0, 6.25, false},
+		{"zero minimum above limit", 1, 1.25, 0, -25, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headroom, near := nearPriceLimit(tt.priceLimit, tt.price, tt.minHeadroom)
+			if headroom != tt.wantHeadroom || near != tt.wantNear {
+				t.Errorf("nearPriceLimit() = %v, %v, want %v, %v", headroom, near, tt.wantHeadroom, tt.wantNear)
+			}
+		})
+	}
+}
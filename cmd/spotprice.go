@@ -26,9 +26,12 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/collector"
 	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
 	"github.com/allanhung/alicloud-monitoring/pkg/log"
 	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+	"github.com/allanhung/alicloud-monitoring/pkg/spotalert"
+	"github.com/allanhung/alicloud-monitoring/pkg/types"
 )
 
 var spotPriceQueryFlags = alicloud.QuerySpotPriceFlags{}
@@ -44,38 +47,52 @@ example:
   alicloud-monitoring spotprice --cron '0 */5 * * * *'`,
 	Run: func(cmd *cobra.Command, args []string) {
 
-		pm := monitor.NewSpotMonitor()
+		reg := monitor.NewRegistry(runtimeMetrics)
+		var pm *monitor.SpotMonitor
 		var c *cron.Cron
-		jobLock := joblock.JobLock{}
+		jobLock := &joblock.JobLock{Name: cmd.Use}
 
-		pm.SpotPriceWatchdog.With(prometheus.Labels{"name": cmd.Use}).Set(1)
-		cfg := &alicloud.AliCloudConfig{}
-		err := cfg.GetCloudConfig()
-		if err != nil {
-			log.Logger.Errorf("failed to getCloudConfigFromStsToken: %v", err)
+		if err := validateMode(spotPriceQueryFlags.Mode); err != nil {
+			log.Logger.Errorf("%v", err)
 			os.Exit(1)
 		}
-
-		aliClient, err := alicloud.NewAliClient(cfg)
+		evaluator, err := newSpotAlertEvaluator(spotPriceQueryFlags)
 		if err != nil {
-			log.Logger.Errorf("failed to create aliClient: %v", err)
+			log.Logger.Errorf("%v", err)
 			os.Exit(1)
 		}
+		if spotPriceQueryFlags.Mode != collectorMode {
+			pm = monitor.NewSpotMonitor(reg)
+			pm.SpotPriceWatchdog.With(prometheus.Labels{"name": cmd.Use}).Set(1)
+		}
+		aliClient := newAliClient(reg)
+		defer aliClient.Close()
 
-		if spotPriceQueryFlags.Cron == "" {
-			err := querySpotPrice(jobLock, aliClient, pm)
+		if spotPriceQueryFlags.Mode == collectorMode {
+			reg.MustRegister(newSpotPriceCollector(cmd.Use, aliClient, spotPriceQueryFlags))
+		} else if spotPriceQueryFlags.Cron == "" {
+			err := querySpotPrice(jobLock, aliClient, spotPriceQueryFlags, pm, evaluator)
+			monitor.SetJobResult(cmd.Use, err)
 			if err != nil {
 				log.Logger.Errorf("%v", err)
 				os.Exit(1)
 			}
 		} else {
 			c = cron.New(cron.WithSeconds())
-			c.AddFunc(spotPriceQueryFlags.Cron, func() { querySpotPrice(jobLock, aliClient, pm) })
+			c.AddFunc(spotPriceQueryFlags.Cron, func() {
+				err := querySpotPrice(jobLock, aliClient, spotPriceQueryFlags, pm, evaluator)
+				if err != nil {
+					log.Logger.Errorf("%v", err)
+				}
+				monitor.SetJobResult(cmd.Use, err)
+			})
 			fmt.Printf("start: %v", time.Now())
 			c.Start()
 		}
-		err = monitor.PrometheusBoot()
-		c.Stop()
+		err = monitor.PrometheusBoot(reg, webListenAddress, webConfigFile)
+		if c != nil {
+			c.Stop()
+		}
 		if err != nil {
 			log.Logger.Errorf("failed to ListenAndServe: %v", err)
 			os.Exit(1)
@@ -92,11 +109,11 @@ func stringInList(a string, list []string) bool {
 	return false
 }
 
-func queryEcsTypesInk8s(job joblock.JobLock, aliClient *alicloud.AliClient) ([]string, error) {
+func queryEcsTypesInk8s(job *joblock.JobLock, aliClient *alicloud.AliClient, pageSize int) ([]string, error) {
 	instanceTypes := []string{}
 	var ecsQueryFlags = alicloud.QueryEcsFlags{}
 	ecsQueryFlags.ReName = append(ecsQueryFlags.ReName, "worker-k8s.*")
-	ecsQueryFlags.PageSize = spotPriceQueryFlags.PageSize
+	ecsQueryFlags.PageSize = pageSize
 
 	queryList, err := alicloud.QueryECS(aliClient, ecsQueryFlags)
 	if err != nil {
@@ -111,19 +128,22 @@ func queryEcsTypesInk8s(job joblock.JobLock, aliClient *alicloud.AliClient) ([]s
 	return instanceTypes, nil
 }
 
-func querySpotPrice(job joblock.JobLock, aliClient *alicloud.AliClient, pm *monitor.SpotMonitor) error {
+func querySpotPrice(job *joblock.JobLock, aliClient *alicloud.AliClient, queryFlags alicloud.QuerySpotPriceFlags, pm *monitor.SpotMonitor, evaluator *spotalert.Evaluator) error {
 	if job.IsRunning {
 		return fmt.Errorf("job is still running: %s", job.Kind)
 	} else {
 		job.SetRun("Checking spot price")
 	}
-	log.Logger.Infof("Running job: %s", job.Kind)
+	defer job.DoneRun()
+	job.Log().Infof("Running job: %s", job.Kind)
 
-	instanceTypes, err := queryEcsTypesInk8s(job, aliClient)
+	instanceTypes, err := queryEcsTypesInk8s(job, aliClient, queryFlags.PageSize)
 	if err != nil {
 		return err
 	}
 
+	transitions := []spotalert.Transition{}
+	now := time.Now()
 	for _, instanceType := range instanceTypes {
 		zoneList := []string{}
 		spotPrices, err := alicloud.QuerySpotPrice(aliClient, instanceType)
@@ -132,22 +152,106 @@ func querySpotPrice(job joblock.JobLock, aliClient *alicloud.AliClient, pm *moni
 		}
 		for _, spotPrice := range spotPrices {
 			if !stringInList(spotPrice.ZoneId, zoneList) {
-				pm.SpotPrice.With(prometheus.Labels{"zoneid": spotPrice.ZoneId, "type": instanceType}).Set(spotPrice.SpotPrice)
-				pm.ListPrice.With(prometheus.Labels{"zoneid": spotPrice.ZoneId, "type": instanceType}).Set(spotPrice.OriginPrice)
+				pm.SpotPriceSeries.Set(job.Name, prometheus.Labels{"zoneid": spotPrice.ZoneId, "type": instanceType}, spotPrice.SpotPrice)
+				pm.ListPriceSeries.Set(job.Name, prometheus.Labels{"zoneid": spotPrice.ZoneId, "type": instanceType}, spotPrice.OriginPrice)
+				if spotPrice.OriginPrice > 0 {
+					pm.DiscountSeries.Set(job.Name, prometheus.Labels{"zoneid": spotPrice.ZoneId, "type": instanceType}, spotalert.Discount(spotPrice.SpotPrice, spotPrice.OriginPrice))
+				}
+				transitions = append(transitions, evaluator.Evaluate(instanceType, spotPrice.ZoneId, spotPrice.SpotPrice, spotPrice.OriginPrice, now)...)
 				zoneList = append(zoneList, spotPrice.ZoneId)
-				log.Logger.Debugf("Instance Type: %s, Zone: %s, Spot Price: %v, List Price: %v", instanceType, spotPrice.ZoneId, spotPrice.SpotPrice, spotPrice.OriginPrice)
+				job.Log().Debugf("Instance Type: %s, Zone: %s, Spot Price: %v, List Price: %v", instanceType, spotPrice.ZoneId, spotPrice.SpotPrice, spotPrice.OriginPrice)
 			}
 		}
 	}
-	job.DoneRun()
-	log.Logger.Infof("Job Completed.")
+	transitions = append(transitions, evaluator.EndRun()...)
+	for _, alert := range evaluator.Alerts() {
+		pm.AlertSeries.Set(job.Name, prometheus.Labels{"zoneid": alert.Zone, "type": alert.Type, "condition": alert.Condition}, float64(alert.State))
+	}
+	notifySpotAlerts(job, transitions)
+	pm.SpotPriceSeries.EndRun(job.Name)
+	pm.ListPriceSeries.EndRun(job.Name)
+	pm.DiscountSeries.EndRun(job.Name)
+	pm.AlertSeries.EndRun(job.Name)
+	job.Log().Infof("Job Completed.")
 	return nil
 }
 
+var (
+	spotPriceDesc = prometheus.NewDesc(
+		"ecsspotprice",
+		"Spot price for ecs instance.",
+		[]string{"zoneid", "type"}, nil,
+	)
+	listPriceDesc = prometheus.NewDesc(
+		"ecslistprice",
+		"List price for ecs instance.",
+		[]string{"zoneid", "type"}, nil,
+	)
+)
+
+// newSpotPriceCollector exposes the same price series as querySpotPrice, queried when scraped.
+func newSpotPriceCollector(name string, aliClient *alicloud.AliClient, queryFlags alicloud.QuerySpotPriceFlags) *collector.CachedCollector {
+	return collector.NewCachedCollector(name, queryFlags.CacheTTL, []*prometheus.Desc{spotPriceDesc, listPriceDesc}, func() ([]prometheus.Metric, error) {
+		instanceTypes, err := queryEcsTypesInk8s(nil, aliClient, queryFlags.PageSize)
+		if err != nil {
+			return nil, err
+		}
+		metrics := []prometheus.Metric{}
+		for _, instanceType := range instanceTypes {
+			zoneList := []string{}
+			spotPrices, err := alicloud.QuerySpotPrice(aliClient, instanceType)
+			if err != nil {
+				return nil, err
+			}
+			for _, spotPrice := range spotPrices {
+				if !stringInList(spotPrice.ZoneId, zoneList) {
+					metrics = append(metrics, prometheus.MustNewConstMetric(spotPriceDesc, prometheus.GaugeValue, spotPrice.SpotPrice, spotPrice.ZoneId, instanceType))
+					metrics = append(metrics, prometheus.MustNewConstMetric(listPriceDesc, prometheus.GaugeValue, spotPrice.OriginPrice, spotPrice.ZoneId, instanceType))
+					zoneList = append(zoneList, spotPrice.ZoneId)
+				}
+			}
+		}
+		return metrics, nil
+	})
+}
+
+func spotPriceServeJob(env *serveEnv, jobCfg serveJobConfig) func() error {
+	queryFlags := alicloud.QuerySpotPriceFlags{
+		InstanceTypes: types.ArgList{},
+		Region:        jobCfg.Region,
+		PageSize:      jobCfg.PageSize,
+		Cron:          jobCfg.Cron,
+		Mode:          jobCfg.Mode,
+		CacheTTL:      jobCfg.CacheTTL,
+	}
+	if queryFlags.Mode == collectorMode {
+		env.reg.MustRegister(newSpotPriceCollector(jobCfg.Name, env.aliClient, queryFlags))
+		return nil
+	}
+	evaluator, err := newSpotAlertEvaluator(queryFlags)
+	if err != nil {
+		log.Logger.Errorf("job %s: %v", jobCfg.Name, err)
+		os.Exit(1)
+	}
+	pm := env.getSpotMonitor()
+	pm.SpotPriceWatchdog.With(prometheus.Labels{"name": spotPriceCmd.Use}).Set(1)
+	jobLock := &joblock.JobLock{Name: jobCfg.Name}
+	return func() error {
+		return querySpotPrice(jobLock, env.aliClient, queryFlags, pm, evaluator)
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(spotPriceCmd)
+	serveJobs["spotprice"] = spotPriceServeJob
 	f := spotPriceCmd.Flags()
 	f.IntVarP(&spotPriceQueryFlags.PageSize, "pagesize", "s", 10, "alicloud api pagesize")
 	f.StringVarP(&spotPriceQueryFlags.Region, "region", "r", "us-east-1", "query region")
 	f.StringVarP(&spotPriceQueryFlags.Cron, "cron", "c", "", "cron scheduler")
+	f.StringVarP(&spotPriceQueryFlags.Mode, "mode", "", gaugeMode, "gauge: query on cron schedule, collector: query when scraped")
+	f.DurationVarP(&spotPriceQueryFlags.CacheTTL, "cachettl", "", time.Minute, "how long a query result is reused in collector mode")
+	f.Float64VarP(&spotPriceQueryFlags.MinDiscount, "mindiscount", "", 0, "alert when the spot discount from the list price is below this percent, 0 disables the alert")
+	f.Float64VarP(&spotPriceQueryFlags.MaxPrice, "maxprice", "", 0, "alert when the spot price is above this price, 0 disables the alert")
+	f.Float64VarP(&spotPriceQueryFlags.Hysteresis, "hysteresis", "", 0, "resolve a discount alert this many percentage points above mindiscount and a price alert this percent below maxprice")
+	f.DurationVarP(&spotPriceQueryFlags.AlertFor, "alertfor", "", 0, "fire an alert only after its condition held for this long")
 }
@@ -0,0 +1,226 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/collector"
+	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+	"github.com/allanhung/alicloud-monitoring/pkg/types"
+)
+
+var stockQueryFlags = alicloud.QueryStockFlags{}
+
+type stockState struct {
+	zoneId       string
+	instanceType string
+	chargeType   string
+	status       string
+}
+
+// stockCmd represents the stock command
+var stockCmd = &cobra.Command{
+	Use:   "stock",
+	Short: "Query stock of instance types.",
+	Long: `This tool will query the stock status of instance types per zone and charge type,
+without --type the spot instance types of kubernetes workers are queried.
+
+example:
+  alicloud-monitoring stock --type ecs.g6.xlarge,ecs.c6.2xlarge --chargetype Spot,PostPaid
+  alicloud-monitoring stock --cron '0 */5 * * * *'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		reg := monitor.NewRegistry(runtimeMetrics)
+		var pm *monitor.StockMonitor
+		var c *cron.Cron
+		jobLock := &joblock.JobLock{Name: cmd.Use}
+
+		if err := validateMode(stockQueryFlags.Mode); err != nil {
+			log.Logger.Errorf("%v", err)
+			os.Exit(1)
+		}
+		if len(stockQueryFlags.ChargeTypes) == 0 {
+			stockQueryFlags.ChargeTypes = append(stockQueryFlags.ChargeTypes, alicloud.ChargeTypeSpot, alicloud.ChargeTypePostPaid)
+		}
+		if stockQueryFlags.Mode != collectorMode {
+			pm = monitor.NewStockMonitor(reg)
+		}
+		aliClient := newAliClient(reg)
+		defer aliClient.Close()
+
+		if stockQueryFlags.Mode == collectorMode {
+			reg.MustRegister(newStockCollector(cmd.Use, aliClient, stockQueryFlags))
+		} else if stockQueryFlags.Cron == "" {
+			err := queryStock(jobLock, aliClient, stockQueryFlags, pm)
+			monitor.SetJobResult(cmd.Use, err)
+			if err != nil {
+				log.Logger.Errorf("%v", err)
+				os.Exit(1)
+			}
+		} else {
+			c = cron.New(cron.WithSeconds())
+			c.AddFunc(stockQueryFlags.Cron, func() {
+				err := queryStock(jobLock, aliClient, stockQueryFlags, pm)
+				if err != nil {
+					log.Logger.Errorf("%v", err)
+				}
+				monitor.SetJobResult(cmd.Use, err)
+			})
+			fmt.Printf("start: %v", time.Now())
+			c.Start()
+		}
+		err := monitor.PrometheusBoot(reg, webListenAddress, webConfigFile)
+		if c != nil {
+			c.Stop()
+		}
+		if err != nil {
+			log.Logger.Errorf("failed to ListenAndServe: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func getStockStates(aliClient *alicloud.AliClient, queryFlags alicloud.QueryStockFlags) ([]stockState, error) {
+	instanceTypes := []string(queryFlags.InstanceTypes)
+	if len(instanceTypes) == 0 {
+		var err error
+		instanceTypes, err = queryEcsTypesInk8s(nil, aliClient, queryFlags.PageSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	states := []stockState{}
+	for _, instanceType := range instanceTypes {
+		for _, chargeType := range queryFlags.ChargeTypes {
+			stock, err := alicloud.QueryInstanceTypeStock(aliClient, instanceType, chargeType)
+			if err != nil {
+				return nil, err
+			}
+			zones := []string{}
+			for zoneId := range stock {
+				zones = append(zones, zoneId)
+			}
+			sort.Strings(zones)
+			for _, zoneId := range zones {
+				states = append(states, stockState{zoneId: zoneId, instanceType: instanceType, chargeType: chargeType, status: stock[zoneId]})
+				log.Logger.Debugf("Instance Type: %s, Zone: %s, Charge Type: %s, Stock: %s", instanceType, zoneId, chargeType, stock[zoneId])
+			}
+		}
+	}
+	return states, nil
+}
+
+func queryStock(job *joblock.JobLock, aliClient *alicloud.AliClient, queryFlags alicloud.QueryStockFlags, pm *monitor.StockMonitor) error {
+	if job.IsRunning {
+		return fmt.Errorf("job is still running: %s", job.Kind)
+	} else {
+		job.SetRun("Checking stock")
+	}
+	defer job.DoneRun()
+	job.Log().Infof("Running job: %s", job.Kind)
+
+	states, err := getStockStates(aliClient, queryFlags)
+	if err != nil {
+		return err
+	}
+	for _, state := range states {
+		for _, status := range alicloud.StockStatuses {
+			value := 0.0
+			if status == state.status {
+				value = 1
+			}
+			pm.StockSeries.Set(job.Name, prometheus.Labels{"zoneid": state.zoneId, "type": state.instanceType, "chargetype": state.chargeType, "status": status}, value)
+		}
+		if state.status != "WithStock" {
+			job.Log().Warnf("instance type %s (%s) in %s: %s", state.instanceType, state.chargeType, state.zoneId, state.status)
+		}
+	}
+	pm.StockSeries.EndRun(job.Name)
+	job.Log().Infof("Job Completed.")
+	return nil
+}
+
+var stockDesc = prometheus.NewDesc(
+	"ecsstock",
+	"Stock status of ecs instance type, 1 for the current status.",
+	[]string{"zoneid", "type", "chargetype", "status"}, nil,
+)
+
+// newStockCollector exposes the same stock series as queryStock, queried when scraped.
+func newStockCollector(name string, aliClient *alicloud.AliClient, queryFlags alicloud.QueryStockFlags) *collector.CachedCollector {
+	return collector.NewCachedCollector(name, queryFlags.CacheTTL, []*prometheus.Desc{stockDesc}, func() ([]prometheus.Metric, error) {
+		states, err := getStockStates(aliClient, queryFlags)
+		if err != nil {
+			return nil, err
+		}
+		metrics := []prometheus.Metric{}
+		for _, state := range states {
+			for _, status := range alicloud.StockStatuses {
+				value := 0.0
+				if status == state.status {
+					value = 1
+				}
+				metrics = append(metrics, prometheus.MustNewConstMetric(stockDesc, prometheus.GaugeValue, value, state.zoneId, state.instanceType, state.chargeType, status))
+			}
+		}
+		return metrics, nil
+	})
+}
+
+func stockServeJob(env *serveEnv, jobCfg serveJobConfig) func() error {
+	queryFlags := alicloud.QueryStockFlags{
+		InstanceTypes: types.ArgList(jobCfg.InstanceTypes),
+		ChargeTypes:   types.ArgList(jobCfg.ChargeTypes),
+		PageSize:      jobCfg.PageSize,
+		Cron:          jobCfg.Cron,
+		Mode:          jobCfg.Mode,
+		CacheTTL:      jobCfg.CacheTTL,
+	}
+	if len(queryFlags.ChargeTypes) == 0 {
+		queryFlags.ChargeTypes = append(queryFlags.ChargeTypes, alicloud.ChargeTypeSpot, alicloud.ChargeTypePostPaid)
+	}
+	if queryFlags.Mode == collectorMode {
+		env.reg.MustRegister(newStockCollector(jobCfg.Name, env.aliClient, queryFlags))
+		return nil
+	}
+	pm := env.getStockMonitor()
+	jobLock := &joblock.JobLock{Name: jobCfg.Name}
+	return func() error {
+		return queryStock(jobLock, env.aliClient, queryFlags, pm)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(stockCmd)
+	serveJobs["stock"] = stockServeJob
+	f := stockCmd.Flags()
+	f.IntVarP(&stockQueryFlags.PageSize, "pagesize", "s", 10, "alicloud api pagesize")
+	f.VarP(&stockQueryFlags.InstanceTypes, "type", "t", "instance type to check, default the spot instance types of kubernetes workers (can specify multiple)")
+	f.VarP(&stockQueryFlags.ChargeTypes, "chargetype", "", "Spot or PostPaid, default both (can specify multiple)")
+	f.StringVarP(&stockQueryFlags.Cron, "cron", "c", "", "cron scheduler")
+	f.StringVarP(&stockQueryFlags.Mode, "mode", "", gaugeMode, "gauge: query on cron schedule, collector: query when scraped")
+	f.DurationVarP(&stockQueryFlags.CacheTTL, "cachettl", "", time.Minute, "how long a query result is reused in collector mode")
+}
@@ -0,0 +1,55 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/spf13/viper"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/tagnorm"
+)
+
+var tagNormalizer *tagnorm.Normalizer
+
+// initTagNormalizer builds the tag key aliases and value synonyms from the "normalize" section of the config file.
+func initTagNormalizer() {
+	cfg := tagnorm.DefaultConfig()
+	if viper.IsSet("normalize") {
+		cfg = tagnorm.Config{}
+		if err := viper.UnmarshalKey("normalize", &cfg); err != nil {
+			log.Logger.Errorf("failed to load normalize config: %v", err)
+			os.Exit(1)
+		}
+	}
+	n, err := tagnorm.NewNormalizer(cfg)
+	if err != nil {
+		log.Logger.Errorf("failed to create tag normalizer: %v", err)
+		os.Exit(1)
+	}
+	tagNormalizer = n
+}
+
+// instanceTags returns the tags of an instance as a map.
+func instanceTags(instance ecs.Instance) map[string]string {
+	m := map[string]string{}
+	for _, tag := range instance.Tags.Tag {
+		m[tag.TagKey] = tag.TagValue
+	}
+	return m
+}
@@ -18,6 +18,8 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
@@ -29,6 +31,8 @@ import (
 	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
 	"github.com/allanhung/alicloud-monitoring/pkg/log"
 	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+	"github.com/allanhung/alicloud-monitoring/pkg/notify"
+	"github.com/allanhung/alicloud-monitoring/pkg/plan"
 )
 
 var updateK8sTagsCmdFlags = alicloud.QueryEcsFlags{}
@@ -43,41 +47,47 @@ example:
   alicloud-monitoring updatek8stags --logfile /tmp/ecs_update.log --loglevel debug
   alicloud-monitoring updatek8stags --cron '0 * * * * *'`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if err := planGuard(updateK8sTagsCmdFlags).Validate(); err != nil {
+			log.Logger.Errorf("%v", err)
+			os.Exit(1)
+		}
 
-		pm := monitor.NewTagsMonitor()
+		reg := monitor.NewRegistry(runtimeMetrics)
+		pm := monitor.NewTagsMonitor(reg)
+		am := monitor.NewApprovalMonitor(reg)
 		var c *cron.Cron
-		jobLock := joblock.JobLock{}
-		instanceList := map[string]ecs.Instance{}
+		jobLock := &joblock.JobLock{Name: cmd.Use}
+		instanceList := newUntaggedInstances()
+		updateK8sTagsCmdFlags.Normalizer = tagNormalizer
 
 		pm.NoEnvTagWatchdog.With(prometheus.Labels{"name": cmd.Use}).Set(1)
-		cfg := &alicloud.AliCloudConfig{}
-		err := cfg.GetCloudConfig()
-		if err != nil {
-			log.Logger.Errorf("failed to getCloudConfigFromStsToken: %v", err)
-			os.Exit(1)
-		}
-
-		aliClient, err := alicloud.NewAliClient(cfg)
-		if err != nil {
-			log.Logger.Errorf("failed to create aliClient: %v", err)
-			os.Exit(1)
-		}
+		aliClient := newAliClient(reg)
+		defer aliClient.Close()
 
 		if updateK8sTagsCmdFlags.Cron == "" {
-			err := addk8sTags(jobLock, aliClient, pm, instanceList)
+			err := addk8sTags(jobLock, aliClient, updateK8sTagsCmdFlags, pm, am, instanceList)
+			monitor.SetJobResult(cmd.Use, err)
 			if err != nil {
 				log.Logger.Errorf("%v", err)
 				os.Exit(1)
 			}
-			log.Logger.Debugf("%v", instanceList)
+			log.Logger.Debugf("%v", instanceList.instances)
 		} else {
 			c = cron.New(cron.WithSeconds())
-			c.AddFunc(updateK8sTagsCmdFlags.Cron, func() { addk8sTags(jobLock, aliClient, pm, instanceList) })
+			c.AddFunc(updateK8sTagsCmdFlags.Cron, func() {
+				err := addk8sTags(jobLock, aliClient, updateK8sTagsCmdFlags, pm, am, instanceList)
+				if err != nil {
+					log.Logger.Errorf("%v", err)
+				}
+				monitor.SetJobResult(cmd.Use, err)
+			})
 			fmt.Printf("start: %v", time.Now())
 			c.Start()
 		}
-		err = monitor.PrometheusBoot()
-		c.Stop()
+		err := monitor.PrometheusBoot(reg, webListenAddress, webConfigFile)
+		if c != nil {
+			c.Stop()
+		}
 		if err != nil {
 			log.Logger.Errorf("failed to ListenAndServe: %v", err)
 			os.Exit(1)
@@ -85,25 +95,43 @@ example:
 	},
 }
 
-func addk8sTags(job joblock.JobLock, aliClient *alicloud.AliClient, pm *monitor.TagsMonitor, instanceList map[string]ecs.Instance) error {
-	queryECStag(job, aliClient, updateK8sTagsCmdFlags, pm, instanceList)
+func addk8sTags(job *joblock.JobLock, aliClient *alicloud.AliClient, queryFlags alicloud.QueryEcsFlags, pm *monitor.TagsMonitor, am *monitor.ApprovalMonitor, instanceList *untaggedInstances) error {
 	if job.IsRunning {
 		return fmt.Errorf("job is still running: %s", job.Kind)
 	} else {
 		job.SetRun("Update")
 	}
+	defer job.DoneRun()
 
-	log.Logger.Infof("Running job: %s", job.Kind)
-	vpcMap, err := getVPCInfo(aliClient, ecsCmdFlags.PageSize)
+	logger := job.Log()
+	logger.Infof("Running job: %s", job.Kind)
+	vpcMap, err := queryUntagged(job, aliClient, queryFlags, pm, instanceList, nil)
+	if err != nil {
+		return err
+	}
+	state, err := loadManagedInstances(queryFlags)
 	if err != nil {
-		return fmt.Errorf("failed to get VPC information: %v", err)
+		return err
 	}
-	for k, v := range instanceList {
-		if (updateK8sTagsCmdFlags.InstanceId == "") || (updateK8sTagsCmdFlags.InstanceId != "" && k == updateK8sTagsCmdFlags.InstanceId) {
+	envKey := tagNormalizer.CanonicalKey("Environment")
+	changes := []plan.Change{}
+	managedLines := []string{}
+	environments := map[string]string{}
+	// the vpc label of the notag series is the environment name as queryUntagged set it
+	vpcLabels := map[string]string{}
+	for k, v := range instanceList.instances {
+		if (queryFlags.InstanceId == "") || (queryFlags.InstanceId != "" && k == queryFlags.InstanceId) {
+			env := vpcMap[v.VpcAttributes.VpcId]
+			if env.Name == "" {
+				logger.WithField(log.FieldInstanceID, k).Warnf("no environment for vpc %s, will not update", v.VpcAttributes.VpcId)
+				continue
+			}
+			vpcLabels[k] = env.Name
+			env.Name = tagNormalizer.CanonicalValue(envKey, env.Name)
 			k8sTag := []ecs.AddTagsTag{
 				{
-					Key:   "Environment",
-					Value: vpcMap[v.VpcAttributes.VpcId],
+					Key:   envKey,
+					Value: env.Name,
 				},
 				{
 					Key:   "role",
@@ -114,26 +142,103 @@ func addk8sTags(job joblock.JobLock, aliClient *alicloud.AliClient, pm *monitor.
 					Value: "kubernetes",
 				},
 			}
-			log.Logger.Infof("InstanceId：%s start update", k)
-			err := alicloud.AddInstanceTags(aliClient, v, k8sTag)
-			if err != nil {
-				log.Logger.Errorf("InstanceId：%s failed to add tag. error: %v", k, err)
+			change := tagChange(v, k8sTag)
+			if skip, line := skipManaged(job, state, queryFlags, k, v.InstanceName, change.String()); skip {
+				if line != "" {
+					managedLines = append(managedLines, line)
+				}
+				continue
 			}
-			pm.NoEnvTag.With(prometheus.Labels{"id": k, "vpc": vpcMap[v.VpcAttributes.VpcId], "name": v.InstanceName}).Set(0)
+			changes = append(changes, change)
+			environments[k] = env.Name
 		} else {
-			log.Logger.Debugf("InstanceId：%s != %s will not update", k, updateK8sTagsCmdFlags.InstanceId)
+			logger.WithField(log.FieldInstanceID, k).Debugf("%s != %s will not update", k, queryFlags.InstanceId)
+		}
+	}
+	notifyManaged(job, "updatek8stags", managedLines)
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].InstanceId < changes[j].InstanceId
+	})
+	if guardChanges(job, aliClient, planGuard(queryFlags), am, changes) {
+		return nil
+	}
+
+	taggedIds := []string{}
+	taggedLines := []string{}
+	tagged := 0
+	for _, change := range changes {
+		err := applyTagChange(job, aliClient, change, queryFlags.DryRun)
+		if queryFlags.DryRun {
+			taggedLines = append(taggedLines, fmt.Sprintf("%s (%s) Environment=%s (dry run)", change.InstanceId, change.InstanceName, environments[change.InstanceId]))
+		} else if err != nil {
+			taggedLines = append(taggedLines, fmt.Sprintf("%s (%s) failed: %v", change.InstanceId, change.InstanceName, err))
+		} else {
+			taggedLines = append(taggedLines, fmt.Sprintf("%s (%s) Environment=%s", change.InstanceId, change.InstanceName, environments[change.InstanceId]))
+			tagged++
+		}
+		taggedIds = append(taggedIds, change.InstanceId)
+		if !queryFlags.DryRun && err == nil {
+			pm.NoEnvTagSeries.Set(job.Name, prometheus.Labels{"id": change.InstanceId, "vpc": vpcLabels[change.InstanceId], "name": change.InstanceName}, 0)
+		}
+	}
+
+	if len(taggedIds) > 0 {
+		sort.Strings(taggedIds)
+		sort.Strings(taggedLines)
+		title := fmt.Sprintf("%d kubernetes workers tagged", tagged)
+		if queryFlags.DryRun {
+			title = fmt.Sprintf("%d kubernetes workers would be tagged (dry run)", len(taggedIds))
+		} else if tagged < len(taggedIds) {
+			title = fmt.Sprintf("%d of %d kubernetes workers tagged", tagged, len(taggedIds))
+		}
+		err := notifier.Notify(notify.Message{
+			Key:   "tagged/" + strings.Join(taggedIds, ","),
+			Kind:  "updatek8stags",
+			Title: title,
+			Lines: taggedLines,
+		})
+		if err != nil {
+			logger.Errorf("failed to notify tagged instances: %v", err)
 		}
 	}
-	job.DoneRun()
 	return nil
 }
 
+func updateK8sTagsServeJob(env *serveEnv, jobCfg serveJobConfig) func() error {
+	queryFlags := jobCfg.queryEcsFlags()
+	if len(queryFlags.NoTagKey) == 0 {
+		queryFlags.NoTagKey = append(queryFlags.NoTagKey, "Environment")
+	}
+	if len(queryFlags.ReName) == 0 {
+		queryFlags.ReName = append(queryFlags.ReName, "worker-k8s.*")
+	}
+	if err := planGuard(queryFlags).Validate(); err != nil {
+		log.Logger.Errorf("job %s: %v", jobCfg.Name, err)
+		os.Exit(1)
+	}
+	pm := env.getTagsMonitor()
+	am := env.getApprovalMonitor()
+	pm.NoEnvTagWatchdog.With(prometheus.Labels{"name": updateK8sTagsCmd.Use}).Set(1)
+	jobLock := &joblock.JobLock{Name: jobCfg.Name}
+	instanceList := newUntaggedInstances()
+	return func() error {
+		return addk8sTags(jobLock, env.aliClient, queryFlags, pm, am, instanceList)
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(updateK8sTagsCmd)
+	serveJobs["updatek8stags"] = updateK8sTagsServeJob
 	f := updateK8sTagsCmd.Flags()
 	updateK8sTagsCmdFlags.NoTagKey = append(updateK8sTagsCmdFlags.NoTagKey, "Environment")
 	updateK8sTagsCmdFlags.ReName = append(updateK8sTagsCmdFlags.ReName, "worker-k8s.*")
 	f.StringVarP(&updateK8sTagsCmdFlags.InstanceId, "instanceid", "i", "", "filter by instance id")
 	f.IntVarP(&updateK8sTagsCmdFlags.PageSize, "pagesize", "s", 10, "alicloud api pagesize")
 	f.StringVarP(&updateK8sTagsCmdFlags.Cron, "cron", "c", "", "cron scheduler")
+	f.BoolVarP(&updateK8sTagsCmdFlags.DryRun, "dryrun", "", false, "only log and audit the tags that would be added")
+	f.IntVarP(&updateK8sTagsCmdFlags.MaxChanges, "maxchanges", "", 0, "block runs tagging more instances than this until the plan is approved with apply, 0 disables the limit")
+	f.StringVarP(&updateK8sTagsCmdFlags.PlanDir, "plandir", "", "", "write plans of blocked runs to this directory")
+	f.StringVarP(&updateK8sTagsCmdFlags.PlanWebhook, "planwebhook", "", "", "post plans of blocked runs as json to this url")
+	f.VarP(&updateK8sTagsCmdFlags.TerraformState, "tfstate", "", "local terraform state file, instances declared in it are not tagged (can specify multiple)")
+	f.StringVarP(&updateK8sTagsCmdFlags.IacMode, "iacmode", "", "skip", "skip: leave terraform managed instances out, report: also log and notify the tags they are missing")
 }
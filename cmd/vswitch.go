@@ -0,0 +1,289 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/alicloud"
+	"github.com/allanhung/alicloud-monitoring/pkg/collector"
+	"github.com/allanhung/alicloud-monitoring/pkg/joblock"
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+)
+
+// vswitchReservedIps is the number of addresses alicloud reserves in every vswitch,
+// the first and the last three addresses of the cidr block.
+const vswitchReservedIps = 4
+
+var vswitchQueryFlags = alicloud.QueryVSwitchFlags{}
+
+type ipCapacity struct {
+	labels    []string
+	available float64
+	total     float64
+	usable    float64
+}
+
+func (c ipCapacity) utilization() float64 {
+	if c.usable <= 0 {
+		return 0
+	}
+	return (c.usable - c.available) / c.usable
+}
+
+func (c ipCapacity) labelMap(names []string) prometheus.Labels {
+	labels := prometheus.Labels{}
+	for i, name := range names {
+		labels[name] = c.labels[i]
+	}
+	return labels
+}
+
+// vswitchCmd represents the vswitch command
+var vswitchCmd = &cobra.Command{
+	Use:   "vswitch",
+	Short: "Query ip capacity of vswitches.",
+	Long: `This tool will query available ip addresses, cidr size and utilization of vswitches per vswitch and zone.
+
+example:
+  alicloud-monitoring vswitch --vpcid vpc-xxxxxxxx
+  alicloud-monitoring vswitch --cron '0 */5 * * * *'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		reg := monitor.NewRegistry(runtimeMetrics)
+		var pm *monitor.VpcMonitor
+		var c *cron.Cron
+		jobLock := &joblock.JobLock{Name: cmd.Use}
+
+		if err := validateMode(vswitchQueryFlags.Mode); err != nil {
+			log.Logger.Errorf("%v", err)
+			os.Exit(1)
+		}
+		if vswitchQueryFlags.Mode != collectorMode {
+			pm = monitor.NewVpcMonitor(reg)
+		}
+		aliClient := newAliClient(reg)
+		defer aliClient.Close()
+
+		if vswitchQueryFlags.Mode == collectorMode {
+			reg.MustRegister(newVSwitchCollector(cmd.Use, aliClient, vswitchQueryFlags))
+		} else if vswitchQueryFlags.Cron == "" {
+			err := queryVSwitch(jobLock, aliClient, vswitchQueryFlags, pm)
+			monitor.SetJobResult(cmd.Use, err)
+			if err != nil {
+				log.Logger.Errorf("%v", err)
+				os.Exit(1)
+			}
+		} else {
+			c = cron.New(cron.WithSeconds())
+			c.AddFunc(vswitchQueryFlags.Cron, func() {
+				err := queryVSwitch(jobLock, aliClient, vswitchQueryFlags, pm)
+				if err != nil {
+					log.Logger.Errorf("%v", err)
+				}
+				monitor.SetJobResult(cmd.Use, err)
+			})
+			fmt.Printf("start: %v", time.Now())
+			c.Start()
+		}
+		err := monitor.PrometheusBoot(reg, webListenAddress, webConfigFile)
+		if c != nil {
+			c.Stop()
+		}
+		if err != nil {
+			log.Logger.Errorf("failed to ListenAndServe: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// getVSwitchCapacity returns the ip capacity per vswitch and per vpc zone.
+func getVSwitchCapacity(aliClient *alicloud.AliClient, queryFlags alicloud.QueryVSwitchFlags) ([]ipCapacity, []ipCapacity, error) {
+	allVpcs, err := alicloud.QueryVpc(aliClient, queryFlags.PageSize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get VPC information: %v", err)
+	}
+	vpcNames := map[string]string{}
+	for _, vpc := range allVpcs {
+		vpcNames[vpc.VpcId] = vpc.VpcName
+	}
+	vswitches, err := alicloud.QueryVSwitches(aliClient, queryFlags.VpcId, queryFlags.PageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vswitchList := []ipCapacity{}
+	zoneMap := map[string]*ipCapacity{}
+	for _, vswitch := range vswitches {
+		_, cidr, err := net.ParseCIDR(vswitch.CidrBlock)
+		if err != nil {
+			log.Logger.Warnf("vswitch: %s (%s) has invalid cidr block %s: %v", vswitch.VSwitchId, vswitch.VSwitchName, vswitch.CidrBlock, err)
+			continue
+		}
+		ones, bits := cidr.Mask.Size()
+		total := float64(uint64(1) << uint(bits-ones))
+		capacity := ipCapacity{
+			labels:    []string{vswitch.VSwitchId, vswitch.VSwitchName, vswitch.VpcId, vpcNames[vswitch.VpcId], vswitch.ZoneId, vswitch.CidrBlock},
+			available: float64(vswitch.AvailableIpAddressCount),
+			total:     total,
+			usable:    total - vswitchReservedIps,
+		}
+		vswitchList = append(vswitchList, capacity)
+		log.Logger.Debugf("vswitch: %s (%s), zone: %s, cidr: %s, available: %v, utilization: %.2f", vswitch.VSwitchId, vswitch.VSwitchName, vswitch.ZoneId, vswitch.CidrBlock, capacity.available, capacity.utilization())
+
+		zoneKey := vswitch.VpcId + "/" + vswitch.ZoneId
+		zone, ok := zoneMap[zoneKey]
+		if !ok {
+			zone = &ipCapacity{labels: []string{vswitch.VpcId, vpcNames[vswitch.VpcId], vswitch.ZoneId}}
+			zoneMap[zoneKey] = zone
+		}
+		zone.available += capacity.available
+		zone.total += capacity.total
+		zone.usable += capacity.usable
+	}
+
+	zoneKeys := []string{}
+	for key := range zoneMap {
+		zoneKeys = append(zoneKeys, key)
+	}
+	sort.Strings(zoneKeys)
+	zoneList := []ipCapacity{}
+	for _, key := range zoneKeys {
+		zoneList = append(zoneList, *zoneMap[key])
+	}
+	return vswitchList, zoneList, nil
+}
+
+func queryVSwitch(job *joblock.JobLock, aliClient *alicloud.AliClient, queryFlags alicloud.QueryVSwitchFlags, pm *monitor.VpcMonitor) error {
+	if job.IsRunning {
+		return fmt.Errorf("job is still running: %s", job.Kind)
+	} else {
+		job.SetRun("Checking vswitch capacity")
+	}
+	defer job.DoneRun()
+	job.Log().Infof("Running job: %s", job.Kind)
+
+	vswitchList, zoneList, err := getVSwitchCapacity(aliClient, queryFlags)
+	if err != nil {
+		return err
+	}
+	for _, vswitch := range vswitchList {
+		labels := vswitch.labelMap(monitor.VSwitchLabels)
+		pm.VSwitchAvailableSeries.Set(job.Name, labels, vswitch.available)
+		pm.VSwitchTotalSeries.Set(job.Name, labels, vswitch.total)
+		pm.VSwitchUtilSeries.Set(job.Name, labels, vswitch.utilization())
+	}
+	for _, zone := range zoneList {
+		labels := zone.labelMap(monitor.ZoneLabels)
+		pm.ZoneAvailableSeries.Set(job.Name, labels, zone.available)
+		pm.ZoneUtilSeries.Set(job.Name, labels, zone.utilization())
+	}
+	pm.VSwitchAvailableSeries.EndRun(job.Name)
+	pm.VSwitchTotalSeries.EndRun(job.Name)
+	pm.VSwitchUtilSeries.EndRun(job.Name)
+	pm.ZoneAvailableSeries.EndRun(job.Name)
+	pm.ZoneUtilSeries.EndRun(job.Name)
+	job.Log().Infof("Job Completed. %d vswitches in %d zones", len(vswitchList), len(zoneList))
+	return nil
+}
+
+var (
+	vswitchAvailableDesc = prometheus.NewDesc(
+		"vswitchavailableips",
+		"Number of available ip addresses in vswitch.",
+		monitor.VSwitchLabels, nil,
+	)
+	vswitchTotalDesc = prometheus.NewDesc(
+		"vswitchtotalips",
+		"Number of ip addresses in the cidr block of vswitch.",
+		monitor.VSwitchLabels, nil,
+	)
+	vswitchUtilDesc = prometheus.NewDesc(
+		"vswitchutilization",
+		"Ratio of used to usable ip addresses in vswitch.",
+		monitor.VSwitchLabels, nil,
+	)
+	zoneAvailableDesc = prometheus.NewDesc(
+		"vswitchzoneavailableips",
+		"Number of available ip addresses in all vswitches of a vpc zone.",
+		monitor.ZoneLabels, nil,
+	)
+	zoneUtilDesc = prometheus.NewDesc(
+		"vswitchzoneutilization",
+		"Ratio of used to usable ip addresses in all vswitches of a vpc zone.",
+		monitor.ZoneLabels, nil,
+	)
+)
+
+// newVSwitchCollector exposes the same capacity series as queryVSwitch, queried when scraped.
+func newVSwitchCollector(name string, aliClient *alicloud.AliClient, queryFlags alicloud.QueryVSwitchFlags) *collector.CachedCollector {
+	descs := []*prometheus.Desc{vswitchAvailableDesc, vswitchTotalDesc, vswitchUtilDesc, zoneAvailableDesc, zoneUtilDesc}
+	return collector.NewCachedCollector(name, queryFlags.CacheTTL, descs, func() ([]prometheus.Metric, error) {
+		vswitchList, zoneList, err := getVSwitchCapacity(aliClient, queryFlags)
+		if err != nil {
+			return nil, err
+		}
+		metrics := []prometheus.Metric{}
+		for _, vswitch := range vswitchList {
+			metrics = append(metrics, prometheus.MustNewConstMetric(vswitchAvailableDesc, prometheus.GaugeValue, vswitch.available, vswitch.labels...))
+			metrics = append(metrics, prometheus.MustNewConstMetric(vswitchTotalDesc, prometheus.GaugeValue, vswitch.total, vswitch.labels...))
+			metrics = append(metrics, prometheus.MustNewConstMetric(vswitchUtilDesc, prometheus.GaugeValue, vswitch.utilization(), vswitch.labels...))
+		}
+		for _, zone := range zoneList {
+			metrics = append(metrics, prometheus.MustNewConstMetric(zoneAvailableDesc, prometheus.GaugeValue, zone.available, zone.labels...))
+			metrics = append(metrics, prometheus.MustNewConstMetric(zoneUtilDesc, prometheus.GaugeValue, zone.utilization(), zone.labels...))
+		}
+		return metrics, nil
+	})
+}
+
+func vswitchServeJob(env *serveEnv, jobCfg serveJobConfig) func() error {
+	queryFlags := alicloud.QueryVSwitchFlags{
+		PageSize: jobCfg.PageSize,
+		VpcId:    jobCfg.VpcId,
+		Cron:     jobCfg.Cron,
+		Mode:     jobCfg.Mode,
+		CacheTTL: jobCfg.CacheTTL,
+	}
+	if queryFlags.Mode == collectorMode {
+		env.reg.MustRegister(newVSwitchCollector(jobCfg.Name, env.aliClient, queryFlags))
+		return nil
+	}
+	pm := env.getVpcMonitor()
+	jobLock := &joblock.JobLock{Name: jobCfg.Name}
+	return func() error {
+		return queryVSwitch(jobLock, env.aliClient, queryFlags, pm)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(vswitchCmd)
+	serveJobs["vswitch"] = vswitchServeJob
+	f := vswitchCmd.Flags()
+	f.IntVarP(&vswitchQueryFlags.PageSize, "pagesize", "s", 10, "alicloud api pagesize")
+	f.StringVarP(&vswitchQueryFlags.VpcId, "vpcid", "", "", "only query vswitches of this vpc")
+	f.StringVarP(&vswitchQueryFlags.Cron, "cron", "c", "", "cron scheduler")
+	f.StringVarP(&vswitchQueryFlags.Mode, "mode", "", gaugeMode, "gauge: query on cron schedule, collector: query when scraped")
+	f.DurationVarP(&vswitchQueryFlags.CacheTTL, "cachettl", "", time.Minute, "how long a query result is reused in collector mode")
+}
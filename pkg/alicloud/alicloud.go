@@ -17,14 +17,15 @@ package alicloud
 
 import (
 	"fmt"
-	"net/http"
-	"os"
 	"sync"
 	"time"
 
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/cms"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ess"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/sts"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/vpc"
 
-	"github.com/denverdino/aliyungo/metadata"
 	"github.com/allanhung/alicloud-monitoring/pkg/log"
 )
 
@@ -36,143 +37,205 @@ type AliCloudConfig struct {
 	RoleName        string    `json:"-" yaml:"-"` // For ECS RAM role only
 	StsToken        string    `json:"-" yaml:"-"`
 	ExpireTime      time.Time `json:"-" yaml:"-"`
+	Provider        string    `json:"-" yaml:"-"`
 }
 
+// AliClient holds the alibaba cloud api clients, use the accessors so requests never see a client being replaced by a refresh.
 type AliClient struct {
-	RegionID   string
-	EcsClient  ecs.Client
+	regionID   string
+	ecsClient  *ecs.Client
+	vpcClient  *vpc.Client
+	cmsClient  *cms.Client
+	essClient  *ess.Client
+	stsClient  *sts.Client
 	clientLock sync.RWMutex
-	nextExpire time.Time
+	refresher  *CredentialRefresher
 }
 
+// GetCloudConfig loads credentials from the first configured provider of the default chain:
+// credential file, env vars, aliyun cli profile, RRSA OIDC token and finally the ECS metadata RAM role.
 func (a *AliCloudConfig) GetCloudConfig() error {
-	roleName := ""
-	if os.Getenv("ALICLOUD_REGION") == "" ||
-		os.Getenv("ALICLOUD_ACCESS_KEY") == "" ||
-		os.Getenv("ALICLOUD_SECRET_KEY") == "" {
-		httpClient := &http.Client{
-			Timeout: 3 * time.Second,
-		}
-		// Load config from Metadata Service
-		m := metadata.NewMetaData(httpClient)
-		roleName, err := m.RoleName()
-		if err != nil {
-			return fmt.Errorf("failed to get role name from Metadata Service: %v", err)
-		}
-		vpcID, err := m.VpcID()
-		if err != nil {
-			return fmt.Errorf("failed to get VPC ID from Metadata Service: %v", err)
-		}
-		regionID, err := m.Region()
-		if err != nil {
-			return fmt.Errorf("failed to get Region ID from Metadata Service: %v", err)
-		}
-		role, err := m.RamRoleToken(roleName)
-		if err != nil {
-			return fmt.Errorf("failed to get STS Token from Metadata Service: %v", err)
-		}
-		a.RegionID = regionID
-		a.RoleName = roleName
-		a.VPCID = vpcID
-		a.AccessKeyID = role.AccessKeyId
-		a.AccessKeySecret = role.AccessKeySecret
-		a.StsToken = role.SecurityToken
-		a.ExpireTime = role.Expiration
-	} else {
-		a.RegionID = os.Getenv("ALICLOUD_REGION")
-		a.AccessKeyID = os.Getenv("ALICLOUD_ACCESS_KEY")
-		a.AccessKeySecret = os.Getenv("ALICLOUD_SECRET_KEY")
-		a.RoleName = roleName
+	provider, err := RetrieveCredentials(a, DefaultCredentialProviders())
+	if err != nil {
+		return err
+	}
+	if a.Provider != provider {
+		log.Logger.Infof("using credentials from %s provider", provider)
 	}
+	a.Provider = provider
 	return nil
 }
 
-func NewAliClient(cfg *AliCloudConfig) (*AliClient, error) {
-	var err error
-	var ecsClient *ecs.Client
+func newEcsClient(cfg *AliCloudConfig) (*ecs.Client, error) {
+	if cfg.StsToken == "" {
+		return ecs.NewClientWithAccessKey(
+			cfg.RegionID,
+			cfg.AccessKeyID,
+			cfg.AccessKeySecret,
+		)
+	}
+	return ecs.NewClientWithStsToken(
+		cfg.RegionID,
+		cfg.AccessKeyID,
+		cfg.AccessKeySecret,
+		cfg.StsToken,
+	)
+}
 
-	if cfg.RoleName == "" {
-		ecsClient, err = ecs.NewClientWithAccessKey(
+func newVpcClient(cfg *AliCloudConfig) (*vpc.Client, error) {
+	if cfg.StsToken == "" {
+		return vpc.NewClientWithAccessKey(
 			cfg.RegionID,
 			cfg.AccessKeyID,
 			cfg.AccessKeySecret,
 		)
-	} else {
-		ecsClient, err = ecs.NewClientWithStsToken(
+	}
+	return vpc.NewClientWithStsToken(
+		cfg.RegionID,
+		cfg.AccessKeyID,
+		cfg.AccessKeySecret,
+		cfg.StsToken,
+	)
+}
+
+func newCmsClient(cfg *AliCloudConfig) (*cms.Client, error) {
+	if cfg.StsToken == "" {
+		return cms.NewClientWithAccessKey(
 			cfg.RegionID,
 			cfg.AccessKeyID,
 			cfg.AccessKeySecret,
-			cfg.StsToken,
 		)
 	}
-	if err != nil {
-		return nil, fmt.Errorf("failed to create alicloud client: %v", err)
+	return cms.NewClientWithStsToken(
+		cfg.RegionID,
+		cfg.AccessKeyID,
+		cfg.AccessKeySecret,
+		cfg.StsToken,
+	)
+}
+
+func newEssClient(cfg *AliCloudConfig) (*ess.Client, error) {
+	if cfg.StsToken == "" {
+		return ess.NewClientWithAccessKey(
+			cfg.RegionID,
+			cfg.AccessKeyID,
+			cfg.AccessKeySecret,
+		)
+	}
+	return ess.NewClientWithStsToken(
+		cfg.RegionID,
+		cfg.AccessKeyID,
+		cfg.AccessKeySecret,
+		cfg.StsToken,
+	)
+}
+
+func newStsClient(cfg *AliCloudConfig) (*sts.Client, error) {
+	if cfg.StsToken == "" {
+		return sts.NewClientWithAccessKey(
+			cfg.RegionID,
+			cfg.AccessKeyID,
+			cfg.AccessKeySecret,
+		)
 	}
-	aliClient := &AliClient{
-		RegionID:  cfg.RegionID,
-		EcsClient: *ecsClient,
+	return sts.NewClientWithStsToken(
+		cfg.RegionID,
+		cfg.AccessKeyID,
+		cfg.AccessKeySecret,
+		cfg.StsToken,
+	)
+}
+
+// NewAliClient creates the api clients from cfg, when the credentials expire a refresher is started
+// that reloads them before expiration, stop it with Close.
+func NewAliClient(cfg *AliCloudConfig) (*AliClient, error) {
+	aliClient := &AliClient{}
+	if err := aliClient.setClients(cfg); err != nil {
+		return nil, err
 	}
-	if cfg.RoleName != "" {
-		aliClient.setNextExpire(cfg.ExpireTime)
-		go aliClient.refreshStsToken(cfg, 1*time.Second)
+	if !cfg.ExpireTime.IsZero() {
+		aliClient.refresher = NewCredentialRefresher(aliClient, cfg)
+		aliClient.refresher.Start()
 	}
 	return aliClient, nil
 }
 
-func (p *AliClient) setNextExpire(expireTime time.Time) {
+func (p *AliClient) setClients(cfg *AliCloudConfig) error {
+	ecsClient, err := newEcsClient(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create alicloud client: %v", err)
+	}
+	vpcClient, err := newVpcClient(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create alicloud vpc client: %v", err)
+	}
+	cmsClient, err := newCmsClient(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create alicloud cms client: %v", err)
+	}
+	essClient, err := newEssClient(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create alicloud ess client: %v", err)
+	}
+	stsClient, err := newStsClient(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create alicloud sts client: %v", err)
+	}
 	p.clientLock.Lock()
 	defer p.clientLock.Unlock()
-	p.nextExpire = expireTime
-}
-
-func (p *AliClient) refreshStsToken(cfg *AliCloudConfig, sleepTime time.Duration) {
-	for {
-		time.Sleep(sleepTime)
-		now := time.Now()
-		utcLocation, err := time.LoadLocation("")
-		if err != nil {
-			log.Logger.Errorf("Get utc time error %v", err)
-			continue
-		}
-		nowTime := now.In(utcLocation)
-		p.clientLock.RLock()
-		sleepTime = p.nextExpire.Sub(nowTime)
-		p.clientLock.RUnlock()
-		log.Logger.Infof("Distance expiration time %v", sleepTime)
-		if sleepTime < 10*time.Minute {
-			sleepTime = time.Second * 1
-		} else {
-			sleepTime = 9 * time.Minute
-			log.Logger.Info("Next fetch sts sleep interval : ", sleepTime.String())
-			continue
-		}
-		err = cfg.GetCloudConfig()
-		if err != nil {
-			log.Logger.Errorf("Failed to refreshStsToken: %v", err)
-			continue
-		}
-		var ecsClient *ecs.Client
-
-		if cfg.RoleName == "" {
-			ecsClient, err = ecs.NewClientWithAccessKey(
-				cfg.RegionID,
-				cfg.AccessKeyID,
-				cfg.AccessKeySecret,
-			)
-		} else {
-			ecsClient, err = ecs.NewClientWithStsToken(
-				cfg.RegionID,
-				cfg.AccessKeyID,
-				cfg.AccessKeySecret,
-				cfg.StsToken,
-			)
-		}
-
-		log.Logger.Infof("Refresh client from sts token, next expire time %v", cfg.ExpireTime)
-		p.clientLock.Lock()
-		p.RegionID = cfg.RegionID
-		p.EcsClient = *ecsClient
-		p.nextExpire = cfg.ExpireTime
-		p.clientLock.Unlock()
+	p.regionID = cfg.RegionID
+	p.ecsClient = ecsClient
+	p.vpcClient = vpcClient
+	p.cmsClient = cmsClient
+	p.essClient = essClient
+	p.stsClient = stsClient
+	return nil
+}
+
+func (p *AliClient) Region() string {
+	p.clientLock.RLock()
+	defer p.clientLock.RUnlock()
+	return p.regionID
+}
+
+func (p *AliClient) Ecs() *ecs.Client {
+	p.clientLock.RLock()
+	defer p.clientLock.RUnlock()
+	return p.ecsClient
+}
+
+func (p *AliClient) Vpc() *vpc.Client {
+	p.clientLock.RLock()
+	defer p.clientLock.RUnlock()
+	return p.vpcClient
+}
+
+func (p *AliClient) Cms() *cms.Client {
+	p.clientLock.RLock()
+	defer p.clientLock.RUnlock()
+	return p.cmsClient
+}
+
+func (p *AliClient) Ess() *ess.Client {
+	p.clientLock.RLock()
+	defer p.clientLock.RUnlock()
+	return p.essClient
+}
+
+func (p *AliClient) Sts() *sts.Client {
+	p.clientLock.RLock()
+	defer p.clientLock.RUnlock()
+	return p.stsClient
+}
+
+// Refresher returns the credential refresher, nil for credentials that do not expire.
+func (p *AliClient) Refresher() *CredentialRefresher {
+	return p.refresher
+}
+
+// Close stops the credential refresher.
+func (p *AliClient) Close() {
+	if p.refresher != nil {
+		p.refresher.Stop()
 	}
 }
@@ -0,0 +1,131 @@
+package alicloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/cms"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/types"
+)
+
+// CmsMetric is one entry of the cms metrics config, every name is queried with the same period and statistics.
+type CmsMetric struct {
+	Namespace  string        `mapstructure:"namespace"`
+	Names      []string      `mapstructure:"names"`
+	Period     int           `mapstructure:"period"`
+	Statistics []string      `mapstructure:"statistics"`
+	Dimensions []string      `mapstructure:"dimensions"`
+	Filter     string        `mapstructure:"filter"`
+	Lookback   time.Duration `mapstructure:"lookback"`
+}
+
+type QueryCmsFlags struct {
+	PageSize  int
+	Metrics   []CmsMetric
+	TagLabels types.ArgList
+	CacheTTL  time.Duration
+}
+
+// Datapoint is one datapoint of a cms metric, it holds the dimensions, the timestamp and the statistics.
+type Datapoint map[string]interface{}
+
+func (d Datapoint) Timestamp() float64 {
+	ts, _ := d["timestamp"].(float64)
+	return ts
+}
+
+// Value returns the statistic of the datapoint, false when it has none.
+func (d Datapoint) Value(statistic string) (float64, bool) {
+	v, ok := d[statistic].(float64)
+	return v, ok
+}
+
+func (d Datapoint) Dimension(name string) string {
+	v, ok := d[name]
+	if !ok || v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func parseDatapoints(metricName string, datapoints string) ([]Datapoint, error) {
+	points := []Datapoint{}
+	if datapoints == "" {
+		return points, nil
+	}
+	if err := json.Unmarshal([]byte(datapoints), &points); err != nil {
+		return nil, fmt.Errorf("failed to parse datapoints of %s: %v", metricName, err)
+	}
+	return points, nil
+}
+
+// QueryMetricLast returns the latest datapoint of every dimension set of a metric, following NextToken.
+func QueryMetricLast(aliClient *AliClient, namespace string, metricName string, period int, filter string) ([]Datapoint, error) {
+	allPoints := []Datapoint{}
+	nextToken := ""
+	for {
+		request := cms.CreateDescribeMetricLastRequest()
+		request.Namespace = namespace
+		request.MetricName = metricName
+		request.Period = strconv.Itoa(period)
+		request.Dimensions = filter
+		request.NextToken = nextToken
+		response, err := aliClient.Cms().DescribeMetricLast(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get cms metric %s/%s: %v", namespace, metricName, err)
+		}
+		if !response.Success {
+			return nil, fmt.Errorf("failed to get cms metric %s/%s: %s %s", namespace, metricName, response.Code, response.Message)
+		}
+		points, err := parseDatapoints(metricName, response.Datapoints)
+		if err != nil {
+			return nil, err
+		}
+		allPoints = append(allPoints, points...)
+		if response.NextToken == "" {
+			break
+		}
+		nextToken = response.NextToken
+	}
+	return allPoints, nil
+}
+
+// QueryMetricList returns all datapoints of a metric between start and end, following NextToken.
+func QueryMetricList(aliClient *AliClient, namespace string, metricName string, period int, filter string, start time.Time, end time.Time, pageSize int) ([]Datapoint, error) {
+	allPoints := []Datapoint{}
+	nextToken := ""
+	for {
+		request := cms.CreateDescribeMetricListRequest()
+		request.Namespace = namespace
+		request.MetricName = metricName
+		request.Period = strconv.Itoa(period)
+		request.Dimensions = filter
+		request.StartTime = strconv.FormatInt(start.UnixNano()/int64(time.Millisecond), 10)
+		request.EndTime = strconv.FormatInt(end.UnixNano()/int64(time.Millisecond), 10)
+		request.Length = strconv.Itoa(pageSize)
+		request.NextToken = nextToken
+		response, err := aliClient.Cms().DescribeMetricList(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get cms metric %s/%s: %v", namespace, metricName, err)
+		}
+		if !response.Success {
+			return nil, fmt.Errorf("failed to get cms metric %s/%s: %s %s", namespace, metricName, response.Code, response.Message)
+		}
+		points, err := parseDatapoints(metricName, response.Datapoints)
+		if err != nil {
+			return nil, err
+		}
+		allPoints = append(allPoints, points...)
+		if response.NextToken == "" {
+			break
+		}
+		nextToken = response.NextToken
+	}
+	return allPoints, nil
+}
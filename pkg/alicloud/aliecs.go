@@ -11,18 +11,39 @@ import (
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
 
 	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/tagnorm"
 	"github.com/allanhung/alicloud-monitoring/pkg/types"
 )
 
 type QueryEcsFlags struct {
-	InstanceId   string
-	InstanceName string
-	PageSize     int
-	Tag          types.ArgList
-	ReName       types.ArgList
-	NoTagKey     types.ArgList
-	NoTagValue   types.ArgList
-	Cron         string
+	InstanceId       string
+	InstanceName     string
+	PageSize         int
+	Tag              types.ArgList
+	ReName           types.ArgList
+	NoTagKey         types.ArgList
+	NoTagValue       types.ArgList
+	Cron             string
+	InventoryFile    string
+	InventoryWebhook string
+	Mode             string
+	CacheTTL         time.Duration
+	DryRun           bool
+	Normalizer       *tagnorm.Normalizer
+	MaxChanges       int
+	PlanDir          string
+	PlanWebhook      string
+	TerraformState   types.ArgList
+	IacMode          string
+}
+
+type QueryDriftFlags struct {
+	PageSize       int
+	Tag            types.ArgList
+	ReName         types.ArgList
+	TerraformState types.ArgList
+	IgnoreKeys     types.ArgList
+	Cron           string
 }
 
 type QuerySpotPriceFlags struct {
@@ -30,6 +51,20 @@ type QuerySpotPriceFlags struct {
 	Region        string
 	PageSize      int
 	Cron          string
+	Mode          string
+	CacheTTL      time.Duration
+	MinDiscount   float64
+	MaxPrice      float64
+	Hysteresis    float64
+	AlertFor      time.Duration
+}
+
+type QuerySpotBidFlags struct {
+	PageSize    int
+	Tag         types.ArgList
+	ReName      types.ArgList
+	MinHeadroom float64
+	Cron        string
 }
 
 type byTimestamp []ecs.SpotPriceType
@@ -69,10 +104,10 @@ func QueryECS(aliClient *AliClient, queryFlags QueryEcsFlags) ([]ecs.Instance, e
 		if queryFlags.InstanceName != "" {
 			request.InstanceName = queryFlags.InstanceName
 		}
-		request.RegionId = aliClient.RegionID
+		request.RegionId = aliClient.Region()
 		request.PageSize = requests.NewInteger(pageSize)
 		request.PageNumber = requests.NewInteger(pageNumber)
-		response, err := aliClient.EcsClient.DescribeInstances(request)
+		response, err := aliClient.Ecs().DescribeInstances(request)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get ECS information: %v", err)
 		}
@@ -88,42 +123,8 @@ func QueryECS(aliClient *AliClient, queryFlags QueryEcsFlags) ([]ecs.Instance, e
 				}
 			}
 
-			if nameMatch {
-				// tag key match
-				noTagKeyMatch := true
-				for _, regRule := range queryFlags.NoTagKey {
-					r, _ := regexp.Compile(regRule)
-					for _, tag := range instance.Tags.Tag {
-						if r.MatchString(tag.TagKey) {
-							noTagKeyMatch = false
-							log.Logger.Debugf("instance %s is exclude by no tag key rule: %s", instance.InstanceName, regRule)
-							break
-						}
-					}
-					if !noTagKeyMatch {
-						break
-					}
-				}
-				if noTagKeyMatch {
-					// tag value match
-					noTagValueMatch := true
-					for _, regRule := range queryFlags.NoTagValue {
-						r, _ := regexp.Compile(regRule)
-						for _, tag := range instance.Tags.Tag {
-							if r.MatchString(tag.TagValue) {
-								noTagValueMatch = false
-								log.Logger.Debugf("instance %s is exclude by no tag value rule: %s", instance.InstanceName, regRule)
-								break
-							}
-						}
-						if !noTagValueMatch {
-							break
-						}
-					}
-					if noTagValueMatch {
-						allInstances = append(allInstances, instance)
-					}
-				}
+			if nameMatch && MatchNoTag(instance, queryFlags) {
+				allInstances = append(allInstances, instance)
 			}
 		}
 		remaining = response.TotalCount - pageNumber*pageSize
@@ -132,7 +133,31 @@ func QueryECS(aliClient *AliClient, queryFlags QueryEcsFlags) ([]ecs.Instance, e
 	return allInstances, nil
 }
 
-func AddInstanceTags(aliClient *AliClient, ecsInstance ecs.Instance, ecsTags []ecs.AddTagsTag) error {
+// MatchNoTag returns true when the instance has no tag key matching NoTagKey and no tag value matching NoTagValue.
+func MatchNoTag(instance ecs.Instance, queryFlags QueryEcsFlags) bool {
+	for _, regRule := range queryFlags.NoTagKey {
+		r, _ := regexp.Compile(regRule)
+		for _, tag := range instance.Tags.Tag {
+			if r.MatchString(tag.TagKey) || r.MatchString(queryFlags.Normalizer.CanonicalKey(tag.TagKey)) {
+				log.Logger.Debugf("instance %s is exclude by no tag key rule: %s", instance.InstanceName, regRule)
+				return false
+			}
+		}
+	}
+	for _, regRule := range queryFlags.NoTagValue {
+		r, _ := regexp.Compile(regRule)
+		for _, tag := range instance.Tags.Tag {
+			if r.MatchString(tag.TagValue) {
+				log.Logger.Debugf("instance %s is exclude by no tag value rule: %s", instance.InstanceName, regRule)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// AddInstanceTags adds the tags to the instance and returns the request id of the call.
+func AddInstanceTags(aliClient *AliClient, ecsInstance ecs.Instance, ecsTags []ecs.AddTagsTag) (string, error) {
 	request := ecs.CreateAddTagsRequest()
 	request.Scheme = "https"
 
@@ -140,13 +165,33 @@ func AddInstanceTags(aliClient *AliClient, ecsInstance ecs.Instance, ecsTags []e
 	request.ResourceId = ecsInstance.InstanceId
 	request.Tag = &ecsTags
 
-	response, err := aliClient.EcsClient.AddTags(request)
+	response, err := aliClient.Ecs().AddTags(request)
+	if err != nil {
+		return "", err
+	}
+	log.Logger.Debugf("response: %v", response)
+	return response.RequestId, nil
+}
+
+// RemoveInstanceTags removes the tag keys from the instance and returns the request id of the call.
+func RemoveInstanceTags(aliClient *AliClient, ecsInstance ecs.Instance, keys []string) (string, error) {
+	request := ecs.CreateRemoveTagsRequest()
+	request.Scheme = "https"
+
+	ecsTags := []ecs.RemoveTagsTag{}
+	for _, key := range keys {
+		ecsTags = append(ecsTags, ecs.RemoveTagsTag{Key: key})
+	}
+	request.ResourceType = "instance"
+	request.ResourceId = ecsInstance.InstanceId
+	request.Tag = &ecsTags
+
+	response, err := aliClient.Ecs().RemoveTags(request)
 	if err != nil {
-		return err
+		return "", err
 	}
-	log.Logger.Infof("instance: %s (%s) tags added", ecsInstance.InstanceId, ecsInstance.InstanceName)
 	log.Logger.Debugf("response: %v", response)
-	return nil
+	return response.RequestId, nil
 }
 
 func QueryVpc(aliClient *AliClient, pageSize int) ([]ecs.Vpc, error) {
@@ -157,10 +202,10 @@ func QueryVpc(aliClient *AliClient, pageSize int) ([]ecs.Vpc, error) {
 
 	for remaining > 0 {
 		request := ecs.CreateDescribeVpcsRequest()
-		request.RegionId = aliClient.RegionID
+		request.RegionId = aliClient.Region()
 		request.PageSize = requests.NewInteger(pageSize)
 		request.PageNumber = requests.NewInteger(pageNumber)
-		response, err := aliClient.EcsClient.DescribeVpcs(request)
+		response, err := aliClient.Ecs().DescribeVpcs(request)
 		if err != nil {
 			return allVpcs, err
 		}
@@ -178,7 +223,7 @@ func QuerySpotPrice(aliClient *AliClient, instanceType string) ([]ecs.SpotPriceT
 	request := ecs.CreateDescribeSpotPriceHistoryRequest()
 	request.NetworkType = "vpc"
 	request.InstanceType = instanceType
-	response, err := aliClient.EcsClient.DescribeSpotPriceHistory(request)
+	response, err := aliClient.Ecs().DescribeSpotPriceHistory(request)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,144 @@
+package alicloud
+
+import (
+	"fmt"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ess"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/types"
+)
+
+// essTagResourceLimit is the maximum number of resource ids of one ListTagResources request.
+const essTagResourceLimit = 20
+
+type QueryEssFlags struct {
+	PageSize     int
+	TagInstances bool
+	TagKeys      types.ArgList
+	Cron         string
+	DryRun       bool
+	MaxChanges   int
+	PlanDir      string
+	PlanWebhook  string
+}
+
+func QueryScalingGroups(aliClient *AliClient, pageSize int) ([]ess.ScalingGroup, error) {
+	remaining := 1
+	pageNumber := 1
+
+	allGroups := make([]ess.ScalingGroup, 0)
+
+	for remaining > 0 {
+		request := ess.CreateDescribeScalingGroupsRequest()
+		request.RegionId = aliClient.Region()
+		request.PageSize = requests.NewInteger(pageSize)
+		request.PageNumber = requests.NewInteger(pageNumber)
+		response, err := aliClient.Ess().DescribeScalingGroups(request)
+		if err != nil {
+			return allGroups, fmt.Errorf("failed to get scaling groups: %v", err)
+		}
+		allGroups = append(allGroups, response.ScalingGroups.ScalingGroup...)
+		remaining = response.TotalCount - pageNumber*pageSize
+		pageNumber++
+	}
+
+	return allGroups, nil
+}
+
+func QueryScalingConfigurations(aliClient *AliClient, scalingGroupId string, pageSize int) ([]ess.ScalingConfiguration, error) {
+	remaining := 1
+	pageNumber := 1
+
+	allConfigurations := make([]ess.ScalingConfiguration, 0)
+
+	for remaining > 0 {
+		request := ess.CreateDescribeScalingConfigurationsRequest()
+		request.RegionId = aliClient.Region()
+		request.ScalingGroupId = scalingGroupId
+		request.PageSize = requests.NewInteger(pageSize)
+		request.PageNumber = requests.NewInteger(pageNumber)
+		response, err := aliClient.Ess().DescribeScalingConfigurations(request)
+		if err != nil {
+			return allConfigurations, fmt.Errorf("failed to get scaling configurations of %s: %v", scalingGroupId, err)
+		}
+		allConfigurations = append(allConfigurations, response.ScalingConfigurations.ScalingConfiguration...)
+		remaining = response.TotalCount - pageNumber*pageSize
+		pageNumber++
+	}
+
+	return allConfigurations, nil
+}
+
+func QueryScalingInstances(aliClient *AliClient, scalingGroupId string, pageSize int) ([]ess.ScalingInstance, error) {
+	remaining := 1
+	pageNumber := 1
+
+	allInstances := make([]ess.ScalingInstance, 0)
+
+	for remaining > 0 {
+		request := ess.CreateDescribeScalingInstancesRequest()
+		request.RegionId = aliClient.Region()
+		request.ScalingGroupId = scalingGroupId
+		request.PageSize = requests.NewInteger(pageSize)
+		request.PageNumber = requests.NewInteger(pageNumber)
+		response, err := aliClient.Ess().DescribeScalingInstances(request)
+		if err != nil {
+			return allInstances, fmt.Errorf("failed to get scaling instances of %s: %v", scalingGroupId, err)
+		}
+		allInstances = append(allInstances, response.ScalingInstances.ScalingInstance...)
+		remaining = response.TotalCount - pageNumber*pageSize
+		pageNumber++
+	}
+
+	return allInstances, nil
+}
+
+// QueryScalingActivities returns the latest pageSize scaling activities of a scaling group, newest first.
+func QueryScalingActivities(aliClient *AliClient, scalingGroupId string, pageSize int) ([]ess.ScalingActivity, error) {
+	request := ess.CreateDescribeScalingActivitiesRequest()
+	request.RegionId = aliClient.Region()
+	request.ScalingGroupId = scalingGroupId
+	request.PageSize = requests.NewInteger(pageSize)
+	request.PageNumber = requests.NewInteger(1)
+	response, err := aliClient.Ess().DescribeScalingActivities(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scaling activities of %s: %v", scalingGroupId, err)
+	}
+	return response.ScalingActivities.ScalingActivity, nil
+}
+
+// QueryScalingGroupTags returns the tags of the scaling groups by scaling group id.
+func QueryScalingGroupTags(aliClient *AliClient, scalingGroupIds []string) (map[string]map[string]string, error) {
+	groupTags := map[string]map[string]string{}
+	for start := 0; start < len(scalingGroupIds); start += essTagResourceLimit {
+		end := start + essTagResourceLimit
+		if end > len(scalingGroupIds) {
+			end = len(scalingGroupIds)
+		}
+		ids := scalingGroupIds[start:end]
+		nextToken := ""
+		for {
+			request := ess.CreateListTagResourcesRequest()
+			request.RegionId = aliClient.Region()
+			request.ResourceType = "scalinggroup"
+			request.ResourceId = &ids
+			request.NextToken = nextToken
+			response, err := aliClient.Ess().ListTagResources(request)
+			if err != nil {
+				return groupTags, fmt.Errorf("failed to get scaling group tags: %v", err)
+			}
+			for _, tag := range response.TagResources.TagResource {
+				if _, ok := groupTags[tag.ResourceId]; !ok {
+					groupTags[tag.ResourceId] = map[string]string{}
+				}
+				groupTags[tag.ResourceId][tag.TagKey] = tag.TagValue
+			}
+			if response.NextToken == "" {
+				break
+			}
+			nextToken = response.NextToken
+		}
+	}
+	return groupTags, nil
+}
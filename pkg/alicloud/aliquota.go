@@ -0,0 +1,44 @@
+package alicloud
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/types"
+)
+
+type QueryQuotaFlags struct {
+	PageSize int
+	Quotas   types.ArgList
+	Cron     string
+	Mode     string
+	CacheTTL time.Duration
+}
+
+// QueryAccountAttributes returns the numeric value of the account attributes in names, attributes without a number are skipped.
+func QueryAccountAttributes(aliClient *AliClient, names []string) (map[string]float64, error) {
+	request := ecs.CreateDescribeAccountAttributesRequest()
+	request.RegionId = aliClient.Region()
+	request.AttributeName = &names
+	response, err := aliClient.Ecs().DescribeAccountAttributes(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account attributes: %v", err)
+	}
+	attributes := map[string]float64{}
+	for _, item := range response.AccountAttributeItems.AccountAttributeItem {
+		for _, value := range item.AttributeValues.ValueItem {
+			v, err := strconv.ParseFloat(value.Value, 64)
+			if err != nil {
+				log.Logger.Debugf("account attribute %s has no numeric value: %s", item.AttributeName, value.Value)
+				continue
+			}
+			attributes[item.AttributeName] = v
+			break
+		}
+	}
+	return attributes, nil
+}
@@ -0,0 +1,54 @@
+package alicloud
+
+import (
+	"fmt"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/types"
+)
+
+type QuerySecGroupFlags struct {
+	PageSize int
+	Ports    types.ArgList
+	TagKey   string
+	Report   string
+	Cron     string
+}
+
+func QuerySecurityGroups(aliClient *AliClient, pageSize int) ([]ecs.SecurityGroup, error) {
+	remaining := 1
+	pageNumber := 1
+
+	allGroups := make([]ecs.SecurityGroup, 0)
+
+	for remaining > 0 {
+		request := ecs.CreateDescribeSecurityGroupsRequest()
+		request.RegionId = aliClient.Region()
+		request.IsQueryEcsCount = requests.NewBoolean(true)
+		request.PageSize = requests.NewInteger(pageSize)
+		request.PageNumber = requests.NewInteger(pageNumber)
+		response, err := aliClient.Ecs().DescribeSecurityGroups(request)
+		if err != nil {
+			return allGroups, fmt.Errorf("failed to get security groups: %v", err)
+		}
+		allGroups = append(allGroups, response.SecurityGroups.SecurityGroup...)
+		remaining = response.TotalCount - pageNumber*pageSize
+		pageNumber++
+	}
+
+	return allGroups, nil
+}
+
+func QuerySecurityGroupRules(aliClient *AliClient, securityGroupId string) ([]ecs.Permission, error) {
+	request := ecs.CreateDescribeSecurityGroupAttributeRequest()
+	request.RegionId = aliClient.Region()
+	request.SecurityGroupId = securityGroupId
+	request.Direction = "ingress"
+	response, err := aliClient.Ecs().DescribeSecurityGroupAttribute(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rules of security group %s: %v", securityGroupId, err)
+	}
+	return response.Permissions.Permission, nil
+}
@@ -0,0 +1,62 @@
+package alicloud
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/types"
+)
+
+const (
+	ChargeTypePostPaid = "PostPaid"
+	ChargeTypeSpot     = "Spot"
+)
+
+// StockStatuses are the stock status categories returned by DescribeAvailableResource.
+var StockStatuses = []string{"WithStock", "ClosedWithStock", "WithoutStock"}
+
+type QueryStockFlags struct {
+	InstanceTypes types.ArgList
+	ChargeTypes   types.ArgList
+	PageSize      int
+	Cron          string
+	Mode          string
+	CacheTTL      time.Duration
+}
+
+// QueryInstanceTypeStock returns the stock status category of instanceType by zone for chargeType, PostPaid or Spot.
+func QueryInstanceTypeStock(aliClient *AliClient, instanceType string, chargeType string) (map[string]string, error) {
+	request := ecs.CreateDescribeAvailableResourceRequest()
+	request.RegionId = aliClient.Region()
+	request.DestinationResource = "InstanceType"
+	request.ResourceType = "instance"
+	request.IoOptimized = "optimized"
+	request.NetworkCategory = "vpc"
+	request.InstanceType = instanceType
+	request.InstanceChargeType = "PostPaid"
+	switch chargeType {
+	case ChargeTypePostPaid:
+		request.SpotStrategy = "NoSpot"
+	case ChargeTypeSpot:
+		request.SpotStrategy = "SpotAsPriceGo"
+	default:
+		return nil, fmt.Errorf("unknown charge type: %s, must be %s or %s", chargeType, ChargeTypePostPaid, ChargeTypeSpot)
+	}
+	response, err := aliClient.Ecs().DescribeAvailableResource(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stock of %s: %v", instanceType, err)
+	}
+	stock := map[string]string{}
+	for _, zone := range response.AvailableZones.AvailableZone {
+		for _, resource := range zone.AvailableResources.AvailableResource {
+			for _, supported := range resource.SupportedResources.SupportedResource {
+				if supported.Value == instanceType {
+					stock[zone.ZoneId] = supported.StatusCategory
+				}
+			}
+		}
+	}
+	return stock, nil
+}
@@ -0,0 +1,25 @@
+package alicloud
+
+import (
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/sts"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+)
+
+// CallerIdentity is the account and the RAM user or role the credentials belong to.
+type CallerIdentity struct {
+	AccountId string
+	Arn       string
+}
+
+func QueryCallerIdentity(aliClient *AliClient) (CallerIdentity, error) {
+	request := sts.CreateGetCallerIdentityRequest()
+	request.Scheme = "https"
+
+	response, err := aliClient.Sts().GetCallerIdentity(request)
+	if err != nil {
+		return CallerIdentity{}, err
+	}
+	log.Logger.Debugf("caller identity: %s (%s)", response.Arn, response.AccountId)
+	return CallerIdentity{AccountId: response.AccountId, Arn: response.Arn}, nil
+}
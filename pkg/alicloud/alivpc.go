@@ -0,0 +1,72 @@
+package alicloud
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/vpc"
+)
+
+type QueryVSwitchFlags struct {
+	PageSize int
+	VpcId    string
+	Cron     string
+	Mode     string
+	CacheTTL time.Duration
+}
+
+func QueryVSwitches(aliClient *AliClient, vpcId string, pageSize int) ([]ecs.VSwitch, error) {
+	remaining := 1
+	pageNumber := 1
+
+	allVSwitches := make([]ecs.VSwitch, 0)
+
+	for remaining > 0 {
+		request := ecs.CreateDescribeVSwitchesRequest()
+		request.RegionId = aliClient.Region()
+		request.VpcId = vpcId
+		request.PageSize = requests.NewInteger(pageSize)
+		request.PageNumber = requests.NewInteger(pageNumber)
+		response, err := aliClient.Ecs().DescribeVSwitches(request)
+		if err != nil {
+			return allVSwitches, fmt.Errorf("failed to get vswitches: %v", err)
+		}
+		allVSwitches = append(allVSwitches, response.VSwitches.VSwitch...)
+		remaining = response.TotalCount - pageNumber*pageSize
+		pageNumber++
+	}
+
+	return allVSwitches, nil
+}
+
+// QueryVpcTags returns the tags of every vpc by vpc id, the ecs api does not return vpc tags.
+func QueryVpcTags(aliClient *AliClient, pageSize int) (map[string]map[string]string, error) {
+	remaining := 1
+	pageNumber := 1
+
+	vpcTags := map[string]map[string]string{}
+
+	for remaining > 0 {
+		request := vpc.CreateDescribeVpcsRequest()
+		request.RegionId = aliClient.Region()
+		request.PageSize = requests.NewInteger(pageSize)
+		request.PageNumber = requests.NewInteger(pageNumber)
+		response, err := aliClient.Vpc().DescribeVpcs(request)
+		if err != nil {
+			return vpcTags, fmt.Errorf("failed to get vpc tags: %v", err)
+		}
+		for _, v := range response.Vpcs.Vpc {
+			tags := map[string]string{}
+			for _, tag := range v.Tags.Tag {
+				tags[tag.Key] = tag.Value
+			}
+			vpcTags[v.VpcId] = tags
+		}
+		remaining = response.TotalCount - pageNumber*pageSize
+		pageNumber++
+	}
+
+	return vpcTags, nil
+}
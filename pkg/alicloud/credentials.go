@@ -0,0 +1,340 @@
+package alicloud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/denverdino/aliyungo/metadata"
+	homedir "github.com/mitchellh/go-homedir"
+	"gopkg.in/yaml.v2"
+)
+
+// CredentialFile is an explicit json or yaml file in the AliCloudConfig format, tried first when set.
+var CredentialFile string
+
+// Profile is the aliyun cli profile to use, defaults to ALIBABA_CLOUD_PROFILE or the current profile of the cli config.
+var Profile string
+
+var errCredentialsNotFound = errors.New("credentials not found")
+
+// CredentialProvider fills an AliCloudConfig from one source of credentials.
+type CredentialProvider interface {
+	Name() string
+	// Retrieve returns errCredentialsNotFound when the source is not configured, the chain then tries the next provider.
+	Retrieve(cfg *AliCloudConfig) error
+}
+
+// DefaultCredentialProviders returns the providers in the order they are tried.
+func DefaultCredentialProviders() []CredentialProvider {
+	return []CredentialProvider{
+		&fileProvider{path: CredentialFile},
+		&envProvider{},
+		&profileProvider{name: Profile},
+		&oidcProvider{},
+		&metadataProvider{},
+	}
+}
+
+// RetrieveCredentials fills cfg from the first configured provider.
+// A provider that is configured but fails stops the chain, so a broken setup is not silently replaced by another identity.
+func RetrieveCredentials(cfg *AliCloudConfig, providers []CredentialProvider) (string, error) {
+	for _, provider := range providers {
+		err := provider.Retrieve(cfg)
+		if err == errCredentialsNotFound {
+			continue
+		}
+		if err != nil {
+			return provider.Name(), fmt.Errorf("failed to get credentials from %s provider: %v", provider.Name(), err)
+		}
+		if cfg.RegionID == "" {
+			cfg.RegionID = os.Getenv("ALICLOUD_REGION")
+		}
+		if cfg.RegionID == "" {
+			return provider.Name(), fmt.Errorf("no region found for credentials from %s provider, set ALICLOUD_REGION", provider.Name())
+		}
+		return provider.Name(), nil
+	}
+	return "", fmt.Errorf("no credential provider found credentials")
+}
+
+type fileProvider struct {
+	path string
+}
+
+func (p *fileProvider) Name() string {
+	return "file"
+}
+
+func (p *fileProvider) Retrieve(cfg *AliCloudConfig) error {
+	if p.path == "" {
+		return errCredentialsNotFound
+	}
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+	fileCfg := AliCloudConfig{}
+	if strings.HasSuffix(p.path, ".json") {
+		err = json.Unmarshal(data, &fileCfg)
+	} else {
+		err = yaml.Unmarshal(data, &fileCfg)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %v", p.path, err)
+	}
+	if fileCfg.AccessKeyID == "" || fileCfg.AccessKeySecret == "" {
+		return fmt.Errorf("accessKeyId and accessKeySecret are required in %s", p.path)
+	}
+	cfg.RegionID = fileCfg.RegionID
+	cfg.AccessKeyID = fileCfg.AccessKeyID
+	cfg.AccessKeySecret = fileCfg.AccessKeySecret
+	cfg.VPCID = fileCfg.VPCID
+	cfg.RoleName = ""
+	cfg.StsToken = ""
+	cfg.ExpireTime = time.Time{}
+	return nil
+}
+
+type envProvider struct{}
+
+func (p *envProvider) Name() string {
+	return "env"
+}
+
+func (p *envProvider) Retrieve(cfg *AliCloudConfig) error {
+	if os.Getenv("ALICLOUD_REGION") == "" ||
+		os.Getenv("ALICLOUD_ACCESS_KEY") == "" ||
+		os.Getenv("ALICLOUD_SECRET_KEY") == "" {
+		return errCredentialsNotFound
+	}
+	cfg.RegionID = os.Getenv("ALICLOUD_REGION")
+	cfg.AccessKeyID = os.Getenv("ALICLOUD_ACCESS_KEY")
+	cfg.AccessKeySecret = os.Getenv("ALICLOUD_SECRET_KEY")
+	cfg.RoleName = ""
+	cfg.StsToken = ""
+	cfg.ExpireTime = time.Time{}
+	return nil
+}
+
+type cliProfile struct {
+	Name            string `json:"name"`
+	Mode            string `json:"mode"`
+	AccessKeyID     string `json:"access_key_id"`
+	AccessKeySecret string `json:"access_key_secret"`
+	StsToken        string `json:"sts_token"`
+	RamRoleName     string `json:"ram_role_name"`
+	RegionID        string `json:"region_id"`
+}
+
+type cliConfig struct {
+	Current  string       `json:"current"`
+	Profiles []cliProfile `json:"profiles"`
+}
+
+// profileProvider reads a profile of the aliyun cli from ~/.aliyun/config.json.
+type profileProvider struct {
+	name string
+	path string
+}
+
+func (p *profileProvider) Name() string {
+	return "profile"
+}
+
+func (p *profileProvider) Retrieve(cfg *AliCloudConfig) error {
+	path := p.path
+	if path == "" {
+		home, err := homedir.Dir()
+		if err != nil {
+			return errCredentialsNotFound
+		}
+		path = filepath.Join(home, ".aliyun", "config.json")
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return errCredentialsNotFound
+	}
+	if err != nil {
+		return err
+	}
+	conf := cliConfig{}
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	name := p.name
+	if name == "" {
+		name = os.Getenv("ALIBABA_CLOUD_PROFILE")
+	}
+	if name == "" {
+		name = conf.Current
+	}
+	for _, profile := range conf.Profiles {
+		if profile.Name != name {
+			continue
+		}
+		cfg.RegionID = profile.RegionID
+		cfg.RoleName = ""
+		cfg.ExpireTime = time.Time{}
+		switch profile.Mode {
+		case "", "AK":
+			cfg.AccessKeyID = profile.AccessKeyID
+			cfg.AccessKeySecret = profile.AccessKeySecret
+			cfg.StsToken = ""
+		case "StsToken":
+			cfg.AccessKeyID = profile.AccessKeyID
+			cfg.AccessKeySecret = profile.AccessKeySecret
+			cfg.StsToken = profile.StsToken
+		case "EcsRamRole":
+			return (&metadataProvider{roleName: profile.RamRoleName}).Retrieve(cfg)
+		default:
+			return fmt.Errorf("profile %s uses unsupported mode %s", name, profile.Mode)
+		}
+		return nil
+	}
+	if p.name != "" {
+		return fmt.Errorf("profile %s not found in %s", p.name, path)
+	}
+	return errCredentialsNotFound
+}
+
+type oidcCredentials struct {
+	AccessKeyId     string `json:"AccessKeyId"`
+	AccessKeySecret string `json:"AccessKeySecret"`
+	SecurityToken   string `json:"SecurityToken"`
+	Expiration      string `json:"Expiration"`
+}
+
+type oidcResponse struct {
+	RequestId   string          `json:"RequestId"`
+	Code        string          `json:"Code"`
+	Message     string          `json:"Message"`
+	Credentials oidcCredentials `json:"Credentials"`
+}
+
+// oidcProvider exchanges the service account token mounted by ACK RRSA for a STS token with AssumeRoleWithOIDC.
+// ALIBABA_CLOUD_STS_ENDPOINT overrides the sts endpoint, an endpoint with a scheme such as http://127.0.0.1:8080 is used as is.
+type oidcProvider struct{}
+
+func (p *oidcProvider) Name() string {
+	return "oidc"
+}
+
+func (p *oidcProvider) Retrieve(cfg *AliCloudConfig) error {
+	roleArn := os.Getenv("ALIBABA_CLOUD_ROLE_ARN")
+	providerArn := os.Getenv("ALIBABA_CLOUD_OIDC_PROVIDER_ARN")
+	tokenFile := os.Getenv("ALIBABA_CLOUD_OIDC_TOKEN_FILE")
+	if roleArn == "" || providerArn == "" || tokenFile == "" {
+		return errCredentialsNotFound
+	}
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return fmt.Errorf("failed to read oidc token: %v", err)
+	}
+
+	endpoint := os.Getenv("ALIBABA_CLOUD_STS_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "sts.aliyuncs.com"
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	sessionName := os.Getenv("ALIBABA_CLOUD_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "alicloud-monitoring"
+	}
+	form := url.Values{}
+	form.Set("Action", "AssumeRoleWithOIDC")
+	form.Set("Format", "JSON")
+	form.Set("Version", "2015-04-01")
+	form.Set("Timestamp", time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	form.Set("RoleArn", roleArn)
+	form.Set("OIDCProviderArn", providerArn)
+	form.Set("OIDCToken", strings.TrimSpace(string(token)))
+	form.Set("RoleSessionName", sessionName)
+
+	httpClient := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+	resp, err := httpClient.PostForm(endpoint+"/", form)
+	if err != nil {
+		return fmt.Errorf("failed to call AssumeRoleWithOIDC: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read AssumeRoleWithOIDC response: %v", err)
+	}
+	result := oidcResponse{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("failed to parse AssumeRoleWithOIDC response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("AssumeRoleWithOIDC failed: %s %s (request id %s)", result.Code, result.Message, result.RequestId)
+	}
+	expiration, err := time.Parse(time.RFC3339, result.Credentials.Expiration)
+	if err != nil {
+		return fmt.Errorf("failed to parse sts token expiration %s: %v", result.Credentials.Expiration, err)
+	}
+
+	cfg.RegionID = os.Getenv("ALIBABA_CLOUD_REGION_ID")
+	cfg.AccessKeyID = result.Credentials.AccessKeyId
+	cfg.AccessKeySecret = result.Credentials.AccessKeySecret
+	cfg.StsToken = result.Credentials.SecurityToken
+	cfg.ExpireTime = expiration
+	cfg.RoleName = ""
+	return nil
+}
+
+// metadataProvider uses the RAM role of the ECS instance from the Metadata Service.
+type metadataProvider struct {
+	roleName string
+}
+
+func (p *metadataProvider) Name() string {
+	return "metadata"
+}
+
+func (p *metadataProvider) Retrieve(cfg *AliCloudConfig) error {
+	httpClient := &http.Client{
+		Timeout: 3 * time.Second,
+	}
+	// Load config from Metadata Service
+	m := metadata.NewMetaData(httpClient)
+	roleName := p.roleName
+	if roleName == "" {
+		var err error
+		roleName, err = m.RoleName()
+		if err != nil {
+			return fmt.Errorf("failed to get role name from Metadata Service: %v", err)
+		}
+	}
+	vpcID, err := m.VpcID()
+	if err != nil {
+		return fmt.Errorf("failed to get VPC ID from Metadata Service: %v", err)
+	}
+	regionID, err := m.Region()
+	if err != nil {
+		return fmt.Errorf("failed to get Region ID from Metadata Service: %v", err)
+	}
+	role, err := m.RamRoleToken(roleName)
+	if err != nil {
+		return fmt.Errorf("failed to get STS Token from Metadata Service: %v", err)
+	}
+	cfg.RegionID = regionID
+	cfg.RoleName = roleName
+	cfg.VPCID = vpcID
+	cfg.AccessKeyID = role.AccessKeyId
+	cfg.AccessKeySecret = role.AccessKeySecret
+	cfg.StsToken = role.SecurityToken
+	cfg.ExpireTime = role.Expiration
+	return nil
+}
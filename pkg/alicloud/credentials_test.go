@@ -0,0 +1,307 @@
+package alicloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+var credentialEnv = []string{
+	"ALICLOUD_REGION",
+	"ALICLOUD_ACCESS_KEY",
+	"ALICLOUD_SECRET_KEY",
+	"ALIBABA_CLOUD_PROFILE",
+	"ALIBABA_CLOUD_ROLE_ARN",
+	"ALIBABA_CLOUD_OIDC_PROVIDER_ARN",
+	"ALIBABA_CLOUD_OIDC_TOKEN_FILE",
+	"ALIBABA_CLOUD_STS_ENDPOINT",
+	"ALIBABA_CLOUD_ROLE_SESSION_NAME",
+	"ALIBABA_CLOUD_REGION_ID",
+	"METADATA_ENDPOINT",
+	"HOME",
+}
+
+func setenv(t *testing.T, key string, value string) {
+	prev, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, prev)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// isolate clears the credential environment, points HOME to an empty directory and resets the package flags.
+func isolate(t *testing.T) string {
+	for _, key := range credentialEnv {
+		setenv(t, key, "")
+		os.Unsetenv(key)
+	}
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	setenv(t, "HOME", dir)
+	homedir.DisableCache = true
+
+	credentialFile, profile := CredentialFile, Profile
+	CredentialFile, Profile = "", ""
+	t.Cleanup(func() { CredentialFile, Profile = credentialFile, profile })
+	return dir
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func writeProfiles(t *testing.T, home string, current string, profiles ...cliProfile) {
+	data, err := json.Marshal(cliConfig{Current: current, Profiles: profiles})
+	if err != nil {
+		t.Fatalf("failed to encode profiles: %v", err)
+	}
+	writeFile(t, filepath.Join(home, ".aliyun", "config.json"), string(data))
+}
+
+// newFakeSts answers AssumeRoleWithOIDC and fails the test when the request misses a parameter,
+// any token but service-account-token is refused.
+func newFakeSts(t *testing.T, accessKeyId string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("failed to parse form: %v", err)
+		}
+		want := map[string]string{
+			"Action":          "AssumeRoleWithOIDC",
+			"Format":          "JSON",
+			"RoleArn":         "acs:ram::123:role/monitoring",
+			"OIDCProviderArn": "acs:ram::123:oidc-provider/ack",
+		}
+		for key, value := range want {
+			if got := r.PostForm.Get(key); got != value {
+				t.Errorf("%s = %q, want %q", key, got, value)
+			}
+		}
+		if r.PostForm.Get("RoleSessionName") == "" {
+			t.Errorf("RoleSessionName missing")
+		}
+		if r.PostForm.Get("OIDCToken") != "service-account-token" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"RequestId":"req-1","Code":"InvalidParameter.OIDCToken","Message":"invalid token"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"RequestId":"req-1","Credentials":{"AccessKeyId":%q,"AccessKeySecret":"oidc-secret","SecurityToken":"oidc-token","Expiration":"2030-01-01T00:00:00Z"}}`, accessKeyId)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func setupOIDC(t *testing.T, dir string, endpoint string, token string) {
+	tokenFile := filepath.Join(dir, "token")
+	writeFile(t, tokenFile, token+"\n")
+	setenv(t, "ALIBABA_CLOUD_ROLE_ARN", "acs:ram::123:role/monitoring")
+	setenv(t, "ALIBABA_CLOUD_OIDC_PROVIDER_ARN", "acs:ram::123:oidc-provider/ack")
+	setenv(t, "ALIBABA_CLOUD_OIDC_TOKEN_FILE", tokenFile)
+	setenv(t, "ALIBABA_CLOUD_STS_ENDPOINT", endpoint)
+	setenv(t, "ALIBABA_CLOUD_REGION_ID", "cn-shanghai")
+}
+
+// newFakeMetadata serves the role, vpc, region and sts token of the ecs metadata service.
+func newFakeMetadata(t *testing.T, roleName string, accessKeyId string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/meta-data/ram/security-credentials/":
+			fmt.Fprint(w, roleName)
+		case "/latest/meta-data/vpc-id":
+			fmt.Fprint(w, "vpc-1")
+		case "/latest/meta-data/region-id":
+			fmt.Fprint(w, "cn-beijing")
+		case "/latest/meta-data/ram/security-credentials/" + roleName:
+			fmt.Fprintf(w, `{"AccessKeyId":%q,"AccessKeySecret":"metadata-secret","SecurityToken":"metadata-token","Expiration":"2030-01-01T00:00:00Z","Code":"Success"}`, accessKeyId)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	setenv(t, "METADATA_ENDPOINT", server.URL)
+	return server
+}
+
+func TestProviderChainOrder(t *testing.T) {
+	dir := isolate(t)
+	credentialFile := filepath.Join(dir, "credentials.yaml")
+	writeFile(t, credentialFile, "regionId: cn-hangzhou\naccessKeyId: file-key\naccessKeySecret: file-secret\n")
+	setenv(t, "ALICLOUD_REGION", "cn-hangzhou")
+	setenv(t, "ALICLOUD_ACCESS_KEY", "env-key")
+	setenv(t, "ALICLOUD_SECRET_KEY", "env-secret")
+	writeProfiles(t, dir, "default", cliProfile{Name: "default", Mode: "AK", AccessKeyID: "profile-key", AccessKeySecret: "profile-secret", RegionID: "cn-hangzhou"})
+	sts := newFakeSts(t, "oidc-key")
+	setupOIDC(t, dir, sts.URL, "service-account-token")
+	newFakeMetadata(t, "monitoring", "metadata-key")
+
+	// every step removes the provider that was used before, the next one in the chain must take over
+	steps := []struct {
+		provider    string
+		accessKeyId string
+		remove      func()
+	}{
+		{"file", "file-key", func() { CredentialFile = "" }},
+		{"env", "env-key", func() { os.Unsetenv("ALICLOUD_ACCESS_KEY") }},
+		{"profile", "profile-key", func() { os.Remove(filepath.Join(dir, ".aliyun", "config.json")) }},
+		{"oidc", "oidc-key", func() { os.Unsetenv("ALIBABA_CLOUD_ROLE_ARN") }},
+		{"metadata", "metadata-key", func() {}},
+	}
+	CredentialFile = credentialFile
+	for _, step := range steps {
+		cfg := &AliCloudConfig{}
+		provider, err := RetrieveCredentials(cfg, DefaultCredentialProviders())
+		if err != nil {
+			t.Fatalf("RetrieveCredentials() error = %v, want %s provider", err, step.provider)
+		}
+		if provider != step.provider || cfg.AccessKeyID != step.accessKeyId {
+			t.Errorf("got provider %s with key %s, want %s with key %s", provider, cfg.AccessKeyID, step.provider, step.accessKeyId)
+		}
+		step.remove()
+	}
+}
+
+func TestProviderChainStopsOnBrokenProvider(t *testing.T) {
+	dir := isolate(t)
+	CredentialFile = filepath.Join(dir, "missing.yaml")
+	setenv(t, "ALICLOUD_REGION", "cn-hangzhou")
+	setenv(t, "ALICLOUD_ACCESS_KEY", "env-key")
+	setenv(t, "ALICLOUD_SECRET_KEY", "env-secret")
+
+	provider, err := RetrieveCredentials(&AliCloudConfig{}, DefaultCredentialProviders())
+	if err == nil || provider != "file" {
+		t.Errorf("got provider %s error %v, want error of the file provider", provider, err)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir := isolate(t)
+	jsonFile := filepath.Join(dir, "credentials.json")
+	writeFile(t, jsonFile, `{"regionId":"cn-hangzhou","accessKeyId":"json-key","accessKeySecret":"json-secret"}`)
+	cfg := &AliCloudConfig{StsToken: "stale"}
+	if err := (&fileProvider{path: jsonFile}).Retrieve(cfg); err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if cfg.AccessKeyID != "json-key" || cfg.RegionID != "cn-hangzhou" || cfg.StsToken != "" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	incomplete := filepath.Join(dir, "incomplete.yaml")
+	writeFile(t, incomplete, "regionId: cn-hangzhou\naccessKeyId: key\n")
+	if err := (&fileProvider{path: incomplete}).Retrieve(&AliCloudConfig{}); err == nil {
+		t.Errorf("Retrieve() without accessKeySecret error = nil")
+	}
+}
+
+func TestProfileProviderModes(t *testing.T) {
+	dir := isolate(t)
+	newFakeMetadata(t, "monitoring", "metadata-key")
+	writeProfiles(t, dir, "ak",
+		cliProfile{Name: "ak", Mode: "AK", AccessKeyID: "ak-key", AccessKeySecret: "ak-secret", RegionID: "cn-hangzhou"},
+		cliProfile{Name: "legacy", AccessKeyID: "legacy-key", AccessKeySecret: "legacy-secret", RegionID: "cn-hangzhou"},
+		cliProfile{Name: "sts", Mode: "StsToken", AccessKeyID: "sts-key", AccessKeySecret: "sts-secret", StsToken: "sts-token", RegionID: "cn-shenzhen"},
+		cliProfile{Name: "ramrole", Mode: "EcsRamRole", RamRoleName: "monitoring", RegionID: "cn-hangzhou"},
+		cliProfile{Name: "unsupported", Mode: "RamRoleArn", RegionID: "cn-hangzhou"},
+	)
+
+	tests := []struct {
+		name        string
+		envProfile  string
+		accessKeyId string
+		stsToken    string
+		roleName    string
+		regionId    string
+		wantErr     bool
+	}{
+		{name: "", accessKeyId: "ak-key", regionId: "cn-hangzhou"},
+		{name: "ak", accessKeyId: "ak-key", regionId: "cn-hangzhou"},
+		{name: "legacy", accessKeyId: "legacy-key", regionId: "cn-hangzhou"},
+		{name: "sts", accessKeyId: "sts-key", stsToken: "sts-token", regionId: "cn-shenzhen"},
+		{envProfile: "sts", accessKeyId: "sts-key", stsToken: "sts-token", regionId: "cn-shenzhen"},
+		{name: "ramrole", accessKeyId: "metadata-key", stsToken: "metadata-token", roleName: "monitoring", regionId: "cn-beijing"},
+		{name: "unsupported", wantErr: true},
+		{name: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+tt.envProfile, func(t *testing.T) {
+			setenv(t, "ALIBABA_CLOUD_PROFILE", tt.envProfile)
+			cfg := &AliCloudConfig{}
+			err := (&profileProvider{name: tt.name}).Retrieve(cfg)
+			if tt.wantErr {
+				if err == nil || err == errCredentialsNotFound {
+					t.Errorf("Retrieve() error = %v, want a failure", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Retrieve() error = %v", err)
+			}
+			if cfg.AccessKeyID != tt.accessKeyId || cfg.StsToken != tt.stsToken || cfg.RoleName != tt.roleName || cfg.RegionID != tt.regionId {
+				t.Errorf("got %+v, want key %s token %s role %s region %s", cfg, tt.accessKeyId, tt.stsToken, tt.roleName, tt.regionId)
+			}
+		})
+	}
+}
+
+func TestProfileProviderNotConfigured(t *testing.T) {
+	isolate(t)
+	if err := (&profileProvider{}).Retrieve(&AliCloudConfig{}); err != errCredentialsNotFound {
+		t.Errorf("Retrieve() without cli config error = %v, want errCredentialsNotFound", err)
+	}
+}
+
+func TestOIDCProvider(t *testing.T) {
+	dir := isolate(t)
+	sts := newFakeSts(t, "oidc-key")
+	// the endpoint is used as is when it has a scheme
+	setupOIDC(t, dir, sts.URL, "service-account-token")
+
+	cfg := &AliCloudConfig{RoleName: "stale"}
+	if err := (&oidcProvider{}).Retrieve(cfg); err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if cfg.AccessKeyID != "oidc-key" || cfg.AccessKeySecret != "oidc-secret" || cfg.StsToken != "oidc-token" || cfg.RegionID != "cn-shanghai" || cfg.RoleName != "" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	if want := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC); !cfg.ExpireTime.Equal(want) {
+		t.Errorf("ExpireTime = %v, want %v", cfg.ExpireTime, want)
+	}
+}
+
+func TestOIDCProviderError(t *testing.T) {
+	dir := isolate(t)
+	sts := newFakeSts(t, "oidc-key")
+	setupOIDC(t, dir, sts.URL, "expired-token")
+
+	err := (&oidcProvider{}).Retrieve(&AliCloudConfig{})
+	if err == nil || !strings.Contains(err.Error(), "InvalidParameter.OIDCToken") || !strings.Contains(err.Error(), "req-1") {
+		t.Errorf("Retrieve() error = %v, want the sts error code and request id", err)
+	}
+}
+
+func TestOIDCProviderNotConfigured(t *testing.T) {
+	isolate(t)
+	setenv(t, "ALIBABA_CLOUD_ROLE_ARN", "acs:ram::123:role/monitoring")
+	if err := (&oidcProvider{}).Retrieve(&AliCloudConfig{}); err != errCredentialsNotFound {
+		t.Errorf("Retrieve() with partial oidc env error = %v, want errCredentialsNotFound", err)
+	}
+}
@@ -0,0 +1,125 @@
+package alicloud
+
+import (
+	"sync"
+	"time"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+)
+
+// RefreshFraction is the fraction of the token lifetime after which the credentials are refreshed.
+var RefreshFraction = 0.8
+
+const (
+	minRefreshInterval   = 10 * time.Second
+	refreshRetryInterval = 30 * time.Second
+)
+
+// CredentialRefresher reloads expiring credentials in the background and swaps the clients of an AliClient.
+// It works on its own copy of the config, so the config passed in is never modified after creation.
+type CredentialRefresher struct {
+	client    *AliClient
+	cfg       AliCloudConfig
+	issued    time.Time
+	expire    time.Time
+	onRefresh []func(expire time.Time, err error)
+	mtx       sync.Mutex
+	stopCh    chan struct{}
+	doneCh    chan struct{}
+	stopOnce  sync.Once
+}
+
+func NewCredentialRefresher(client *AliClient, cfg *AliCloudConfig) *CredentialRefresher {
+	return &CredentialRefresher{
+		client: client,
+		cfg:    *cfg,
+		issued: time.Now(),
+		expire: cfg.ExpireTime,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// OnRefresh registers fn to be called after every refresh attempt with the current expiration and the error, if any.
+func (r *CredentialRefresher) OnRefresh(fn func(expire time.Time, err error)) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.onRefresh = append(r.onRefresh, fn)
+}
+
+func (r *CredentialRefresher) Expiration() time.Time {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.expire
+}
+
+func (r *CredentialRefresher) Start() {
+	go r.run()
+}
+
+// Stop ends the refresh loop and waits for it to exit, it is safe to call more than once.
+func (r *CredentialRefresher) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+	})
+	<-r.doneCh
+}
+
+func (r *CredentialRefresher) run() {
+	defer close(r.doneCh)
+	timer := time.NewTimer(r.nextRefresh(time.Now()))
+	defer timer.Stop()
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-timer.C:
+			wait := refreshRetryInterval
+			if err := r.refresh(); err == nil {
+				wait = r.nextRefresh(time.Now())
+			}
+			log.Logger.Infof("next credential refresh in %v", wait)
+			timer.Reset(wait)
+		}
+	}
+}
+
+func (r *CredentialRefresher) nextRefresh(now time.Time) time.Duration {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	lifetime := r.expire.Sub(r.issued)
+	wait := r.issued.Add(time.Duration(float64(lifetime) * RefreshFraction)).Sub(now)
+	if wait < minRefreshInterval {
+		wait = minRefreshInterval
+	}
+	return wait
+}
+
+func (r *CredentialRefresher) refresh() error {
+	r.mtx.Lock()
+	cfg := r.cfg
+	r.mtx.Unlock()
+
+	err := cfg.GetCloudConfig()
+	if err == nil {
+		err = r.client.setClients(&cfg)
+	}
+
+	r.mtx.Lock()
+	if err != nil {
+		log.Logger.Errorf("failed to refresh credentials, current credentials expire at %v: %v", r.expire, err)
+	} else {
+		log.Logger.Infof("refreshed credentials from %s provider, next expire time %v", cfg.Provider, cfg.ExpireTime)
+		r.cfg = cfg
+		r.issued = time.Now()
+		r.expire = cfg.ExpireTime
+	}
+	expire := r.expire
+	hooks := append([]func(expire time.Time, err error){}, r.onRefresh...)
+	r.mtx.Unlock()
+
+	for _, fn := range hooks {
+		fn(expire, err)
+	}
+	return err
+}
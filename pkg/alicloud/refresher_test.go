@@ -0,0 +1,127 @@
+package alicloud
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+)
+
+func TestMain(m *testing.M) {
+	log.InitLogger("error", "", "text", log.Rotation{})
+	os.Exit(m.Run())
+}
+
+func setRefreshFraction(t *testing.T, fraction float64) {
+	prev := RefreshFraction
+	RefreshFraction = fraction
+	t.Cleanup(func() { RefreshFraction = prev })
+}
+
+func TestNextRefresh(t *testing.T) {
+	issued := time.Date(2020, 10, 19, 10, 0, 0, 0, time.UTC)
+	r := &CredentialRefresher{issued: issued, expire: issued.Add(time.Hour)}
+	tests := []struct {
+		fraction float64
+		now      time.Time
+		want     time.Duration
+	}{
+		{0.8, issued, 48 * time.Minute},
+		{0.5, issued, 30 * time.Minute},
+		{0.8, issued.Add(40 * time.Minute), 8 * time.Minute},
+		// a refresh due in less than the minimum interval or already overdue waits the minimum interval
+		{0.8, issued.Add(48*time.Minute - time.Second), minRefreshInterval},
+		{0.8, issued.Add(2 * time.Hour), minRefreshInterval},
+	}
+	for _, tt := range tests {
+		setRefreshFraction(t, tt.fraction)
+		if got := r.nextRefresh(tt.now); got != tt.want {
+			t.Errorf("nextRefresh(%v) with fraction %v = %v, want %v", tt.now.Sub(issued), tt.fraction, got, tt.want)
+		}
+	}
+}
+
+// newTestRefresher returns a refresher for credentials expiring in an hour, refreshed from the oidc provider.
+func newTestRefresher(t *testing.T, token string) (*CredentialRefresher, *AliCloudConfig) {
+	dir := isolate(t)
+	sts := newFakeSts(t, "refreshed-key")
+	setupOIDC(t, dir, sts.URL, token)
+	cfg := &AliCloudConfig{
+		RegionID:        "cn-shanghai",
+		AccessKeyID:     "old-key",
+		AccessKeySecret: "old-secret",
+		StsToken:        "old-token",
+		ExpireTime:      time.Now().Add(time.Hour),
+		Provider:        "oidc",
+	}
+	client := &AliClient{}
+	if err := client.setClients(cfg); err != nil {
+		t.Fatalf("setClients() error = %v", err)
+	}
+	return NewCredentialRefresher(client, cfg), cfg
+}
+
+func TestRefresh(t *testing.T) {
+	r, cfg := newTestRefresher(t, "service-account-token")
+	initialExpire := cfg.ExpireTime
+	var hookExpire time.Time
+	var hookErr error
+	calls := 0
+	r.OnRefresh(func(expire time.Time, err error) {
+		calls++
+		hookExpire, hookErr = expire, err
+	})
+
+	if err := r.refresh(); err != nil {
+		t.Fatalf("refresh() error = %v", err)
+	}
+	want := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !r.Expiration().Equal(want) {
+		t.Errorf("Expiration() = %v, want %v", r.Expiration(), want)
+	}
+	if calls != 1 || hookErr != nil || !hookExpire.Equal(want) {
+		t.Errorf("hook called %d times with %v, %v", calls, hookExpire, hookErr)
+	}
+	if r.cfg.AccessKeyID != "refreshed-key" {
+		t.Errorf("refreshed access key %s, want refreshed-key", r.cfg.AccessKeyID)
+	}
+	// the refresher works on its own copy of the config
+	if cfg.AccessKeyID != "old-key" || !cfg.ExpireTime.Equal(initialExpire) {
+		t.Errorf("config passed to the refresher was modified: %+v", cfg)
+	}
+}
+
+func TestRefreshFailureKeepsCredentials(t *testing.T) {
+	r, cfg := newTestRefresher(t, "expired-token")
+	var hookErr error
+	r.OnRefresh(func(expire time.Time, err error) {
+		hookErr = err
+	})
+
+	if err := r.refresh(); err == nil {
+		t.Fatalf("refresh() error = nil, want the sts error")
+	}
+	if hookErr == nil {
+		t.Errorf("hook not called with the refresh error")
+	}
+	if !r.Expiration().Equal(cfg.ExpireTime) || r.cfg.AccessKeyID != "old-key" {
+		t.Errorf("failed refresh replaced the credentials: %+v", r.cfg)
+	}
+}
+
+func TestRefresherStop(t *testing.T) {
+	r, _ := newTestRefresher(t, "service-account-token")
+	r.Start()
+	done := make(chan struct{})
+	go func() {
+		r.Stop()
+		r.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stop() did not return")
+	}
+}
@@ -0,0 +1,167 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record is one write operation on a cloud resource.
+type Record struct {
+	Timestamp    time.Time         `json:"timestamp"`
+	Account      string            `json:"account"`
+	Region       string            `json:"region"`
+	ResourceType string            `json:"resourceType"`
+	ResourceId   string            `json:"resourceId"`
+	Operation    string            `json:"operation"`
+	PreviousTags map[string]string `json:"previousTags"`
+	NewTags      map[string]string `json:"newTags"`
+	Actor        string            `json:"actor"`
+	DryRun       bool              `json:"dryRun"`
+	RequestId    string            `json:"requestId"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// Config is the "audit" section of the config file. Webhook receives every record as a json post,
+// e.g. a log collector forwarding to SLS, Headers are added to the post.
+type Config struct {
+	File    string            `mapstructure:"file"`
+	Webhook string            `mapstructure:"webhook"`
+	Headers map[string]string `mapstructure:"headers"`
+}
+
+// Trail appends records to a json lines file and posts them to the webhook.
+type Trail struct {
+	cfg        Config
+	httpClient *http.Client
+	mtx        sync.Mutex
+}
+
+func NewTrail(cfg Config) *Trail {
+	return &Trail{
+		cfg: cfg,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (t *Trail) Enabled() bool {
+	return t != nil && (t.cfg.File != "" || t.cfg.Webhook != "")
+}
+
+// Record writes r to the file and the webhook, a nil Trail or one without destinations does nothing.
+func (t *Trail) Record(r Record) error {
+	if !t.Enabled() {
+		return nil
+	}
+	if r.Timestamp.IsZero() {
+		r.Timestamp = time.Now()
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %v", err)
+	}
+	if t.cfg.File != "" {
+		if err := t.appendLine(line); err != nil {
+			return fmt.Errorf("failed to write audit record: %v", err)
+		}
+	}
+	if t.cfg.Webhook != "" {
+		if err := t.post(line); err != nil {
+			return fmt.Errorf("failed to post audit record: %v", err)
+		}
+	}
+	return nil
+}
+
+func (t *Trail) appendLine(line []byte) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	file, err := os.OpenFile(t.cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (t *Trail) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, t.cfg.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Filter selects records, empty fields match everything.
+type Filter struct {
+	ResourceId string
+	Since      time.Time
+	Until      time.Time
+}
+
+func (f Filter) Match(r Record) bool {
+	if f.ResourceId != "" && r.ResourceId != f.ResourceId {
+		return false
+	}
+	if !f.Since.IsZero() && r.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && r.Timestamp.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Read returns the records of the json lines file at path matching the filter, in the order they were written.
+func Read(path string, filter Filter) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := []Record{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		r := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return records, fmt.Errorf("failed to parse %s line %d: %v", path, lineNumber, err)
+		}
+		if filter.Match(r) {
+			records = append(records, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return records, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return records, nil
+}
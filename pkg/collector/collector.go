@@ -0,0 +1,102 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+)
+
+// QueryFunc queries alicloud and returns the metrics to expose until the next query.
+type QueryFunc func() ([]prometheus.Metric, error)
+
+// CachedCollector runs its QueryFunc when scraped, reusing the result for TTL.
+// Concurrent scrapes with an expired cache share a single query.
+// The scrape metrics of every collector carry its name as const label, so several collectors can be registered together.
+type CachedCollector struct {
+	name                  string
+	ttl                   time.Duration
+	descs                 []*prometheus.Desc
+	lastScrapeSuccessDesc *prometheus.Desc
+	scrapeDurationDesc    *prometheus.Desc
+	query                 QueryFunc
+	group                 singleflight.Group
+	mtx                   sync.Mutex
+	metrics               []prometheus.Metric
+	success               bool
+	duration              time.Duration
+	expire                time.Time
+}
+
+func NewCachedCollector(name string, ttl time.Duration, descs []*prometheus.Desc, query QueryFunc) *CachedCollector {
+	return &CachedCollector{
+		name:  name,
+		ttl:   ttl,
+		descs: descs,
+		lastScrapeSuccessDesc: prometheus.NewDesc(
+			"last_scrape_success",
+			"Whether the last query of the collector succeeded.",
+			nil, prometheus.Labels{"collector": name},
+		),
+		scrapeDurationDesc: prometheus.NewDesc(
+			"scrape_duration_seconds",
+			"Duration of the last query of the collector.",
+			nil, prometheus.Labels{"collector": name},
+		),
+		query: query,
+	}
+}
+
+func (c *CachedCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.descs {
+		ch <- desc
+	}
+	ch <- c.lastScrapeSuccessDesc
+	ch <- c.scrapeDurationDesc
+}
+
+func (c *CachedCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mtx.Lock()
+	fresh := time.Now().Before(c.expire)
+	c.mtx.Unlock()
+	if !fresh {
+		c.group.Do(c.name, func() (interface{}, error) {
+			c.refresh()
+			return nil, nil
+		})
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, metric := range c.metrics {
+		ch <- metric
+	}
+	success := 0.0
+	if c.success {
+		success = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.lastScrapeSuccessDesc, prometheus.GaugeValue, success)
+	ch <- prometheus.MustNewConstMetric(c.scrapeDurationDesc, prometheus.GaugeValue, c.duration.Seconds())
+}
+
+func (c *CachedCollector) refresh() {
+	start := time.Now()
+	metrics, err := c.query()
+	duration := time.Since(start)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.duration = duration
+	c.expire = time.Now().Add(c.ttl)
+	if err != nil {
+		log.Logger.Errorf("collector %s failed: %v", c.name, err)
+		c.metrics = nil
+		c.success = false
+		return
+	}
+	c.metrics = metrics
+	c.success = true
+}
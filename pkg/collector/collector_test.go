@@ -0,0 +1,64 @@
+package collector
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+)
+
+func TestMain(m *testing.M) {
+	log.InitLogger("error", "", "text", log.Rotation{})
+	os.Exit(m.Run())
+}
+
+func newTestCollector(name string, err error, calls *int) *CachedCollector {
+	desc := prometheus.NewDesc(name+"_value", "Test value.", nil, nil)
+	return NewCachedCollector(name, time.Minute, []*prometheus.Desc{desc}, func() ([]prometheus.Metric, error) {
+		*calls++
+		if err != nil {
+			return nil, err
+		}
+		return []prometheus.Metric{prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1)}, nil
+	})
+}
+
+func TestRegisterSeveralCollectors(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	calls := 0
+	if err := reg.Register(newTestCollector("ecs", nil, &calls)); err != nil {
+		t.Fatalf("Register(ecs) error = %v", err)
+	}
+	if err := reg.Register(newTestCollector("stock", fmt.Errorf("failed"), &calls)); err != nil {
+		t.Fatalf("Register(stock) error = %v", err)
+	}
+	expected := `
+# HELP last_scrape_success Whether the last query of the collector succeeded.
+# TYPE last_scrape_success gauge
+last_scrape_success{collector="ecs"} 1
+last_scrape_success{collector="stock"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "last_scrape_success"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectCachesQuery(t *testing.T) {
+	calls := 0
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(newTestCollector("ecs", nil, &calls))
+	for i := 0; i < 3; i++ {
+		if err := testutil.GatherAndCompare(reg, strings.NewReader("# HELP ecs_value Test value.\n# TYPE ecs_value gauge\necs_value 1\n"), "ecs_value"); err != nil {
+			t.Error(err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("queried %d times within ttl, want 1", calls)
+	}
+}
@@ -0,0 +1,186 @@
+package compliance
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+const (
+	ReasonMissing = "missing"
+	ReasonPattern = "pattern"
+	ReasonValue   = "value"
+)
+
+// Rule requires the tag Key, its value must match Pattern and be one of Values when they are set.
+type Rule struct {
+	Key     string   `mapstructure:"key"`
+	Pattern string   `mapstructure:"pattern"`
+	Values  []string `mapstructure:"values"`
+}
+
+// Config is the "compliance" section of the config file, instances are grouped by the first tag of GroupBy they have.
+type Config struct {
+	Rules   []Rule   `mapstructure:"rules"`
+	GroupBy []string `mapstructure:"groupBy"`
+}
+
+// DefaultConfig requires the Environment tag and groups by the Team or Owner tag.
+func DefaultConfig() Config {
+	return Config{
+		Rules:   []Rule{{Key: "Environment"}},
+		GroupBy: []string{"Team", "Owner"},
+	}
+}
+
+type Violation struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+	Value  string `json:"value,omitempty"`
+}
+
+func (v Violation) String() string {
+	switch v.Reason {
+	case ReasonMissing:
+		return fmt.Sprintf("%s missing", v.Key)
+	case ReasonPattern:
+		return fmt.Sprintf("%s=%s does not match pattern", v.Key, v.Value)
+	default:
+		return fmt.Sprintf("%s=%s not allowed", v.Key, v.Value)
+	}
+}
+
+type rule struct {
+	Rule
+	re     *regexp.Regexp
+	values map[string]bool
+}
+
+type Schema struct {
+	rules   []rule
+	groupBy []string
+}
+
+func NewSchema(cfg Config) (*Schema, error) {
+	s := &Schema{groupBy: cfg.GroupBy}
+	for _, r := range cfg.Rules {
+		if r.Key == "" {
+			return nil, fmt.Errorf("compliance rule without key")
+		}
+		compiled := rule{Rule: r}
+		if r.Pattern != "" {
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern for tag %s: %v", r.Key, err)
+			}
+			compiled.re = re
+		}
+		if len(r.Values) > 0 {
+			compiled.values = map[string]bool{}
+			for _, v := range r.Values {
+				compiled.values[v] = true
+			}
+		}
+		s.rules = append(s.rules, compiled)
+	}
+	return s, nil
+}
+
+// Evaluate returns the share of rules the tags satisfy, 1 without rules, and the violations.
+func (s *Schema) Evaluate(tags map[string]string) (float64, []Violation) {
+	violations := []Violation{}
+	if len(s.rules) == 0 {
+		return 1, violations
+	}
+	for _, r := range s.rules {
+		value, ok := tags[r.Key]
+		switch {
+		case !ok:
+			violations = append(violations, Violation{Key: r.Key, Reason: ReasonMissing})
+		case r.re != nil && !r.re.MatchString(value):
+			violations = append(violations, Violation{Key: r.Key, Reason: ReasonPattern, Value: value})
+		case r.values != nil && !r.values[value]:
+			violations = append(violations, Violation{Key: r.Key, Reason: ReasonValue, Value: value})
+		}
+	}
+	return float64(len(s.rules)-len(violations)) / float64(len(s.rules)), violations
+}
+
+// Group returns the value of the first GroupBy tag found, empty when none is set.
+func (s *Schema) Group(tags map[string]string) string {
+	for _, key := range s.groupBy {
+		if v := tags[key]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Result is the compliance of one instance.
+type Result struct {
+	InstanceId   string      `json:"instanceId"`
+	InstanceName string      `json:"instanceName"`
+	VpcId        string      `json:"vpcId"`
+	VpcName      string      `json:"vpcName"`
+	Group        string      `json:"group"`
+	Score        float64     `json:"score"`
+	Violations   []Violation `json:"violations"`
+}
+
+func (r Result) Compliant() bool {
+	return len(r.Violations) == 0
+}
+
+// Summary aggregates the results of one group and vpc.
+type Summary struct {
+	Group     string
+	VpcId     string
+	VpcName   string
+	Instances int
+	Compliant int
+	Score     float64
+	Results   []Result
+}
+
+// Ratio is the share of compliant instances.
+func (s Summary) Ratio() float64 {
+	if s.Instances == 0 {
+		return 1
+	}
+	return float64(s.Compliant) / float64(s.Instances)
+}
+
+// Summarize groups results by group and vpc, Score of a summary is the mean score of its instances.
+func Summarize(results []Result) []Summary {
+	index := map[[2]string]int{}
+	summaries := []Summary{}
+	for _, r := range results {
+		key := [2]string{r.Group, r.VpcId}
+		i, ok := index[key]
+		if !ok {
+			i = len(summaries)
+			index[key] = i
+			summaries = append(summaries, Summary{Group: r.Group, VpcId: r.VpcId, VpcName: r.VpcName})
+		}
+		s := &summaries[i]
+		s.Instances++
+		if r.Compliant() {
+			s.Compliant++
+		}
+		s.Score += r.Score
+		s.Results = append(s.Results, r)
+	}
+	for i := range summaries {
+		summaries[i].Score /= float64(summaries[i].Instances)
+		sort.Slice(summaries[i].Results, func(a, b int) bool {
+			return summaries[i].Results[a].InstanceId < summaries[i].Results[b].InstanceId
+		})
+	}
+	sort.Slice(summaries, func(a, b int) bool {
+		if summaries[a].Group != summaries[b].Group {
+			return summaries[a].Group < summaries[b].Group
+		}
+		return summaries[a].VpcId < summaries[b].VpcId
+	})
+	return summaries
+}
@@ -0,0 +1,203 @@
+package compliance
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testSchema(t *testing.T) *Schema {
+	s, err := NewSchema(Config{
+		Rules: []Rule{
+			{Key: "Environment", Values: []string{"production", "staging"}},
+			{Key: "Team", Pattern: "^[a-z]+$"},
+			{Key: "CostCenter"},
+			{Key: "Service"},
+		},
+		GroupBy: []string{"Team", "Owner"},
+	})
+	if err != nil {
+		t.Fatalf("NewSchema() error = %v", err)
+	}
+	return s
+}
+
+func TestNewSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"default", DefaultConfig(), false},
+		{"empty", Config{}, false},
+		{"rule without key", Config{Rules: []Rule{{Pattern: "x"}}}, true},
+		{"invalid pattern", Config{Rules: []Rule{{Key: "Team", Pattern: "("}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSchema(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	s := testSchema(t)
+	tests := []struct {
+		name           string
+		tags           map[string]string
+		wantScore      float64
+		wantViolations []Violation
+	}{
+		{
+			name:           "compliant",
+			tags:           map[string]string{"Environment": "production", "Team": "infra", "CostCenter": "42", "Service": "api"},
+			wantScore:      1,
+			wantViolations: []Violation{},
+		},
+		{
+			name:      "missing",
+			tags:      map[string]string{"Environment": "staging", "Team": "infra"},
+			wantScore: 0.5,
+			wantViolations: []Violation{
+				{Key: "CostCenter", Reason: ReasonMissing},
+				{Key: "Service", Reason: ReasonMissing},
+			},
+		},
+		{
+			name:      "pattern",
+			tags:      map[string]string{"Environment": "production", "Team": "Infra-1", "CostCenter": "42", "Service": "api"},
+			wantScore: 0.75,
+			wantViolations: []Violation{
+				{Key: "Team", Reason: ReasonPattern, Value: "Infra-1"},
+			},
+		},
+		{
+			name:      "value",
+			tags:      map[string]string{"Environment": "prod", "Team": "infra", "CostCenter": "42", "Service": "api"},
+			wantScore: 0.75,
+			wantViolations: []Violation{
+				{Key: "Environment", Reason: ReasonValue, Value: "prod"},
+			},
+		},
+		{
+			name:           "empty value is not missing",
+			tags:           map[string]string{"Environment": "production", "Team": "infra", "CostCenter": "", "Service": "api"},
+			wantScore:      1,
+			wantViolations: []Violation{},
+		},
+		{
+			name:      "no tags",
+			tags:      map[string]string{},
+			wantScore: 0,
+			wantViolations: []Violation{
+				{Key: "Environment", Reason: ReasonMissing},
+				{Key: "Team", Reason: ReasonMissing},
+				{Key: "CostCenter", Reason: ReasonMissing},
+				{Key: "Service", Reason: ReasonMissing},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, violations := s.Evaluate(tt.tags)
+			if score != tt.wantScore {
+				t.Errorf("Evaluate() score = %v, want %v", score, tt.wantScore)
+			}
+			if !reflect.DeepEqual(violations, tt.wantViolations) {
+				t.Errorf("Evaluate() violations = %v, want %v", violations, tt.wantViolations)
+			}
+		})
+	}
+}
+
+func TestEvaluateWithoutRules(t *testing.T) {
+	s, err := NewSchema(Config{})
+	if err != nil {
+		t.Fatalf("NewSchema() error = %v", err)
+	}
+	score, violations := s.Evaluate(map[string]string{})
+	if score != 1 || len(violations) != 0 {
+		t.Errorf("Evaluate() = %v, %v, want 1 without violations", score, violations)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	s := testSchema(t)
+	tests := []struct {
+		name string
+		tags map[string]string
+		want string
+	}{
+		{"first key", map[string]string{"Team": "infra", "Owner": "alice"}, "infra"},
+		{"fallback", map[string]string{"Owner": "alice"}, "alice"},
+		{"empty value skipped", map[string]string{"Team": "", "Owner": "alice"}, "alice"},
+		{"none", map[string]string{"Environment": "production"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Group(tt.tags); got != tt.want {
+				t.Errorf("Group() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	missing := []Violation{{Key: "Environment", Reason: ReasonMissing}}
+	results := []Result{
+		{InstanceId: "i-3", Group: "web", VpcId: "vpc-1", Score: 1, Violations: []Violation{}},
+		{InstanceId: "i-2", Group: "infra", VpcId: "vpc-2", VpcName: "prod", Score: 0, Violations: missing},
+		{InstanceId: "i-1", Group: "web", VpcId: "vpc-1", Score: 0.5, Violations: missing},
+		{InstanceId: "i-4", Group: "infra", VpcId: "vpc-1", Score: 1, Violations: []Violation{}},
+		{InstanceId: "i-5", Group: "", VpcId: "vpc-1", Score: 1, Violations: []Violation{}},
+	}
+	got := Summarize(results)
+
+	type summary struct {
+		Group, VpcId string
+		Instances    int
+		Compliant    int
+		Score        float64
+		Ids          []string
+	}
+	want := []summary{
+		{"", "vpc-1", 1, 1, 1, []string{"i-5"}},
+		{"infra", "vpc-1", 1, 1, 1, []string{"i-4"}},
+		{"infra", "vpc-2", 1, 0, 0, []string{"i-2"}},
+		{"web", "vpc-1", 2, 1, 0.75, []string{"i-1", "i-3"}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Summarize() returned %d summaries, want %d", len(got), len(want))
+	}
+	for i, s := range got {
+		ids := []string{}
+		for _, r := range s.Results {
+			ids = append(ids, r.InstanceId)
+		}
+		g := summary{s.Group, s.VpcId, s.Instances, s.Compliant, s.Score, ids}
+		if !reflect.DeepEqual(g, want[i]) {
+			t.Errorf("Summarize()[%d] = %+v, want %+v", i, g, want[i])
+		}
+	}
+	if got[2].VpcName != "prod" {
+		t.Errorf("Summarize()[2].VpcName = %q, want %q", got[2].VpcName, "prod")
+	}
+}
+
+func TestRatio(t *testing.T) {
+	tests := []struct {
+		summary Summary
+		want    float64
+	}{
+		{Summary{}, 1},
+		{Summary{Instances: 4, Compliant: 1}, 0.25},
+		{Summary{Instances: 2, Compliant: 2}, 1},
+	}
+	for _, tt := range tests {
+		if got := tt.summary.Ratio(); got != tt.want {
+			t.Errorf("Ratio() of %+v = %v, want %v", tt.summary, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package compliance
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatHTML     = "html"
+	FormatMarkdown = "markdown"
+	FormatCSV      = "csv"
+)
+
+type Report struct {
+	Timestamp time.Time
+	Summaries []Summary
+}
+
+func NewReport(results []Result) Report {
+	return Report{Timestamp: time.Now(), Summaries: Summarize(results)}
+}
+
+// Total sums up all summaries.
+func (r Report) Total() Summary {
+	total := Summary{}
+	for _, s := range r.Summaries {
+		total.Instances += s.Instances
+		total.Compliant += s.Compliant
+		total.Score += s.Score * float64(s.Instances)
+	}
+	if total.Instances > 0 {
+		total.Score /= float64(total.Instances)
+	}
+	return total
+}
+
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatHTML, FormatMarkdown, FormatCSV:
+		return nil
+	}
+	return fmt.Errorf("unknown report format %s, expected %s, %s or %s", format, FormatHTML, FormatMarkdown, FormatCSV)
+}
+
+func Render(w io.Writer, format string, report Report) error {
+	if err := ValidateFormat(format); err != nil {
+		return err
+	}
+	switch format {
+	case FormatHTML:
+		return htmlTemplate.Execute(w, report)
+	case FormatMarkdown:
+		return renderMarkdown(w, report)
+	default:
+		return renderCSV(w, report)
+	}
+}
+
+func groupName(group string) string {
+	if group == "" {
+		return "(none)"
+	}
+	return group
+}
+
+func vpcName(s Summary) string {
+	if s.VpcName == "" {
+		return s.VpcId
+	}
+	return fmt.Sprintf("%s (%s)", s.VpcId, s.VpcName)
+}
+
+func percent(v float64) string {
+	return fmt.Sprintf("%.1f%%", v*100)
+}
+
+func violations(r Result) string {
+	lines := []string{}
+	for _, v := range r.Violations {
+		lines = append(lines, v.String())
+	}
+	return strings.Join(lines, "; ")
+}
+
+// cell escapes the table delimiter in markdown cells.
+func cell(s string) string {
+	return strings.Replace(s, "|", "\\|", -1)
+}
+
+func renderMarkdown(w io.Writer, report Report) error {
+	total := report.Total()
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "# Tag compliance report\n\n")
+	fmt.Fprintf(b, "Generated %s, %d of %d instances compliant (%s), mean score %s.\n\n",
+		report.Timestamp.Format(time.RFC3339), total.Compliant, total.Instances, percent(total.Ratio()), percent(total.Score))
+	fmt.Fprintf(b, "| Group | VPC | Instances | Compliant | Ratio | Score |\n|---|---|---|---|---|---|\n")
+	for _, s := range report.Summaries {
+		fmt.Fprintf(b, "| %s | %s | %d | %d | %s | %s |\n", cell(groupName(s.Group)), cell(vpcName(s)), s.Instances, s.Compliant, percent(s.Ratio()), percent(s.Score))
+	}
+	for _, s := range report.Summaries {
+		if s.Compliant == s.Instances {
+			continue
+		}
+		fmt.Fprintf(b, "\n## %s / %s\n\n| Instance | Name | Score | Violations |\n|---|---|---|---|\n", groupName(s.Group), vpcName(s))
+		for _, r := range s.Results {
+			if r.Compliant() {
+				continue
+			}
+			fmt.Fprintf(b, "| %s | %s | %s | %s |\n", r.InstanceId, cell(r.InstanceName), percent(r.Score), cell(violations(r)))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func renderCSV(w io.Writer, report Report) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"group", "vpcId", "vpcName", "instanceId", "instanceName", "score", "violations"})
+	for _, s := range report.Summaries {
+		for _, r := range s.Results {
+			writer.Write([]string{s.Group, s.VpcId, s.VpcName, r.InstanceId, r.InstanceName, strconv.FormatFloat(r.Score, 'f', 3, 64), violations(r)})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"group":      groupName,
+	"vpc":        vpcName,
+	"percent":    percent,
+	"violations": violations,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Tag compliance report</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.bad { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Tag compliance report</h1>
+{{- with .Total }}
+<p>Generated {{ $.Timestamp.Format "2006-01-02T15:04:05Z07:00" }}, {{ .Compliant }} of {{ .Instances }} instances compliant ({{ percent .Ratio }}), mean score {{ percent .Score }}.</p>
+{{- end }}
+<table>
+<tr><th>Group</th><th>VPC</th><th>Instances</th><th>Compliant</th><th>Ratio</th><th>Score</th></tr>
+{{- range .Summaries }}
+<tr><td>{{ group .Group }}</td><td>{{ vpc . }}</td><td>{{ .Instances }}</td><td>{{ .Compliant }}</td><td>{{ percent .Ratio }}</td><td>{{ percent .Score }}</td></tr>
+{{- end }}
+</table>
+{{- range .Summaries }}
+{{- if ne .Compliant .Instances }}
+<h2>{{ group .Group }} / {{ vpc . }}</h2>
+<table>
+<tr><th>Instance</th><th>Name</th><th>Score</th><th>Violations</th></tr>
+{{- range .Results }}
+{{- if not .Compliant }}
+<tr><td>{{ .InstanceId }}</td><td>{{ .InstanceName }}</td><td>{{ percent .Score }}</td><td class="bad">{{ violations . }}</td></tr>
+{{- end }}
+{{- end }}
+</table>
+{{- end }}
+{{- end }}
+</body>
+</html>
+`))
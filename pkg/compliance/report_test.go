@@ -0,0 +1,135 @@
+package compliance
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func goldenReport() Report {
+	missing := []Violation{{Key: "Environment", Reason: ReasonMissing}}
+	results := []Result{
+		{InstanceId: "i-1", InstanceName: "web|1", Group: "web", VpcId: "vpc-1", VpcName: "prod", Score: 0.5,
+			Violations: []Violation{{Key: "Environment", Reason: ReasonValue, Value: "prd"}, {Key: "Service", Reason: ReasonMissing}}},
+		{InstanceId: "i-2", InstanceName: "web-2", Group: "web", VpcId: "vpc-1", VpcName: "prod", Score: 1, Violations: []Violation{}},
+		{InstanceId: "i-3", InstanceName: "<db>", Group: "", VpcId: "vpc-2", Score: 0, Violations: missing},
+	}
+	return Report{Timestamp: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), Summaries: Summarize(results)}
+}
+
+const goldenMarkdown = `# Tag compliance report
+
+Generated 2020-01-02T03:04:05Z, 1 of 3 instances compliant (33.3%), mean score 50.0%.
+
+| Group | VPC | Instances | Compliant | Ratio | Score |
+|---|---|---|---|---|---|
+| (none) | vpc-2 | 1 | 0 | 0.0% | 0.0% |
+| web | vpc-1 (prod) | 2 | 1 | 50.0% | 75.0% |
+
+## (none) / vpc-2
+
+| Instance | Name | Score | Violations |
+|---|---|---|---|
+| i-3 | <db> | 0.0% | Environment missing |
+
+## web / vpc-1 (prod)
+
+| Instance | Name | Score | Violations |
+|---|---|---|---|
+| i-1 | web\|1 | 50.0% | Environment=prd not allowed; Service missing |
+`
+
+const goldenCSV = `group,vpcId,vpcName,instanceId,instanceName,score,violations
+,vpc-2,,i-3,<db>,0.000,Environment missing
+web,vpc-1,prod,i-1,web|1,0.500,Environment=prd not allowed; Service missing
+web,vpc-1,prod,i-2,web-2,1.000,
+`
+
+const goldenHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Tag compliance report</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.bad { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Tag compliance report</h1>
+<p>Generated 2020-01-02T03:04:05Z, 1 of 3 instances compliant (33.3%), mean score 50.0%.</p>
+<table>
+<tr><th>Group</th><th>VPC</th><th>Instances</th><th>Compliant</th><th>Ratio</th><th>Score</th></tr>
+<tr><td>(none)</td><td>vpc-2</td><td>1</td><td>0</td><td>0.0%</td><td>0.0%</td></tr>
+<tr><td>web</td><td>vpc-1 (prod)</td><td>2</td><td>1</td><td>50.0%</td><td>75.0%</td></tr>
+</table>
+<h2>(none) / vpc-2</h2>
+<table>
+<tr><th>Instance</th><th>Name</th><th>Score</th><th>Violations</th></tr>
+<tr><td>i-3</td><td>&lt;db&gt;</td><td>0.0%</td><td class="bad">Environment missing</td></tr>
+</table>
+<h2>web / vpc-1 (prod)</h2>
+<table>
+<tr><th>Instance</th><th>Name</th><th>Score</th><th>Violations</th></tr>
+<tr><td>i-1</td><td>web|1</td><td>50.0%</td><td class="bad">Environment=prd not allowed; Service missing</td></tr>
+</table>
+</body>
+</html>
+`
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{FormatMarkdown, goldenMarkdown},
+		{FormatCSV, goldenCSV},
+		{FormatHTML, goldenHTML},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			b := &strings.Builder{}
+			if err := Render(b, tt.format, goldenReport()); err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("Render() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderUnknownFormat(t *testing.T) {
+	if err := Render(&strings.Builder{}, "pdf", goldenReport()); err == nil {
+		t.Errorf("Render() with unknown format did not fail")
+	}
+}
+
+func TestTotal(t *testing.T) {
+	tests := []struct {
+		name   string
+		report Report
+		want   Summary
+	}{
+		{"empty", Report{}, Summary{}},
+		{"weighted by instances", goldenReport(), Summary{Instances: 3, Compliant: 1, Score: 0.5}},
+		{
+			name: "several summaries",
+			report: Report{Summaries: []Summary{
+				{Instances: 1, Compliant: 1, Score: 1},
+				{Instances: 3, Compliant: 0, Score: 0.5},
+			}},
+			want: Summary{Instances: 4, Compliant: 1, Score: 0.625},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.report.Total()
+			if got.Instances != tt.want.Instances || got.Compliant != tt.want.Compliant || got.Score != tt.want.Score {
+				t.Errorf("Total() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package envmap
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// FallbackName uses the vpc name as environment, the behaviour before the mapping was configurable.
+	FallbackName = "name"
+	// FallbackUnknown uses the Unknown value as environment.
+	FallbackUnknown = "unknown"
+	// FallbackSkip leaves the environment empty, instances in the vpc are not tagged.
+	FallbackSkip = "skip"
+)
+
+// Rule rewrites a vpc name matching the regular expression Match to Replace, Replace may use $1 style references.
+type Rule struct {
+	Match   string `mapstructure:"match"`
+	Replace string `mapstructure:"replace"`
+}
+
+// Config is the "environment" section of the config file.
+// Keys of IDs and Names are compared case insensitive, the config file loader lowercases them.
+type Config struct {
+	IDs      map[string]string `mapstructure:"ids"`
+	Names    map[string]string `mapstructure:"names"`
+	Rules    []Rule            `mapstructure:"rules"`
+	TagKey   string            `mapstructure:"tagKey"`
+	Fallback string            `mapstructure:"fallback"`
+	Unknown  string            `mapstructure:"unknown"`
+}
+
+// DefaultConfig maps the vpc "dev" to "develop" and every other vpc to its name.
+func DefaultConfig() Config {
+	return Config{
+		Names:    map[string]string{"dev": "develop"},
+		Fallback: FallbackName,
+		Unknown:  "unknown",
+	}
+}
+
+// VPC is what the mapper needs to know about a vpc, Tags is only filled when TagKey is configured.
+type VPC struct {
+	ID   string
+	Name string
+	Tags map[string]string
+}
+
+// Environment is the result of resolving a vpc, Name may be set for an unresolved vpc by the unknown fallback.
+type Environment struct {
+	Name     string
+	Resolved bool
+}
+
+type rule struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+type Mapper struct {
+	cfg   Config
+	ids   map[string]string
+	names map[string]string
+	rules []rule
+}
+
+func NewMapper(cfg Config) (*Mapper, error) {
+	if cfg.Fallback == "" {
+		cfg.Fallback = FallbackName
+	}
+	if cfg.Unknown == "" {
+		cfg.Unknown = "unknown"
+	}
+	switch cfg.Fallback {
+	case FallbackName, FallbackUnknown, FallbackSkip:
+	default:
+		return nil, fmt.Errorf("unknown fallback: %s, must be %s, %s or %s", cfg.Fallback, FallbackName, FallbackUnknown, FallbackSkip)
+	}
+	m := &Mapper{
+		cfg:   cfg,
+		ids:   map[string]string{},
+		names: map[string]string{},
+	}
+	for k, v := range cfg.IDs {
+		m.ids[strings.ToLower(k)] = v
+	}
+	for k, v := range cfg.Names {
+		m.names[strings.ToLower(k)] = v
+	}
+	for _, r := range cfg.Rules {
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid environment rule %s: %v", r.Match, err)
+		}
+		m.rules = append(m.rules, rule{re: re, replace: r.Replace})
+	}
+	return m, nil
+}
+
+// TagKey is the vpc tag holding the environment, empty when vpc tags are not used.
+func (m *Mapper) TagKey() string {
+	return m.cfg.TagKey
+}
+
+// Resolve looks up the environment of vpc by id, tag, name and rules in that order and applies the fallback
+// when none of them matches.
+func (m *Mapper) Resolve(vpc VPC) Environment {
+	if env, ok := m.ids[strings.ToLower(vpc.ID)]; ok && env != "" {
+		return Environment{Name: env, Resolved: true}
+	}
+	if m.cfg.TagKey != "" {
+		if env := vpc.Tags[m.cfg.TagKey]; env != "" {
+			return Environment{Name: env, Resolved: true}
+		}
+	}
+	if env, ok := m.names[strings.ToLower(vpc.Name)]; ok && env != "" {
+		return Environment{Name: env, Resolved: true}
+	}
+	for _, r := range m.rules {
+		if r.re.MatchString(vpc.Name) {
+			if env := r.re.ReplaceAllString(vpc.Name, r.replace); env != "" {
+				return Environment{Name: env, Resolved: true}
+			}
+		}
+	}
+	switch m.cfg.Fallback {
+	case FallbackName:
+		if vpc.Name != "" {
+			return Environment{Name: vpc.Name, Resolved: true}
+		}
+	case FallbackUnknown:
+		return Environment{Name: m.cfg.Unknown}
+	}
+	return Environment{}
+}
@@ -0,0 +1,169 @@
+package iac
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+)
+
+const (
+	// ModeSkip leaves managed instances out silently.
+	ModeSkip = "skip"
+	// ModeReport leaves managed instances out and reports the tags that would have been written.
+	ModeReport = "report"
+
+	// DriftMissing is a tag declared in state but not on the instance.
+	DriftMissing = "missing"
+	// DriftUnexpected is a tag on the instance that is not declared in state.
+	DriftUnexpected = "unexpected"
+	// DriftChanged is a tag with another value on the instance than declared in state.
+	DriftChanged = "changed"
+)
+
+// instanceTypes are the terraform resource types of ecs instances.
+var instanceTypes = map[string]bool{
+	"alicloud_instance":     true,
+	"alicloud_ecs_instance": true,
+}
+
+// Instance is an ecs instance declared in terraform state.
+type Instance struct {
+	Id      string
+	Address string
+	Tags    map[string]string
+}
+
+// State holds the ecs instances of one or more terraform state files by instance id.
+type State struct {
+	Instances map[string]Instance
+}
+
+type tfState struct {
+	Version   int          `json:"version"`
+	Resources []tfResource `json:"resources"`
+}
+
+type tfResource struct {
+	Module    string       `json:"module"`
+	Mode      string       `json:"mode"`
+	Type      string       `json:"type"`
+	Name      string       `json:"name"`
+	Instances []tfInstance `json:"instances"`
+}
+
+type tfInstance struct {
+	IndexKey   interface{}     `json:"index_key"`
+	Attributes json.RawMessage `json:"attributes"`
+}
+
+type tfAttributes struct {
+	Id   string            `json:"id"`
+	Tags map[string]string `json:"tags"`
+}
+
+// LoadState reads local terraform state files in the json format of terraform 0.12 and later (state version 4).
+func LoadState(paths []string) (*State, error) {
+	state := &State{Instances: map[string]Instance{}}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read terraform state %s: %v", path, err)
+		}
+		tf := tfState{}
+		if err := json.Unmarshal(data, &tf); err != nil {
+			return nil, fmt.Errorf("failed to parse terraform state %s: %v", path, err)
+		}
+		if tf.Version != 4 {
+			return nil, fmt.Errorf("unsupported terraform state version %d in %s, expected 4", tf.Version, path)
+		}
+		for _, resource := range tf.Resources {
+			if resource.Mode != "managed" || !instanceTypes[resource.Type] {
+				continue
+			}
+			for _, instance := range resource.Instances {
+				attributes := tfAttributes{}
+				if err := json.Unmarshal(instance.Attributes, &attributes); err != nil {
+					return nil, fmt.Errorf("failed to parse attributes of %s.%s in %s: %v", resource.Type, resource.Name, path, err)
+				}
+				if attributes.Id == "" {
+					continue
+				}
+				if attributes.Tags == nil {
+					attributes.Tags = map[string]string{}
+				}
+				state.Instances[attributes.Id] = Instance{
+					Id:      attributes.Id,
+					Address: address(resource, instance.IndexKey),
+					Tags:    attributes.Tags,
+				}
+			}
+		}
+	}
+	return state, nil
+}
+
+func address(resource tfResource, indexKey interface{}) string {
+	addr := resource.Type + "." + resource.Name
+	if resource.Module != "" {
+		addr = resource.Module + "." + addr
+	}
+	switch key := indexKey.(type) {
+	case string:
+		addr += fmt.Sprintf("[%q]", key)
+	case float64:
+		addr += fmt.Sprintf("[%d]", int(key))
+	}
+	return addr
+}
+
+// Managed returns the declaration of the instance, a nil State manages nothing.
+func (s *State) Managed(instanceId string) (Instance, bool) {
+	if s == nil {
+		return Instance{}, false
+	}
+	instance, ok := s.Instances[instanceId]
+	return instance, ok
+}
+
+// Drift is the difference of one tag between state and the instance.
+type Drift struct {
+	Key       string
+	Direction string
+	Declared  string
+	Actual    string
+}
+
+// Compare returns the drift of the actual tags from the declared ones, actual tag keys matching one of ignore,
+// e.g. tags set by alicloud services, are not reported as unexpected.
+func Compare(declared map[string]string, actual map[string]string, ignore []*regexp.Regexp) []Drift {
+	drifts := []Drift{}
+	for key, value := range declared {
+		actualValue, ok := actual[key]
+		if !ok {
+			drifts = append(drifts, Drift{Key: key, Direction: DriftMissing, Declared: value})
+		} else if actualValue != value {
+			drifts = append(drifts, Drift{Key: key, Direction: DriftChanged, Declared: value, Actual: actualValue})
+		}
+	}
+	for key, value := range actual {
+		if _, ok := declared[key]; ok || ignored(key, ignore) {
+			continue
+		}
+		drifts = append(drifts, Drift{Key: key, Direction: DriftUnexpected, Actual: value})
+	}
+	sort.Slice(drifts, func(i, j int) bool {
+		return drifts[i].Key < drifts[j].Key
+	})
+	return drifts
+}
+
+func ignored(key string, ignore []*regexp.Regexp) bool {
+	for _, re := range ignore {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
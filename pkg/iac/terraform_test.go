@@ -0,0 +1,181 @@
+package iac
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func writeState(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "iac")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "terraform.tfstate")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+	return path
+}
+
+const testState = `{
+  "version": 4,
+  "terraform_version": "0.12.29",
+  "resources": [
+    {
+      "mode": "managed",
+      "type": "alicloud_instance",
+      "name": "web",
+      "instances": [
+        {"index_key": 0, "attributes": {"id": "i-web0", "tags": {"Environment": "production"}}},
+        {"index_key": 1, "attributes": {"id": "i-web1", "tags": null}}
+      ]
+    },
+    {
+      "module": "module.db",
+      "mode": "managed",
+      "type": "alicloud_ecs_instance",
+      "name": "this",
+      "instances": [
+        {"index_key": "primary", "attributes": {"id": "i-db", "tags": {"Team": "data"}}}
+      ]
+    },
+    {
+      "mode": "managed",
+      "type": "alicloud_instance",
+      "name": "bastion",
+      "instances": [
+        {"attributes": {"id": "i-bastion", "tags": {}}},
+        {"attributes": {"id": ""}}
+      ]
+    },
+    {
+      "mode": "data",
+      "type": "alicloud_instance",
+      "name": "lookup",
+      "instances": [{"attributes": {"id": "i-data"}}]
+    },
+    {
+      "mode": "managed",
+      "type": "alicloud_vpc",
+      "name": "main",
+      "instances": [{"attributes": {"id": "vpc-main"}}]
+    }
+  ]
+}`
+
+func TestLoadState(t *testing.T) {
+	state, err := LoadState([]string{writeState(t, testState)})
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	want := map[string]Instance{
+		"i-web0":    {Id: "i-web0", Address: "alicloud_instance.web[0]", Tags: map[string]string{"Environment": "production"}},
+		"i-web1":    {Id: "i-web1", Address: "alicloud_instance.web[1]", Tags: map[string]string{}},
+		"i-db":      {Id: "i-db", Address: `module.db.alicloud_ecs_instance.this["primary"]`, Tags: map[string]string{"Team": "data"}},
+		"i-bastion": {Id: "i-bastion", Address: "alicloud_instance.bastion", Tags: map[string]string{}},
+	}
+	if !reflect.DeepEqual(state.Instances, want) {
+		t.Errorf("LoadState() = %v, want %v", state.Instances, want)
+	}
+}
+
+func TestLoadStateMerge(t *testing.T) {
+	other := `{"version": 4, "resources": [{"mode": "managed", "type": "alicloud_instance", "name": "other",
+		"instances": [{"attributes": {"id": "i-other", "tags": {}}}]}]}`
+	state, err := LoadState([]string{writeState(t, testState), writeState(t, other)})
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if len(state.Instances) != 5 {
+		t.Errorf("LoadState() loaded %d instances, want 5", len(state.Instances))
+	}
+	if instance, ok := state.Managed("i-other"); !ok || instance.Address != "alicloud_instance.other" {
+		t.Errorf("Managed() = %v, %v, want alicloud_instance.other", instance, ok)
+	}
+}
+
+func TestLoadStateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"version 3", `{"version": 3, "modules": []}`},
+		{"invalid json", `{"version": 4,`},
+		{"invalid attributes", `{"version": 4, "resources": [{"mode": "managed", "type": "alicloud_instance", "name": "web",
+			"instances": [{"attributes": {"id": "i-web", "tags": ["Environment"]}}]}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadState([]string{writeState(t, tt.content)}); err == nil {
+				t.Errorf("LoadState() did not fail")
+			}
+		})
+	}
+	if _, err := LoadState([]string{filepath.Join(os.TempDir(), "missing.tfstate")}); err == nil {
+		t.Errorf("LoadState() of a missing file did not fail")
+	}
+}
+
+func TestManagedNilState(t *testing.T) {
+	var state *State
+	if _, ok := state.Managed("i-web0"); ok {
+		t.Errorf("Managed() of nil state = true, want false")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ignore := []*regexp.Regexp{regexp.MustCompile("^acs:"), regexp.MustCompile("^ack\\.")}
+	tests := []struct {
+		name     string
+		declared map[string]string
+		actual   map[string]string
+		want     []Drift
+	}{
+		{
+			name:     "no drift",
+			declared: map[string]string{"Environment": "production"},
+			actual:   map[string]string{"Environment": "production"},
+			want:     []Drift{},
+		},
+		{
+			name:     "missing",
+			declared: map[string]string{"Environment": "production", "Team": "infra"},
+			actual:   map[string]string{"Environment": "production"},
+			want:     []Drift{{Key: "Team", Direction: DriftMissing, Declared: "infra"}},
+		},
+		{
+			name:     "changed",
+			declared: map[string]string{"Environment": "production"},
+			actual:   map[string]string{"Environment": "staging"},
+			want:     []Drift{{Key: "Environment", Direction: DriftChanged, Declared: "production", Actual: "staging"}},
+		},
+		{
+			name:     "unexpected and ignored",
+			declared: map[string]string{},
+			actual:   map[string]string{"Owner": "alice", "acs:autoscaling:scalingGroupId": "asg-1", "ack.aliyun.com": "c1"},
+			want:     []Drift{{Key: "Owner", Direction: DriftUnexpected, Actual: "alice"}},
+		},
+		{
+			name:     "sorted by key",
+			declared: map[string]string{"Team": "infra", "Environment": "production"},
+			actual:   map[string]string{"Environment": "prod", "Owner": "alice"},
+			want: []Drift{
+				{Key: "Environment", Direction: DriftChanged, Declared: "production", Actual: "prod"},
+				{Key: "Owner", Direction: DriftUnexpected, Actual: "alice"},
+				{Key: "Team", Direction: DriftMissing, Declared: "infra"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Compare(tt.declared, tt.actual, ignore); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Compare() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package inventory
+
+import (
+	"sort"
+)
+
+const (
+	EventCreated  = "created"
+	EventDeleted  = "deleted"
+	EventModified = "modified"
+)
+
+type Change struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type Event struct {
+	Type         string   `json:"type"`
+	InstanceId   string   `json:"instanceId"`
+	InstanceName string   `json:"instanceName"`
+	Changes      []Change `json:"changes,omitempty"`
+}
+
+// Diff compares two snapshots and returns the events needed to get from prev to cur, ordered by instance id.
+func Diff(prev *Snapshot, cur *Snapshot) []Event {
+	events := []Event{}
+	for id, curInstance := range cur.Instances {
+		prevInstance, ok := prev.Instances[id]
+		if !ok {
+			events = append(events, Event{Type: EventCreated, InstanceId: id, InstanceName: curInstance.InstanceName})
+			continue
+		}
+		changes := diffInstance(prevInstance, curInstance)
+		if len(changes) > 0 {
+			events = append(events, Event{Type: EventModified, InstanceId: id, InstanceName: curInstance.InstanceName, Changes: changes})
+		}
+	}
+	for id, prevInstance := range prev.Instances {
+		if _, ok := cur.Instances[id]; !ok {
+			events = append(events, Event{Type: EventDeleted, InstanceId: id, InstanceName: prevInstance.InstanceName})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].InstanceId < events[j].InstanceId
+	})
+	return events
+}
+
+func diffInstance(prev Instance, cur Instance) []Change {
+	changes := []Change{}
+	if prev.Status != cur.Status {
+		changes = append(changes, Change{Field: "status", Old: prev.Status, New: cur.Status})
+	}
+	if prev.InstanceType != cur.InstanceType {
+		changes = append(changes, Change{Field: "type", Old: prev.InstanceType, New: cur.InstanceType})
+	}
+	if prev.InstanceName != cur.InstanceName {
+		changes = append(changes, Change{Field: "name", Old: prev.InstanceName, New: cur.InstanceName})
+	}
+
+	keys := []string{}
+	for k := range prev.Tags {
+		keys = append(keys, k)
+	}
+	for k := range cur.Tags {
+		if _, ok := prev.Tags[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		oldValue, oldOk := prev.Tags[k]
+		newValue, newOk := cur.Tags[k]
+		if oldOk != newOk || oldValue != newValue {
+			changes = append(changes, Change{Field: "tag:" + k, Old: oldValue, New: newValue})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,94 @@
+package inventory
+
+import (
+	"reflect"
+	"testing"
+)
+
+func snapshot(instances ...Instance) *Snapshot {
+	s := &Snapshot{Instances: map[string]Instance{}}
+	for _, instance := range instances {
+		s.Instances[instance.InstanceId] = instance
+	}
+	return s
+}
+
+func TestDiff(t *testing.T) {
+	running := Instance{InstanceId: "i-1", InstanceName: "web", InstanceType: "ecs.g6.large", Status: "Running", Tags: map[string]string{"Environment": "production", "team": "infra"}}
+	stopped := running
+	stopped.Status = "Stopped"
+	resized := running
+	resized.InstanceType = "ecs.g6.xlarge"
+	renamed := running
+	renamed.InstanceName = "web-1"
+	retagged := running
+	retagged.Tags = map[string]string{"Environment": "staging", "owner": "ops"}
+
+	tests := []struct {
+		name string
+		prev *Snapshot
+		cur  *Snapshot
+		want []Event
+	}{
+		{
+			name: "unchanged",
+			prev: snapshot(running),
+			cur:  snapshot(running),
+			want: []Event{},
+		},
+		{
+			name: "created and deleted",
+			prev: snapshot(running, Instance{InstanceId: "i-3", InstanceName: "old"}),
+			cur:  snapshot(running, Instance{InstanceId: "i-2", InstanceName: "new"}),
+			want: []Event{
+				{Type: EventCreated, InstanceId: "i-2", InstanceName: "new"},
+				{Type: EventDeleted, InstanceId: "i-3", InstanceName: "old"},
+			},
+		},
+		{
+			name: "status",
+			prev: snapshot(running),
+			cur:  snapshot(stopped),
+			want: []Event{{Type: EventModified, InstanceId: "i-1", InstanceName: "web", Changes: []Change{{Field: "status", Old: "Running", New: "Stopped"}}}},
+		},
+		{
+			name: "type",
+			prev: snapshot(running),
+			cur:  snapshot(resized),
+			want: []Event{{Type: EventModified, InstanceId: "i-1", InstanceName: "web", Changes: []Change{{Field: "type", Old: "ecs.g6.large", New: "ecs.g6.xlarge"}}}},
+		},
+		{
+			name: "name",
+			prev: snapshot(running),
+			cur:  snapshot(renamed),
+			want: []Event{{Type: EventModified, InstanceId: "i-1", InstanceName: "web-1", Changes: []Change{{Field: "name", Old: "web", New: "web-1"}}}},
+		},
+		{
+			name: "tags changed, added and removed",
+			prev: snapshot(running),
+			cur:  snapshot(retagged),
+			want: []Event{{Type: EventModified, InstanceId: "i-1", InstanceName: "web", Changes: []Change{
+				{Field: "tag:Environment", Old: "production", New: "staging"},
+				{Field: "tag:owner", Old: "", New: "ops"},
+				{Field: "tag:team", Old: "infra", New: ""},
+			}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff(tt.prev, tt.cur); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffEmptyTagValue(t *testing.T) {
+	// a tag set to an empty value is a change from a missing tag
+	prev := snapshot(Instance{InstanceId: "i-1", Tags: map[string]string{}})
+	cur := snapshot(Instance{InstanceId: "i-1", Tags: map[string]string{"team": ""}})
+	want := []Event{{Type: EventModified, InstanceId: "i-1", Changes: []Change{{Field: "tag:team", Old: "", New: ""}}}}
+	if got := Diff(prev, cur); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %+v, want %+v", got, want)
+	}
+}
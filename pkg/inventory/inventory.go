@@ -0,0 +1,106 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+)
+
+// Instance is the subset of ecs.Instance kept in a snapshot.
+type Instance struct {
+	InstanceId   string            `json:"instanceId"`
+	InstanceName string            `json:"instanceName"`
+	InstanceType string            `json:"instanceType"`
+	Status       string            `json:"status"`
+	ZoneId       string            `json:"zoneId"`
+	VpcId        string            `json:"vpcId"`
+	Tags         map[string]string `json:"tags"`
+}
+
+type Snapshot struct {
+	Timestamp time.Time           `json:"timestamp"`
+	Instances map[string]Instance `json:"instances"`
+}
+
+func NewSnapshot(instances []ecs.Instance) *Snapshot {
+	snapshot := &Snapshot{
+		Timestamp: time.Now(),
+		Instances: map[string]Instance{},
+	}
+	for _, ecsInstance := range instances {
+		tags := map[string]string{}
+		for _, tag := range ecsInstance.Tags.Tag {
+			tags[tag.TagKey] = tag.TagValue
+		}
+		snapshot.Instances[ecsInstance.InstanceId] = Instance{
+			InstanceId:   ecsInstance.InstanceId,
+			InstanceName: ecsInstance.InstanceName,
+			InstanceType: ecsInstance.InstanceType,
+			Status:       ecsInstance.Status,
+			ZoneId:       ecsInstance.ZoneId,
+			VpcId:        ecsInstance.VpcAttributes.VpcId,
+			Tags:         tags,
+		}
+	}
+	return snapshot
+}
+
+// LoadSnapshot reads a snapshot from path, returning nil without error when the file does not exist yet.
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory snapshot %s: %v", path, err)
+	}
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory snapshot %s: %v", path, err)
+	}
+	return snapshot, nil
+}
+
+// Save writes the snapshot to a temporary file and renames it over path so a crash never leaves a partial file.
+func (s *Snapshot) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode inventory snapshot: %v", err)
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save inventory snapshot %s: %v", path, err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to save inventory snapshot %s: %v", path, err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to save inventory snapshot %s: %v", path, err)
+	}
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return fmt.Errorf("failed to save inventory snapshot %s: %v", path, err)
+	}
+	return nil
+}
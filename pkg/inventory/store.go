@@ -0,0 +1,142 @@
+/*
+Copyright © 2019 Allan Hung <hung.allan@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package inventory
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+)
+
+// Store keeps the last inventory snapshot, optionally persisted to a file, and reports the changes of every update.
+type Store struct {
+	Path       string
+	WebhookURL string
+	pm         *monitor.InventoryMonitor
+	last       *Snapshot
+	loaded     bool
+	mtx        sync.Mutex
+}
+
+type webhookPayload struct {
+	Timestamp time.Time `json:"timestamp"`
+	Events    []Event   `json:"events"`
+}
+
+func NewStore(path string, webhookURL string, pm *monitor.InventoryMonitor) *Store {
+	return &Store{
+		Path:       path,
+		WebhookURL: webhookURL,
+		pm:         pm,
+	}
+}
+
+// Update records the current instance list as the latest snapshot and returns the events since the previous one.
+// No events are returned when there is no previous snapshot to compare against.
+func (s *Store) Update(instances []ecs.Instance) ([]Event, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if !s.loaded && s.Path != "" {
+		last, err := LoadSnapshot(s.Path)
+		if err != nil {
+			return nil, err
+		}
+		s.last = last
+	}
+	s.loaded = true
+
+	cur := NewSnapshot(instances)
+	events := []Event{}
+	if s.last == nil {
+		log.Logger.Infof("no previous inventory snapshot, recording %d instances as baseline", len(cur.Instances))
+	} else {
+		events = Diff(s.last, cur)
+	}
+	s.last = cur
+	s.report(cur, events)
+
+	if s.Path != "" {
+		if err := cur.Save(s.Path); err != nil {
+			return events, err
+		}
+	}
+	if s.WebhookURL != "" && len(events) > 0 {
+		if err := s.postWebhook(cur.Timestamp, events); err != nil {
+			return events, err
+		}
+	}
+	return events, nil
+}
+
+func (s *Store) report(cur *Snapshot, events []Event) {
+	if s.pm != nil {
+		s.pm.Instances.Set(float64(len(cur.Instances)))
+	}
+	for _, event := range events {
+		entry := log.Logger.WithField("event", event.Type).WithField("instance_id", event.InstanceId).WithField("instance_name", event.InstanceName)
+		if event.Type != EventModified {
+			entry.Infof("instance %s", event.Type)
+			if s.pm != nil {
+				s.pm.Events.With(prometheus.Labels{"event": event.Type, "field": ""}).Inc()
+			}
+			continue
+		}
+		for _, change := range event.Changes {
+			entry.WithField("field", change.Field).WithField("old", change.Old).WithField("new", change.New).Infof("instance %s", event.Type)
+			if s.pm != nil {
+				s.pm.Events.With(prometheus.Labels{"event": event.Type, "field": changeLabel(change.Field)}).Inc()
+			}
+		}
+	}
+}
+
+// changeLabel folds all tag changes into one label value to keep the counter cardinality bounded.
+func changeLabel(field string) string {
+	if strings.HasPrefix(field, "tag:") {
+		return "tag"
+	}
+	return field
+}
+
+func (s *Store) postWebhook(timestamp time.Time, events []Event) error {
+	body, err := json.Marshal(webhookPayload{Timestamp: timestamp, Events: events})
+	if err != nil {
+		return fmt.Errorf("failed to encode inventory events: %v", err)
+	}
+	httpClient := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+	resp, err := httpClient.Post(s.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post inventory events: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to post inventory events: unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package inventory
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+	"github.com/allanhung/alicloud-monitoring/pkg/monitor"
+)
+
+func TestMain(m *testing.M) {
+	log.InitLogger("error", "", "text", log.Rotation{})
+	os.Exit(m.Run())
+}
+
+func ecsInstance(id string, status string, tags map[string]string) ecs.Instance {
+	instance := ecs.Instance{InstanceId: id, InstanceName: "name-" + id, Status: status}
+	for k, v := range tags {
+		instance.Tags.Tag = append(instance.Tags.Tag, ecs.Tag{TagKey: k, TagValue: v})
+	}
+	return instance
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestStoreUpdate(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	pm := monitor.NewInventoryMonitor(reg)
+	s := NewStore("", "", pm)
+
+	events, err := s.Update([]ecs.Instance{ecsInstance("i-1", "Running", nil), ecsInstance("i-2", "Running", nil)})
+	if err != nil || len(events) != 0 {
+		t.Fatalf("first Update() = %v, %v, want the baseline without events", events, err)
+	}
+	events, err = s.Update([]ecs.Instance{ecsInstance("i-1", "Stopped", map[string]string{"team": "infra"}), ecsInstance("i-3", "Running", nil)})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	types := []string{}
+	for _, event := range events {
+		types = append(types, event.InstanceId+":"+event.Type)
+	}
+	if got, want := strings.Join(types, ","), "i-1:modified,i-2:deleted,i-3:created"; got != want {
+		t.Errorf("events %s, want %s", got, want)
+	}
+
+	expected := `
+# HELP ecsinventoryevents Change events detected between ecs inventory snapshots.
+# TYPE ecsinventoryevents counter
+ecsinventoryevents{event="created",field=""} 1
+ecsinventoryevents{event="deleted",field=""} 1
+ecsinventoryevents{event="modified",field="status"} 1
+ecsinventoryevents{event="modified",field="tag"} 1
+# HELP ecsinventoryinstances Number of ecs instances in the latest inventory snapshot.
+# TYPE ecsinventoryinstances gauge
+ecsinventoryinstances 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "ecsinventoryevents", "ecsinventoryinstances"); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+func TestStorePersistsSnapshot(t *testing.T) {
+	path := filepath.Join(tempDir(t), "inventory.json")
+	if _, err := NewStore(path, "", nil).Update([]ecs.Instance{ecsInstance("i-1", "Running", nil)}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	// a new store, e.g. after a restart, diffs against the saved snapshot instead of starting a new baseline
+	events, err := NewStore(path, "", nil).Update([]ecs.Instance{ecsInstance("i-1", "Stopped", nil)})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if len(events) != 1 || events[0].Type != EventModified {
+		t.Errorf("events after restart %+v, want the status change", events)
+	}
+	saved, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot() error = %v", err)
+	}
+	if saved.Instances["i-1"].Status != "Stopped" {
+		t.Errorf("saved snapshot %+v, want the latest update", saved.Instances)
+	}
+}
+
+func TestStoreWebhook(t *testing.T) {
+	posted := []webhookPayload{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := webhookPayload{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode payload: %v", err)
+		}
+		posted = append(posted, payload)
+	}))
+	defer server.Close()
+
+	s := NewStore("", server.URL, nil)
+	instances := []ecs.Instance{ecsInstance("i-1", "Running", nil)}
+	for i := 0; i < 2; i++ {
+		if _, err := s.Update(instances); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+	if len(posted) != 0 {
+		t.Fatalf("posted %d payloads without events, want none", len(posted))
+	}
+	if _, err := s.Update(nil); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if len(posted) != 1 || len(posted[0].Events) != 1 || posted[0].Events[0].Type != EventDeleted {
+		t.Errorf("posted %+v, want one deleted event", posted)
+	}
+}
+
+func TestStoreWebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	s := NewStore("", server.URL, nil)
+	s.Update([]ecs.Instance{ecsInstance("i-1", "Running", nil)})
+	events, err := s.Update(nil)
+	if err == nil || len(events) != 1 {
+		t.Errorf("Update() = %v, %v, want the events and the webhook error", events, err)
+	}
+}
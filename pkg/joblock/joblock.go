@@ -16,19 +16,37 @@ limitations under the License.
 package joblock
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
 )
 
 type JobLock struct {
 	mtx       sync.Mutex
+	Name      string
 	IsRunning bool
 	Kind      string
+	RunID     string
+	logger    *logrus.Entry
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
+// SetRun starts a run with a new run id, lines logged with Log carry the job name and the run id.
 func (m *JobLock) SetRun(kind string) {
 	m.mtx.Lock()
 	m.IsRunning = true
 	m.Kind = kind
+	m.RunID = newRunID()
+	m.logger = log.Logger.WithFields(logrus.Fields{log.FieldJob: m.Name, log.FieldRunID: m.RunID})
 	defer m.mtx.Unlock()
 }
 
@@ -36,5 +54,20 @@ func (m *JobLock) DoneRun() {
 	m.mtx.Lock()
 	m.IsRunning = false
 	m.Kind = ""
+	m.RunID = ""
+	m.logger = nil
+	defer m.mtx.Unlock()
+}
+
+// Log returns the logger of the current run, it is safe to call on a nil JobLock.
+func (m *JobLock) Log() *logrus.Entry {
+	if m == nil {
+		return logrus.NewEntry(log.Logger)
+	}
+	m.mtx.Lock()
 	defer m.mtx.Unlock()
+	if m.logger == nil {
+		return log.Logger.WithField(log.FieldJob, m.Name)
+	}
+	return m.logger
 }
@@ -2,35 +2,80 @@ package log
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Structured fields attached to log lines.
+const (
+	FieldJob        = "job"
+	FieldRunID      = "run_id"
+	FieldInstanceID = "instance_id"
+	FieldRegion     = "region"
+	FieldAPI        = "api"
+	FieldRequestID  = "request_id"
 )
 
 var Logger *logrus.Logger
 
-func InitLogger(logLevel string, logFile string) {
+// Rotation limits the log file, a zero MaxSize disables rotation and the file is only appended to.
+type Rotation struct {
+	MaxSize    int
+	MaxAge     int
+	MaxBackups int
+}
+
+func InitLogger(logLevel string, logFile string, logFormat string, rotation Rotation) {
 	level, err := logrus.ParseLevel(logLevel)
 	if err != nil {
 		fmt.Println("Can't parse log level:", err)
 		os.Exit(1)
 	}
-	Logger = &logrus.Logger{
-		Level: level,
-		Formatter: &logrus.TextFormatter{
+	var formatter logrus.Formatter
+	switch logFormat {
+	case "", "text":
+		formatter = &logrus.TextFormatter{
 			DisableColors:   false,
 			FullTimestamp:   true,
 			TimestampFormat: "2006-01-02 15:04:05",
-		},
+		}
+	case "json":
+		formatter = &logrus.JSONFormatter{
+			TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
+		}
+	default:
+		fmt.Println("Can't parse log format:", logFormat)
+		os.Exit(1)
+	}
+	Logger = &logrus.Logger{
+		Level:     level,
+		Formatter: formatter,
+		Hooks:     make(logrus.LevelHooks),
 	}
 	Logger.SetLevel(level)
 	Logger.Out = os.Stdout
 	if logFile != "" {
-		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-		if err == nil {
-			Logger.Out = file
+		var out io.Writer
+		if rotation.MaxSize > 0 {
+			out = &lumberjack.Logger{
+				Filename:   logFile,
+				MaxSize:    rotation.MaxSize,
+				MaxAge:     rotation.MaxAge,
+				MaxBackups: rotation.MaxBackups,
+			}
 		} else {
-			Logger.Errorf("Unable log to file: %s, using default stdout", logFile)
+			file, err := os.OpenFile(logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				Logger.Errorf("Unable log to file: %s, using default stdout", logFile)
+			} else {
+				out = file
+			}
+		}
+		if out != nil {
+			Logger.Out = out
 		}
 	}
 	Logger.Debug("log level set to: ", logLevel)
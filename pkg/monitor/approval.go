@@ -0,0 +1,35 @@
+package monitor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type ApprovalMonitor struct {
+	BlockedRuns    *prometheus.CounterVec
+	PendingChanges *prometheus.GaugeVec
+}
+
+func NewApprovalMonitor(reg prometheus.Registerer) *ApprovalMonitor {
+	BlockedRuns := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "blockedruns",
+			Help: "Number of runs blocked for changing more resources than allowed without approval.",
+		},
+		[]string{"jobname"},
+	)
+	PendingChanges := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pendingchanges",
+			Help: "Number of resource changes of the last blocked run waiting for approval, 0 once a run is not blocked.",
+		},
+		[]string{"jobname"},
+	)
+
+	reg.MustRegister(BlockedRuns)
+	reg.MustRegister(PendingChanges)
+
+	return &ApprovalMonitor{
+		BlockedRuns:    BlockedRuns,
+		PendingChanges: PendingChanges,
+	}
+}
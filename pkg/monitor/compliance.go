@@ -0,0 +1,58 @@
+package monitor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type ComplianceMonitor struct {
+	Score            *prometheus.GaugeVec
+	ScoreSeries      *SeriesTracker
+	Ratio            *prometheus.GaugeVec
+	RatioSeries      *SeriesTracker
+	Violations       *prometheus.GaugeVec
+	ViolationsSeries *SeriesTracker
+}
+
+func NewComplianceMonitor(reg prometheus.Registerer) *ComplianceMonitor {
+	Score := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tagcompliancescore",
+			Help: "Share of required tag rules satisfied by ecs instance.",
+		},
+		[]string{"id", "name", "vpcid", "group"},
+	)
+	Ratio := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tagcomplianceratio",
+			Help: "Share of ecs instances satisfying all required tag rules per group and vpc.",
+		},
+		[]string{"group", "vpcid"},
+	)
+	Violations := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tagcomplianceviolations",
+			Help: "Number of ecs instances violating a required tag rule.",
+		},
+		[]string{"key", "reason"},
+	)
+
+	ScoreSeries := NewSeriesTracker("tagcompliancescore", Score, false)
+	RatioSeries := NewSeriesTracker("tagcomplianceratio", Ratio, false)
+	ViolationsSeries := NewSeriesTracker("tagcomplianceviolations", Violations, true)
+
+	reg.MustRegister(Score)
+	reg.MustRegister(ScoreSeries)
+	reg.MustRegister(Ratio)
+	reg.MustRegister(RatioSeries)
+	reg.MustRegister(Violations)
+	reg.MustRegister(ViolationsSeries)
+
+	return &ComplianceMonitor{
+		Score:            Score,
+		ScoreSeries:      ScoreSeries,
+		Ratio:            Ratio,
+		RatioSeries:      RatioSeries,
+		Violations:       Violations,
+		ViolationsSeries: ViolationsSeries,
+	}
+}
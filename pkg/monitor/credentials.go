@@ -0,0 +1,46 @@
+package monitor
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type CredentialMonitor struct {
+	TokenExpiry     prometheus.Gauge
+	RefreshFailures prometheus.Counter
+}
+
+func NewCredentialMonitor(reg prometheus.Registerer) *CredentialMonitor {
+	TokenExpiry := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ststokenexpiry",
+			Help: "Expiration time of the sts token as unix timestamp.",
+		},
+	)
+	RefreshFailures := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ststokenrefreshfailures",
+			Help: "Number of failed sts token refreshes.",
+		},
+	)
+
+	reg.MustRegister(TokenExpiry)
+	reg.MustRegister(RefreshFailures)
+
+	return &CredentialMonitor{
+		TokenExpiry:     TokenExpiry,
+		RefreshFailures: RefreshFailures,
+	}
+}
+
+// Observe records the result of a refresh, readiness fails once a token expired without being replaced.
+func (m *CredentialMonitor) Observe(expire time.Time, err error) {
+	if err != nil {
+		m.RefreshFailures.Inc()
+		SetCredentialsValid(time.Now().Before(expire))
+		return
+	}
+	m.TokenExpiry.Set(float64(expire.Unix()))
+	SetCredentialsValid(true)
+}
@@ -0,0 +1,44 @@
+package monitor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type DriftMonitor struct {
+	Managed        *prometheus.GaugeVec
+	ManagedSeries  *SeriesTracker
+	TagDrift       *prometheus.GaugeVec
+	TagDriftSeries *SeriesTracker
+}
+
+func NewDriftMonitor(reg prometheus.Registerer) *DriftMonitor {
+	Managed := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "iacmanaged",
+			Help: "Ecs instance declared in terraform state.",
+		},
+		[]string{"id", "name", "address"},
+	)
+	TagDrift := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "iactagdrift",
+			Help: "Tag of ecs instance differing from terraform state, direction is missing, unexpected or changed.",
+		},
+		[]string{"id", "name", "address", "key", "direction"},
+	)
+
+	ManagedSeries := NewSeriesTracker("iacmanaged", Managed, true)
+	TagDriftSeries := NewSeriesTracker("iactagdrift", TagDrift, true)
+
+	reg.MustRegister(Managed)
+	reg.MustRegister(ManagedSeries)
+	reg.MustRegister(TagDrift)
+	reg.MustRegister(TagDriftSeries)
+
+	return &DriftMonitor{
+		Managed:        Managed,
+		ManagedSeries:  ManagedSeries,
+		TagDrift:       TagDrift,
+		TagDriftSeries: TagDriftSeries,
+	}
+}
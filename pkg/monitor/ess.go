@@ -0,0 +1,86 @@
+package monitor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type EssMonitor struct {
+	GroupCapacity       *prometheus.GaugeVec
+	Configuration       *prometheus.GaugeVec
+	Instances           *prometheus.GaugeVec
+	FailedActivities    *prometheus.GaugeVec
+	SpotFallback        *prometheus.GaugeVec
+	GroupCapacitySeries *SeriesTracker
+	ConfigurationSeries *SeriesTracker
+	InstancesSeries     *SeriesTracker
+	FailedSeries        *SeriesTracker
+	SpotFallbackSeries  *SeriesTracker
+}
+
+func NewEssMonitor(reg prometheus.Registerer) *EssMonitor {
+	GroupCapacity := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "essgroupcapacity",
+			Help: "Capacity of scaling group, capacity is desired, total, active, pending, removing, standby, stopped, min or max.",
+		},
+		[]string{"id", "name", "capacity"},
+	)
+	Configuration := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "essconfiguration",
+			Help: "Scaling configuration of scaling group, 1 for the active configuration.",
+		},
+		[]string{"groupid", "id", "name", "type", "spotstrategy"},
+	)
+	Instances := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "essinstances",
+			Help: "Number of instances in scaling group by health and lifecycle state.",
+		},
+		[]string{"id", "name", "health", "lifecycle"},
+	)
+	FailedActivities := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "essfailedactivities",
+			Help: "Number of failed activities among the latest scaling activities of scaling group.",
+		},
+		[]string{"id", "name"},
+	)
+	SpotFallback := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "essspotfallback",
+			Help: "Number of pay-as-you-go instances created from a spot scaling configuration.",
+		},
+		[]string{"id", "name"},
+	)
+
+	GroupCapacitySeries := NewSeriesTracker("essgroupcapacity", GroupCapacity, false)
+	ConfigurationSeries := NewSeriesTracker("essconfiguration", Configuration, false)
+	InstancesSeries := NewSeriesTracker("essinstances", Instances, true)
+	FailedSeries := NewSeriesTracker("essfailedactivities", FailedActivities, false)
+	SpotFallbackSeries := NewSeriesTracker("essspotfallback", SpotFallback, false)
+
+	reg.MustRegister(GroupCapacity)
+	reg.MustRegister(Configuration)
+	reg.MustRegister(Instances)
+	reg.MustRegister(FailedActivities)
+	reg.MustRegister(SpotFallback)
+	reg.MustRegister(GroupCapacitySeries)
+	reg.MustRegister(ConfigurationSeries)
+	reg.MustRegister(InstancesSeries)
+	reg.MustRegister(FailedSeries)
+	reg.MustRegister(SpotFallbackSeries)
+
+	return &EssMonitor{
+		GroupCapacity:       GroupCapacity,
+		Configuration:       Configuration,
+		Instances:           Instances,
+		FailedActivities:    FailedActivities,
+		SpotFallback:        SpotFallback,
+		GroupCapacitySeries: GroupCapacitySeries,
+		ConfigurationSeries: ConfigurationSeries,
+		InstancesSeries:     InstancesSeries,
+		FailedSeries:        FailedSeries,
+		SpotFallbackSeries:  SpotFallbackSeries,
+	}
+}
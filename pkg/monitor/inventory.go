@@ -0,0 +1,34 @@
+package monitor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type InventoryMonitor struct {
+	Events    *prometheus.CounterVec
+	Instances prometheus.Gauge
+}
+
+func NewInventoryMonitor(reg prometheus.Registerer) *InventoryMonitor {
+	Events := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ecsinventoryevents",
+			Help: "Change events detected between ecs inventory snapshots.",
+		},
+		[]string{"event", "field"},
+	)
+	Instances := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ecsinventoryinstances",
+			Help: "Number of ecs instances in the latest inventory snapshot.",
+		},
+	)
+
+	reg.MustRegister(Events)
+	reg.MustRegister(Instances)
+
+	return &InventoryMonitor{
+		Events:    Events,
+		Instances: Instances,
+	}
+}
@@ -3,16 +3,45 @@ package monitor
 import (
 	"net/http"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func PrometheusBoot() error {
-	http.Handle("/metrics", promhttp.Handler())
-	return func() error {
-		err := http.ListenAndServe("0.0.0.0:9085", nil)
+// NewRegistry returns a dedicated registry for one process, with the go runtime and process
+// collectors only when runtimeMetrics is set.
+func NewRegistry(runtimeMetrics bool) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	if runtimeMetrics {
+		reg.MustRegister(prometheus.NewGoCollector())
+		reg.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	}
+	return reg
+}
+
+// PrometheusBoot serves the metrics of gatherer on /metrics, plus /healthz and /readyz, on listenAddress, with tls
+// and basic auth taken from webConfigFile when set. The health endpoints are never behind basic auth so probes keep working.
+func PrometheusBoot(gatherer prometheus.Gatherer, listenAddress string, webConfigFile string) error {
+	webConfig, err := LoadWebConfig(webConfigFile)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", webConfig.basicAuth(promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})))
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	server := &http.Server{
+		Addr:    listenAddress,
+		Handler: mux,
+	}
+
+	if webConfig.tlsEnabled() {
+		tlsConfig, err := webConfig.serverTLSConfig()
 		if err != nil {
 			return err
 		}
-		return nil
-	}()
+		server.TLSConfig = tlsConfig
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
 }
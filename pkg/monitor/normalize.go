@@ -0,0 +1,44 @@
+package monitor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type NormalizeMonitor struct {
+	NonCanonicalTag       *prometheus.GaugeVec
+	NonCanonicalTagSeries *SeriesTracker
+	TagConflict           *prometheus.GaugeVec
+	TagConflictSeries     *SeriesTracker
+}
+
+func NewNormalizeMonitor(reg prometheus.Registerer) *NormalizeMonitor {
+	NonCanonicalTag := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "noncanonicaltag",
+			Help: "Tag of ecs instance with a key alias or a value synonym instead of the canonical form.",
+		},
+		[]string{"id", "name", "key"},
+	)
+	TagConflict := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tagconflict",
+			Help: "Aliases of a tag key with different values on ecs instance, not normalized.",
+		},
+		[]string{"id", "name", "key"},
+	)
+
+	NonCanonicalTagSeries := NewSeriesTracker("noncanonicaltag", NonCanonicalTag, true)
+	TagConflictSeries := NewSeriesTracker("tagconflict", TagConflict, true)
+
+	reg.MustRegister(NonCanonicalTag)
+	reg.MustRegister(NonCanonicalTagSeries)
+	reg.MustRegister(TagConflict)
+	reg.MustRegister(TagConflictSeries)
+
+	return &NormalizeMonitor{
+		NonCanonicalTag:       NonCanonicalTag,
+		NonCanonicalTagSeries: NonCanonicalTagSeries,
+		TagConflict:           TagConflict,
+		TagConflictSeries:     TagConflictSeries,
+	}
+}
@@ -0,0 +1,45 @@
+package monitor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type OperatorMonitor struct {
+	PolicyMatched *prometheus.GaugeVec
+	PolicyChanged *prometheus.GaugeVec
+	PolicyErrors  *prometheus.CounterVec
+}
+
+func NewOperatorMonitor(reg prometheus.Registerer) *OperatorMonitor {
+	PolicyMatched := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tagpolicymatched",
+			Help: "Number of ecs instances selected by the tag policy in the last reconcile.",
+		},
+		[]string{"namespace", "name"},
+	)
+	PolicyChanged := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tagpolicychanged",
+			Help: "Number of ecs instances whose tags were changed by the last reconcile of the tag policy.",
+		},
+		[]string{"namespace", "name"},
+	)
+	PolicyErrors := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tagpolicyerrors",
+			Help: "Number of failed reconciles of the tag policy.",
+		},
+		[]string{"namespace", "name"},
+	)
+
+	reg.MustRegister(PolicyMatched)
+	reg.MustRegister(PolicyChanged)
+	reg.MustRegister(PolicyErrors)
+
+	return &OperatorMonitor{
+		PolicyMatched: PolicyMatched,
+		PolicyChanged: PolicyChanged,
+		PolicyErrors:  PolicyErrors,
+	}
+}
@@ -0,0 +1,45 @@
+package monitor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type QuotaMonitor struct {
+	QuotaLimit       *prometheus.GaugeVec
+	QuotaUsed        *prometheus.GaugeVec
+	QuotaUtilization *prometheus.GaugeVec
+}
+
+func NewQuotaMonitor(reg prometheus.Registerer) *QuotaMonitor {
+	QuotaLimit := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "quotalimit",
+			Help: "Limit of ecs account quota.",
+		},
+		[]string{"region", "quota"},
+	)
+	QuotaUsed := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "quotaused",
+			Help: "Usage of ecs account quota derived from the instance inventory.",
+		},
+		[]string{"region", "quota"},
+	)
+	QuotaUtilization := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "quotautilization",
+			Help: "Ratio of usage to limit of ecs account quota.",
+		},
+		[]string{"region", "quota"},
+	)
+
+	reg.MustRegister(QuotaLimit)
+	reg.MustRegister(QuotaUsed)
+	reg.MustRegister(QuotaUtilization)
+
+	return &QuotaMonitor{
+		QuotaLimit:       QuotaLimit,
+		QuotaUsed:        QuotaUsed,
+		QuotaUtilization: QuotaUtilization,
+	}
+}
@@ -0,0 +1,38 @@
+package monitor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type SecGroupMonitor struct {
+	SecGroups       prometheus.Gauge
+	SecGroupFinding *prometheus.GaugeVec
+	FindingSeries   *SeriesTracker
+}
+
+func NewSecGroupMonitor(reg prometheus.Registerer) *SecGroupMonitor {
+	SecGroups := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "secgroups",
+			Help: "Number of security groups audited.",
+		},
+	)
+	SecGroupFinding := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "secgroupfinding",
+			Help: "Risky security group configuration found by the audit.",
+		},
+		[]string{"id", "name", "vpc", "finding", "port"},
+	)
+	FindingSeries := NewSeriesTracker("secgroupfinding", SecGroupFinding, true)
+
+	reg.MustRegister(SecGroups)
+	reg.MustRegister(SecGroupFinding)
+	reg.MustRegister(FindingSeries)
+
+	return &SecGroupMonitor{
+		SecGroups:       SecGroups,
+		SecGroupFinding: SecGroupFinding,
+		FindingSeries:   FindingSeries,
+	}
+}
@@ -0,0 +1,127 @@
+package monitor
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StaleRuns is the number of consecutive runs a series may be missing before it is deleted.
+var StaleRuns = 3
+
+type trackedSeries struct {
+	labels prometheus.Labels
+	seen   bool
+	missed int
+}
+
+// SeriesTracker manages the lifecycle of the series of a GaugeVec.
+// Every job setting series is an owner, when an owner ends a run the series it did not set in that run
+// are counted as missed and deleted after StaleRuns consecutive misses. With resetMissing the missed
+// series are set to 0 until they are deleted, 0/1 findings need it so a resolved finding reports 0 right away.
+// The tracker is a collector exposing the number of active series of the GaugeVec.
+type SeriesTracker struct {
+	vec          *prometheus.GaugeVec
+	resetMissing bool
+	activeDesc   *prometheus.Desc
+	series       map[string]map[string]*trackedSeries
+	mtx          sync.Mutex
+}
+
+func NewSeriesTracker(name string, vec *prometheus.GaugeVec, resetMissing bool) *SeriesTracker {
+	return &SeriesTracker{
+		vec:          vec,
+		resetMissing: resetMissing,
+		activeDesc: prometheus.NewDesc(
+			"activeseries",
+			"Number of active series of a metric.",
+			nil, prometheus.Labels{"metric": name},
+		),
+		series: map[string]map[string]*trackedSeries{},
+	}
+}
+
+func seriesKey(labels prometheus.Labels) string {
+	keys := []string{}
+	for k, v := range labels {
+		keys = append(keys, k+"="+v)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "\xff")
+}
+
+// Set sets the value of the series and marks it as seen by owner in the current run.
+func (t *SeriesTracker) Set(owner string, labels prometheus.Labels, value float64) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.vec.With(labels).Set(value)
+	ownerSeries, ok := t.series[owner]
+	if !ok {
+		ownerSeries = map[string]*trackedSeries{}
+		t.series[owner] = ownerSeries
+	}
+	key := seriesKey(labels)
+	s, ok := ownerSeries[key]
+	if !ok {
+		s = &trackedSeries{labels: labels}
+		ownerSeries[key] = s
+	}
+	s.seen = true
+	s.missed = 0
+}
+
+// EndRun finishes a successful run of owner, only call it when the run saw everything it should have.
+func (t *SeriesTracker) EndRun(owner string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for key, s := range t.series[owner] {
+		if s.seen {
+			s.seen = false
+			continue
+		}
+		s.missed++
+		if s.missed < StaleRuns {
+			if t.resetMissing {
+				t.vec.With(s.labels).Set(0)
+			}
+			continue
+		}
+		delete(t.series[owner], key)
+		if !t.ownedByOthers(owner, key) {
+			t.vec.Delete(s.labels)
+		}
+	}
+}
+
+func (t *SeriesTracker) ownedByOthers(owner string, key string) bool {
+	for o, ownerSeries := range t.series {
+		if o == owner {
+			continue
+		}
+		if _, ok := ownerSeries[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *SeriesTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.activeDesc
+}
+
+func (t *SeriesTracker) Collect(ch chan<- prometheus.Metric) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	active := map[string]bool{}
+	for _, ownerSeries := range t.series {
+		for key := range ownerSeries {
+			active[key] = true
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(t.activeDesc, prometheus.GaugeValue, float64(len(active)))
+}
@@ -0,0 +1,118 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestTracker(t *testing.T, resetMissing bool) (*SeriesTracker, *prometheus.Registry) {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "finding", Help: "Test finding."}, []string{"id"})
+	tracker := NewSeriesTracker("finding", vec, resetMissing)
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(vec)
+	reg.MustRegister(tracker)
+	return tracker, reg
+}
+
+// expectSeries compares the finding series and the active series count, values maps ids to their value.
+func expectSeries(t *testing.T, reg *prometheus.Registry, active int, values map[string]float64) {
+	t.Helper()
+	expected := fmt.Sprintf(`
+# HELP activeseries Number of active series of a metric.
+# TYPE activeseries gauge
+activeseries{metric="finding"} %d
+`, active)
+	if len(values) > 0 {
+		expected += "# HELP finding Test finding.\n# TYPE finding gauge\n"
+		for _, id := range []string{"a", "b", "c"} {
+			if value, ok := values[id]; ok {
+				expected += fmt.Sprintf("finding{id=%q} %v\n", id, value)
+			}
+		}
+	}
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "activeseries", "finding"); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+func TestSeriesTrackerKeepsMissingValue(t *testing.T) {
+	tracker, reg := newTestTracker(t, false)
+	tracker.Set("job", prometheus.Labels{"id": "a"}, 1)
+	tracker.Set("job", prometheus.Labels{"id": "b"}, 2)
+	tracker.EndRun("job")
+	expectSeries(t, reg, 2, map[string]float64{"a": 1, "b": 2})
+
+	// b keeps its last value until it was missing for StaleRuns runs
+	for run := 1; run < StaleRuns; run++ {
+		tracker.Set("job", prometheus.Labels{"id": "a"}, 1)
+		tracker.EndRun("job")
+		expectSeries(t, reg, 2, map[string]float64{"a": 1, "b": 2})
+	}
+	tracker.Set("job", prometheus.Labels{"id": "a"}, 1)
+	tracker.EndRun("job")
+	expectSeries(t, reg, 1, map[string]float64{"a": 1})
+}
+
+func TestSeriesTrackerResetMissing(t *testing.T) {
+	tracker, reg := newTestTracker(t, true)
+	tracker.Set("job", prometheus.Labels{"id": "a"}, 1)
+	tracker.Set("job", prometheus.Labels{"id": "b"}, 1)
+	tracker.EndRun("job")
+
+	// a resolved finding reports 0 from the next run on until it is deleted
+	for run := 1; run < StaleRuns; run++ {
+		tracker.Set("job", prometheus.Labels{"id": "a"}, 1)
+		tracker.EndRun("job")
+		expectSeries(t, reg, 2, map[string]float64{"a": 1, "b": 0})
+	}
+	tracker.Set("job", prometheus.Labels{"id": "a"}, 1)
+	tracker.EndRun("job")
+	expectSeries(t, reg, 1, map[string]float64{"a": 1})
+}
+
+func TestSeriesTrackerSeenAgain(t *testing.T) {
+	tracker, reg := newTestTracker(t, true)
+	tracker.Set("job", prometheus.Labels{"id": "a"}, 1)
+	tracker.EndRun("job")
+	for run := 1; run < StaleRuns; run++ {
+		tracker.EndRun("job")
+	}
+	// a series set again before it was deleted starts counting its misses from 0
+	tracker.Set("job", prometheus.Labels{"id": "a"}, 1)
+	tracker.EndRun("job")
+	for run := 1; run < StaleRuns; run++ {
+		tracker.EndRun("job")
+	}
+	expectSeries(t, reg, 1, map[string]float64{"a": 0})
+	tracker.EndRun("job")
+	expectSeries(t, reg, 0, nil)
+}
+
+func TestSeriesTrackerOwners(t *testing.T) {
+	tracker, reg := newTestTracker(t, false)
+	tracker.Set("ecs", prometheus.Labels{"id": "a"}, 1)
+	tracker.Set("ecs", prometheus.Labels{"id": "b"}, 1)
+	tracker.Set("updatek8stags", prometheus.Labels{"id": "a"}, 1)
+	tracker.Set("updatek8stags", prometheus.Labels{"id": "c"}, 1)
+	tracker.EndRun("ecs")
+	tracker.EndRun("updatek8stags")
+	expectSeries(t, reg, 3, map[string]float64{"a": 1, "b": 1, "c": 1})
+
+	// runs of one owner never count misses of the series of another owner
+	for run := 0; run < StaleRuns; run++ {
+		tracker.Set("ecs", prometheus.Labels{"id": "b"}, 1)
+		tracker.EndRun("ecs")
+	}
+	expectSeries(t, reg, 3, map[string]float64{"a": 1, "b": 1, "c": 1})
+
+	// a is only deleted when no owner sets it anymore
+	for run := 0; run < StaleRuns; run++ {
+		tracker.Set("updatek8stags", prometheus.Labels{"id": "c"}, 1)
+		tracker.EndRun("updatek8stags")
+	}
+	expectSeries(t, reg, 2, map[string]float64{"b": 1, "c": 1})
+}
@@ -0,0 +1,169 @@
+package monitor
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// WebConfig follows the prometheus exporter-toolkit web config file format.
+type WebConfig struct {
+	TLSConfig      TLSConfig         `yaml:"tls_server_config"`
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+}
+
+type TLSConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientAuthType string `yaml:"client_auth_type"`
+	ClientCAFile   string `yaml:"client_ca_file"`
+	MinVersion     string `yaml:"min_version"`
+}
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+type health struct {
+	credentialsValid bool
+	jobErrors        map[string]error
+	mtx              sync.RWMutex
+}
+
+var jobHealth = &health{jobErrors: map[string]error{}}
+
+// SetCredentialsValid records whether the cloud credentials could be loaded and used.
+func SetCredentialsValid(valid bool) {
+	jobHealth.mtx.Lock()
+	defer jobHealth.mtx.Unlock()
+	jobHealth.credentialsValid = valid
+}
+
+// SetJobResult records the outcome of the last run of a job for the readiness endpoint.
+func SetJobResult(name string, err error) {
+	jobHealth.mtx.Lock()
+	defer jobHealth.mtx.Unlock()
+	jobHealth.jobErrors[name] = err
+}
+
+func ready() error {
+	jobHealth.mtx.RLock()
+	defer jobHealth.mtx.RUnlock()
+	if !jobHealth.credentialsValid {
+		return fmt.Errorf("credentials are not valid")
+	}
+	for name, err := range jobHealth.jobErrors {
+		if err != nil {
+			return fmt.Errorf("last run of %s failed: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if err := ready(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+func LoadWebConfig(webConfigFile string) (*WebConfig, error) {
+	webConfig := &WebConfig{}
+	if webConfigFile == "" {
+		return webConfig, nil
+	}
+	data, err := ioutil.ReadFile(webConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read web config %s: %v", webConfigFile, err)
+	}
+	if err := yaml.UnmarshalStrict(data, webConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse web config %s: %v", webConfigFile, err)
+	}
+	return webConfig, nil
+}
+
+func (c *WebConfig) tlsEnabled() bool {
+	return c.TLSConfig.CertFile != "" || c.TLSConfig.KeyFile != ""
+}
+
+func (c *WebConfig) serverTLSConfig() (*tls.Config, error) {
+	if c.TLSConfig.CertFile == "" || c.TLSConfig.KeyFile == "" {
+		return nil, fmt.Errorf("both cert_file and key_file are required for tls")
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLSConfig.CertFile, c.TLSConfig.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tls certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.TLSConfig.MinVersion != "" {
+		version, ok := tlsVersions[c.TLSConfig.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown tls min_version: %s", c.TLSConfig.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+	clientAuth, ok := clientAuthTypes[c.TLSConfig.ClientAuthType]
+	if !ok {
+		return nil, fmt.Errorf("unknown tls client_auth_type: %s", c.TLSConfig.ClientAuthType)
+	}
+	tlsConfig.ClientAuth = clientAuth
+	if c.TLSConfig.ClientCAFile != "" {
+		data, err := ioutil.ReadFile(c.TLSConfig.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client_ca_file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in client_ca_file %s", c.TLSConfig.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// basicAuth protects handler with the bcrypt hashed users of the web config.
+func (c *WebConfig) basicAuth(handler http.Handler) http.Handler {
+	if len(c.BasicAuthUsers) == 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if ok {
+			if hash, found := c.BasicAuthUsers[user]; found && bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil {
+				handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", "Basic")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
@@ -8,9 +8,13 @@ type SpotMonitor struct {
 	SpotPriceWatchdog *prometheus.GaugeVec
 	SpotPrice         *prometheus.GaugeVec
 	ListPrice         *prometheus.GaugeVec
+	SpotPriceSeries   *SeriesTracker
+	ListPriceSeries   *SeriesTracker
+	DiscountSeries    *SeriesTracker
+	AlertSeries       *SeriesTracker
 }
 
-func NewSpotMonitor() *SpotMonitor {
+func NewSpotMonitor(reg prometheus.Registerer) *SpotMonitor {
 	SpotPriceWatchdog := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "spotpriceatchdog",
@@ -34,13 +38,44 @@ func NewSpotMonitor() *SpotMonitor {
 		[]string{"zoneid", "type"},
 	)
 
-	prometheus.MustRegister(SpotPriceWatchdog)
-	prometheus.MustRegister(SpotPrice)
-	prometheus.MustRegister(ListPrice)
+	Discount := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "spotpricediscount",
+			Help: "Discount of the spot price from the list price in percent.",
+		},
+		[]string{"zoneid", "type"},
+	)
+
+	Alert := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "spotpricealert",
+			Help: "State of the spot price alert of the condition (discount or price), 0 ok, 1 pending, 2 firing.",
+		},
+		[]string{"zoneid", "type", "condition"},
+	)
+
+	SpotPriceSeries := NewSeriesTracker("ecsspotprice", SpotPrice, false)
+	ListPriceSeries := NewSeriesTracker("ecslistprice", ListPrice, false)
+	DiscountSeries := NewSeriesTracker("spotpricediscount", Discount, false)
+	AlertSeries := NewSeriesTracker("spotpricealert", Alert, true)
+
+	reg.MustRegister(SpotPriceWatchdog)
+	reg.MustRegister(SpotPrice)
+	reg.MustRegister(ListPrice)
+	reg.MustRegister(SpotPriceSeries)
+	reg.MustRegister(ListPriceSeries)
+	reg.MustRegister(Discount)
+	reg.MustRegister(Alert)
+	reg.MustRegister(DiscountSeries)
+	reg.MustRegister(AlertSeries)
 
 	return &SpotMonitor{
 		SpotPriceWatchdog: SpotPriceWatchdog,
 		SpotPrice:         SpotPrice,
 		ListPrice:         ListPrice,
+		SpotPriceSeries:   SpotPriceSeries,
+		ListPriceSeries:   ListPriceSeries,
+		DiscountSeries:    DiscountSeries,
+		AlertSeries:       AlertSeries,
 	}
 }
@@ -0,0 +1,58 @@
+package monitor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type SpotBidMonitor struct {
+	PriceLimit        *prometheus.GaugeVec
+	PriceLimitSeries  *SeriesTracker
+	MarketPrice       *prometheus.GaugeVec
+	MarketPriceSeries *SeriesTracker
+	Headroom          *prometheus.GaugeVec
+	HeadroomSeries    *SeriesTracker
+}
+
+func NewSpotBidMonitor(reg prometheus.Registerer) *SpotBidMonitor {
+	PriceLimit := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "spotpricelimit",
+			Help: "Price limit of running spot instance launched with SpotWithPriceLimit.",
+		},
+		[]string{"id", "name", "type", "zoneid"},
+	)
+	MarketPrice := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "spotmarketprice",
+			Help: "Current spot price of the instance type in the zone of the spot instance.",
+		},
+		[]string{"id", "name", "type", "zoneid"},
+	)
+	Headroom := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "spotbidheadroom",
+			Help: "How far the spot price is below the price limit of the spot instance in percent of the limit.",
+		},
+		[]string{"id", "name", "type", "zoneid"},
+	)
+
+	PriceLimitSeries := NewSeriesTracker("spotpricelimit", PriceLimit, false)
+	MarketPriceSeries := NewSeriesTracker("spotmarketprice", MarketPrice, false)
+	HeadroomSeries := NewSeriesTracker("spotbidheadroom", Headroom, false)
+
+	reg.MustRegister(PriceLimit)
+	reg.MustRegister(PriceLimitSeries)
+	reg.MustRegister(MarketPrice)
+	reg.MustRegister(MarketPriceSeries)
+	reg.MustRegister(Headroom)
+	reg.MustRegister(HeadroomSeries)
+
+	return &SpotBidMonitor{
+		PriceLimit:        PriceLimit,
+		PriceLimitSeries:  PriceLimitSeries,
+		MarketPrice:       MarketPrice,
+		MarketPriceSeries: MarketPriceSeries,
+		Headroom:          Headroom,
+		HeadroomSeries:    HeadroomSeries,
+	}
+}
@@ -0,0 +1,30 @@
+package monitor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type StockMonitor struct {
+	Stock       *prometheus.GaugeVec
+	StockSeries *SeriesTracker
+}
+
+func NewStockMonitor(reg prometheus.Registerer) *StockMonitor {
+	Stock := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ecsstock",
+			Help: "Stock status of ecs instance type, 1 for the current status.",
+		},
+		[]string{"zoneid", "type", "chargetype", "status"},
+	)
+
+	StockSeries := NewSeriesTracker("ecsstock", Stock, false)
+
+	reg.MustRegister(Stock)
+	reg.MustRegister(StockSeries)
+
+	return &StockMonitor{
+		Stock:       Stock,
+		StockSeries: StockSeries,
+	}
+}
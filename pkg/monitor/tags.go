@@ -5,11 +5,14 @@ import (
 )
 
 type TagsMonitor struct {
-	NoEnvTagWatchdog *prometheus.GaugeVec
-	NoEnvTag         *prometheus.GaugeVec
+	NoEnvTagWatchdog    *prometheus.GaugeVec
+	NoEnvTag            *prometheus.GaugeVec
+	NoEnvTagSeries      *SeriesTracker
+	UnresolvedEnv       *prometheus.GaugeVec
+	UnresolvedEnvSeries *SeriesTracker
 }
 
-func NewTagsMonitor() *TagsMonitor {
+func NewTagsMonitor(reg prometheus.Registerer) *TagsMonitor {
 	NoEnvTagWatchdog := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "notagwatchdog",
@@ -25,11 +28,28 @@ func NewTagsMonitor() *TagsMonitor {
 		[]string{"id", "vpc", "name"},
 	)
 
-	prometheus.MustRegister(NoEnvTagWatchdog)
-	prometheus.MustRegister(NoEnvTag)
+	UnresolvedEnv := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "unresolvedenvironment",
+			Help: "Environment of ecs instance could not be resolved from its vpc.",
+		},
+		[]string{"id", "vpcid", "name"},
+	)
+
+	NoEnvTagSeries := NewSeriesTracker("notag", NoEnvTag, true)
+	UnresolvedEnvSeries := NewSeriesTracker("unresolvedenvironment", UnresolvedEnv, true)
+
+	reg.MustRegister(NoEnvTagWatchdog)
+	reg.MustRegister(NoEnvTag)
+	reg.MustRegister(NoEnvTagSeries)
+	reg.MustRegister(UnresolvedEnv)
+	reg.MustRegister(UnresolvedEnvSeries)
 
 	return &TagsMonitor{
-		NoEnvTagWatchdog: NoEnvTagWatchdog,
-		NoEnvTag:         NoEnvTag,
+		NoEnvTagWatchdog:    NoEnvTagWatchdog,
+		NoEnvTag:            NoEnvTag,
+		NoEnvTagSeries:      NoEnvTagSeries,
+		UnresolvedEnv:       UnresolvedEnv,
+		UnresolvedEnvSeries: UnresolvedEnvSeries,
 	}
 }
@@ -0,0 +1,91 @@
+package monitor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	VSwitchLabels = []string{"id", "name", "vpc", "vpcname", "zoneid", "cidr"}
+	ZoneLabels    = []string{"vpc", "vpcname", "zoneid"}
+)
+
+type VpcMonitor struct {
+	VSwitchAvailableIps    *prometheus.GaugeVec
+	VSwitchTotalIps        *prometheus.GaugeVec
+	VSwitchUtilization     *prometheus.GaugeVec
+	ZoneAvailableIps       *prometheus.GaugeVec
+	ZoneUtilization        *prometheus.GaugeVec
+	VSwitchAvailableSeries *SeriesTracker
+	VSwitchTotalSeries     *SeriesTracker
+	VSwitchUtilSeries      *SeriesTracker
+	ZoneAvailableSeries    *SeriesTracker
+	ZoneUtilSeries         *SeriesTracker
+}
+
+func NewVpcMonitor(reg prometheus.Registerer) *VpcMonitor {
+	VSwitchAvailableIps := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "vswitchavailableips",
+			Help: "Number of available ip addresses in vswitch.",
+		},
+		VSwitchLabels,
+	)
+	VSwitchTotalIps := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "vswitchtotalips",
+			Help: "Number of ip addresses in the cidr block of vswitch.",
+		},
+		VSwitchLabels,
+	)
+	VSwitchUtilization := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "vswitchutilization",
+			Help: "Ratio of used to usable ip addresses in vswitch.",
+		},
+		VSwitchLabels,
+	)
+	ZoneAvailableIps := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "vswitchzoneavailableips",
+			Help: "Number of available ip addresses in all vswitches of a vpc zone.",
+		},
+		ZoneLabels,
+	)
+	ZoneUtilization := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "vswitchzoneutilization",
+			Help: "Ratio of used to usable ip addresses in all vswitches of a vpc zone.",
+		},
+		ZoneLabels,
+	)
+
+	VSwitchAvailableSeries := NewSeriesTracker("vswitchavailableips", VSwitchAvailableIps, false)
+	VSwitchTotalSeries := NewSeriesTracker("vswitchtotalips", VSwitchTotalIps, false)
+	VSwitchUtilSeries := NewSeriesTracker("vswitchutilization", VSwitchUtilization, false)
+	ZoneAvailableSeries := NewSeriesTracker("vswitchzoneavailableips", ZoneAvailableIps, false)
+	ZoneUtilSeries := NewSeriesTracker("vswitchzoneutilization", ZoneUtilization, false)
+
+	reg.MustRegister(VSwitchAvailableIps)
+	reg.MustRegister(VSwitchTotalIps)
+	reg.MustRegister(VSwitchUtilization)
+	reg.MustRegister(ZoneAvailableIps)
+	reg.MustRegister(ZoneUtilization)
+	reg.MustRegister(VSwitchAvailableSeries)
+	reg.MustRegister(VSwitchTotalSeries)
+	reg.MustRegister(VSwitchUtilSeries)
+	reg.MustRegister(ZoneAvailableSeries)
+	reg.MustRegister(ZoneUtilSeries)
+
+	return &VpcMonitor{
+		VSwitchAvailableIps:    VSwitchAvailableIps,
+		VSwitchTotalIps:        VSwitchTotalIps,
+		VSwitchUtilization:     VSwitchUtilization,
+		ZoneAvailableIps:       ZoneAvailableIps,
+		ZoneUtilization:        ZoneUtilization,
+		VSwitchAvailableSeries: VSwitchAvailableSeries,
+		VSwitchTotalSeries:     VSwitchTotalSeries,
+		VSwitchUtilSeries:      VSwitchUtilSeries,
+		ZoneAvailableSeries:    ZoneAvailableSeries,
+		ZoneUtilSeries:         ZoneUtilSeries,
+	}
+}
@@ -0,0 +1,146 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+)
+
+const DefaultTemplate = `[{{ .Kind }}] {{ .Title }}
+{{- range .Lines }}
+- {{ . }}
+{{- end }}`
+
+// Message is a notification before rendering, Key is used for de-duplication.
+type Message struct {
+	Key   string
+	Kind  string
+	Title string
+	Lines []string
+}
+
+// Sink delivers a rendered notification to one destination.
+type Sink interface {
+	Name() string
+	Send(title string, text string) error
+}
+
+type Config struct {
+	Template    string           `mapstructure:"template"`
+	DedupWindow time.Duration    `mapstructure:"dedupWindow"`
+	RateLimit   int              `mapstructure:"rateLimit"`
+	Webhooks    []string         `mapstructure:"webhooks"`
+	Slack       []string         `mapstructure:"slack"`
+	DingTalk    []DingTalkConfig `mapstructure:"dingtalk"`
+	Email       []EmailConfig    `mapstructure:"email"`
+}
+
+// Notifier renders messages and fans them out to all sinks, dropping duplicates seen within DedupWindow
+// and anything beyond RateLimit messages per minute.
+type Notifier struct {
+	sinks       []Sink
+	tmpl        *template.Template
+	dedupWindow time.Duration
+	rateLimit   int
+	sent        map[string]time.Time
+	window      time.Time
+	windowCount int
+	mtx         sync.Mutex
+}
+
+func NewNotifier(cfg Config) (*Notifier, error) {
+	text := cfg.Template
+	if text == "" {
+		text = DefaultTemplate
+	}
+	tmpl, err := template.New("notify").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse notification template: %v", err)
+	}
+	n := &Notifier{
+		tmpl:        tmpl,
+		dedupWindow: cfg.DedupWindow,
+		rateLimit:   cfg.RateLimit,
+		sent:        map[string]time.Time{},
+	}
+	for _, url := range cfg.Webhooks {
+		n.AddSink(NewWebhookSink(url))
+	}
+	for _, url := range cfg.Slack {
+		n.AddSink(NewSlackSink(url))
+	}
+	for _, dingTalkCfg := range cfg.DingTalk {
+		n.AddSink(NewDingTalkSink(dingTalkCfg))
+	}
+	for _, emailCfg := range cfg.Email {
+		n.AddSink(NewEmailSink(emailCfg))
+	}
+	return n, nil
+}
+
+func (n *Notifier) AddSink(sink Sink) {
+	n.sinks = append(n.sinks, sink)
+}
+
+func (n *Notifier) Enabled() bool {
+	return n != nil && len(n.sinks) > 0
+}
+
+// Notify sends msg to every sink. A nil Notifier or one without sinks does nothing.
+func (n *Notifier) Notify(msg Message) error {
+	if !n.Enabled() {
+		return nil
+	}
+	if !n.allow(msg.Key, time.Now()) {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := n.tmpl.Execute(&buf, msg); err != nil {
+		return fmt.Errorf("failed to render notification: %v", err)
+	}
+	var lastErr error
+	for _, sink := range n.sinks {
+		if err := sink.Send(msg.Title, buf.String()); err != nil {
+			log.Logger.Errorf("failed to send notification to %s: %v", sink.Name(), err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (n *Notifier) allow(key string, now time.Time) bool {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	if key != "" && n.dedupWindow > 0 {
+		if last, ok := n.sent[key]; ok && now.Sub(last) < n.dedupWindow {
+			log.Logger.Debugf("notification %s suppressed as duplicate", key)
+			return false
+		}
+		for k, last := range n.sent {
+			if now.Sub(last) >= n.dedupWindow {
+				delete(n.sent, k)
+			}
+		}
+	}
+	if n.rateLimit > 0 {
+		if now.Sub(n.window) >= time.Minute {
+			n.window = now
+			n.windowCount = 0
+		}
+		if n.windowCount >= n.rateLimit {
+			log.Logger.Warnf("notification %s dropped, rate limit of %d per minute reached", key, n.rateLimit)
+			return false
+		}
+		n.windowCount++
+	}
+	if key != "" && n.dedupWindow > 0 {
+		n.sent[key] = now
+	}
+	return true
+}
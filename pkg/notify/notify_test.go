@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+)
+
+func TestMain(m *testing.M) {
+	log.InitLogger("error", "", "text", log.Rotation{})
+	os.Exit(m.Run())
+}
+
+type fakeSink struct {
+	titles []string
+	texts  []string
+}
+
+func (s *fakeSink) Name() string {
+	return "fake"
+}
+
+func (s *fakeSink) Send(title string, text string) error {
+	s.titles = append(s.titles, title)
+	s.texts = append(s.texts, text)
+	return nil
+}
+
+func newTestNotifier(t *testing.T, cfg Config) (*Notifier, *fakeSink) {
+	n, err := NewNotifier(cfg)
+	if err != nil {
+		t.Fatalf("NewNotifier() error = %v", err)
+	}
+	sink := &fakeSink{}
+	n.AddSink(sink)
+	return n, sink
+}
+
+func TestNotifyRender(t *testing.T) {
+	n, sink := newTestNotifier(t, Config{})
+	if err := n.Notify(Message{Kind: "ecs", Title: "2 new instances", Lines: []string{"i-1", "i-2"}}); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	want := "[ecs] 2 new instances\n- i-1\n- i-2"
+	if len(sink.texts) != 1 || sink.texts[0] != want {
+		t.Errorf("texts = %q, want %q", sink.texts, want)
+	}
+}
+
+func TestNotifyDedup(t *testing.T) {
+	n, sink := newTestNotifier(t, Config{DedupWindow: time.Hour})
+	for i := 0; i < 3; i++ {
+		if err := n.Notify(Message{Key: "untagged/i-1", Title: "one"}); err != nil {
+			t.Fatalf("Notify() error = %v", err)
+		}
+	}
+	if err := n.Notify(Message{Key: "untagged/i-2", Title: "two"}); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if len(sink.titles) != 2 {
+		t.Errorf("sent %v, want one message per key", sink.titles)
+	}
+}
+
+func TestNotifyDedupExpires(t *testing.T) {
+	n, _ := newTestNotifier(t, Config{DedupWindow: time.Minute})
+	now := time.Now()
+	if !n.allow("key", now) {
+		t.Fatalf("first message not allowed")
+	}
+	if n.allow("key", now.Add(30*time.Second)) {
+		t.Errorf("duplicate within window allowed")
+	}
+	if !n.allow("key", now.Add(2*time.Minute)) {
+		t.Errorf("message after window not allowed")
+	}
+}
+
+func TestNotifyRateLimit(t *testing.T) {
+	n, _ := newTestNotifier(t, Config{RateLimit: 2})
+	now := time.Now()
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if n.allow("", now) {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("allowed %d messages, want 2", allowed)
+	}
+	if !n.allow("", now.Add(time.Minute)) {
+		t.Errorf("message in next window not allowed")
+	}
+}
+
+func TestNotifyWebhook(t *testing.T) {
+	server, requests := newServer(t, http.StatusOK)
+	n, err := NewNotifier(Config{Webhooks: []string{server.URL}, DedupWindow: time.Hour})
+	if err != nil {
+		t.Fatalf("NewNotifier() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := n.Notify(Message{Key: "k", Kind: "ecs", Title: "title"}); err != nil {
+			t.Fatalf("Notify() error = %v", err)
+		}
+	}
+	if len(*requests) != 1 {
+		t.Errorf("got %d requests, want 1", len(*requests))
+	}
+}
+
+func TestNotifyDisabled(t *testing.T) {
+	var n *Notifier
+	if n.Enabled() {
+		t.Errorf("nil notifier enabled")
+	}
+	if err := n.Notify(Message{Title: "title"}); err != nil {
+		t.Errorf("Notify() on nil notifier error = %v", err)
+	}
+}
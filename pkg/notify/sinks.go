@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+func postJSON(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// WebhookSink posts {"title": ..., "text": ...} to a generic endpoint.
+type WebhookSink struct {
+	URL string
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{URL: url}
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *WebhookSink) Send(title string, text string) error {
+	return postJSON(s.URL, map[string]string{"title": title, "text": text})
+}
+
+// SlackSink posts to a Slack compatible incoming webhook.
+type SlackSink struct {
+	URL string
+}
+
+func NewSlackSink(url string) *SlackSink {
+	return &SlackSink{URL: url}
+}
+
+func (s *SlackSink) Name() string {
+	return "slack"
+}
+
+func (s *SlackSink) Send(title string, text string) error {
+	return postJSON(s.URL, map[string]string{"text": text})
+}
+
+type DingTalkConfig struct {
+	URL    string `mapstructure:"url"`
+	Secret string `mapstructure:"secret"`
+}
+
+// DingTalkSink posts to a DingTalk robot, signing the request when a secret is configured.
+type DingTalkSink struct {
+	cfg DingTalkConfig
+}
+
+func NewDingTalkSink(cfg DingTalkConfig) *DingTalkSink {
+	return &DingTalkSink{cfg: cfg}
+}
+
+func (s *DingTalkSink) Name() string {
+	return "dingtalk"
+}
+
+func (s *DingTalkSink) Send(title string, text string) error {
+	target := s.cfg.URL
+	if s.cfg.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+		mac := hmac.New(sha256.New, []byte(s.cfg.Secret))
+		mac.Write([]byte(timestamp + "\n" + s.cfg.Secret))
+		sign := url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		sep := "?"
+		if strings.Contains(target, "?") {
+			sep = "&"
+		}
+		target = fmt.Sprintf("%s%stimestamp=%s&sign=%s", target, sep, timestamp, sign)
+	}
+	payload := map[string]interface{}{
+		"msgtype": "text",
+		"text":    map[string]string{"content": text},
+	}
+	return postJSON(target, payload)
+}
+
+type EmailConfig struct {
+	Host     string   `mapstructure:"host"`
+	Port     int      `mapstructure:"port"`
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+	From     string   `mapstructure:"from"`
+	To       []string `mapstructure:"to"`
+}
+
+// EmailSink sends a plain text mail through an SMTP relay.
+type EmailSink struct {
+	cfg EmailConfig
+}
+
+func NewEmailSink(cfg EmailConfig) *EmailSink {
+	if cfg.Port == 0 {
+		cfg.Port = 25
+	}
+	return &EmailSink{cfg: cfg}
+}
+
+func (s *EmailSink) Name() string {
+	return "email"
+}
+
+func (s *EmailSink) Send(title string, text string) error {
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		s.cfg.From, strings.Join(s.cfg.To, ", "), title, text)
+	return smtp.SendMail(fmt.Sprintf("%s:%d", s.cfg.Host, s.cfg.Port), auth, s.cfg.From, s.cfg.To, []byte(msg))
+}
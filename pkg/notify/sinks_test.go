@@ -0,0 +1,115 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+type request struct {
+	query url.Values
+	body  map[string]interface{}
+}
+
+// newServer records the requests posted to it and answers with status.
+func newServer(t *testing.T, status int) (*httptest.Server, *[]request) {
+	requests := []request{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("unexpected method %s", r.Method)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("unexpected content type %s", ct)
+		}
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Fatalf("failed to parse body %s: %v", data, err)
+		}
+		requests = append(requests, request{query: r.URL.Query(), body: body})
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestWebhookSink(t *testing.T) {
+	server, requests := newServer(t, http.StatusOK)
+	if err := NewWebhookSink(server.URL).Send("title", "text"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*requests))
+	}
+	body := (*requests)[0].body
+	if body["title"] != "title" || body["text"] != "text" {
+		t.Errorf("unexpected body %v", body)
+	}
+}
+
+func TestWebhookSinkStatus(t *testing.T) {
+	server, _ := newServer(t, http.StatusInternalServerError)
+	if err := NewWebhookSink(server.URL).Send("title", "text"); err == nil {
+		t.Errorf("Send() error = nil, want error for status 500")
+	}
+}
+
+func TestSlackSink(t *testing.T) {
+	server, requests := newServer(t, http.StatusOK)
+	if err := NewSlackSink(server.URL).Send("title", "text"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	body := (*requests)[0].body
+	if body["text"] != "text" {
+		t.Errorf("unexpected body %v", body)
+	}
+	if _, ok := body["title"]; ok {
+		t.Errorf("slack body should only have text, got %v", body)
+	}
+}
+
+func TestDingTalkSink(t *testing.T) {
+	server, requests := newServer(t, http.StatusOK)
+	if err := NewDingTalkSink(DingTalkConfig{URL: server.URL + "/robot/send?access_token=token"}).Send("title", "text"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	req := (*requests)[0]
+	if req.body["msgtype"] != "text" {
+		t.Errorf("unexpected msgtype %v", req.body["msgtype"])
+	}
+	if text, ok := req.body["text"].(map[string]interface{}); !ok || text["content"] != "text" {
+		t.Errorf("unexpected text %v", req.body["text"])
+	}
+	if _, ok := req.query["sign"]; ok {
+		t.Errorf("request without secret should not be signed")
+	}
+}
+
+func TestDingTalkSinkSigned(t *testing.T) {
+	server, requests := newServer(t, http.StatusOK)
+	secret := "SECtest"
+	if err := NewDingTalkSink(DingTalkConfig{URL: server.URL + "/robot/send?access_token=token", Secret: secret}).Send("title", "text"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	query := (*requests)[0].query
+	if len(query["access_token"]) != 1 || query["access_token"][0] != "token" {
+		t.Errorf("access_token lost, query %v", query)
+	}
+	if len(query["timestamp"]) != 1 || len(query["sign"]) != 1 {
+		t.Fatalf("missing timestamp or sign, query %v", query)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(query["timestamp"][0] + "\n" + secret))
+	if want := base64.StdEncoding.EncodeToString(mac.Sum(nil)); query["sign"][0] != want {
+		t.Errorf("sign = %s, want %s", query["sign"][0], want)
+	}
+}
@@ -0,0 +1,220 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+)
+
+// Result is what a reconcile of a policy found and changed, with DryRun the changes were only logged.
+type Result struct {
+	Matched int
+	Changed int
+	DryRun  bool
+}
+
+// Reconciler applies a policy to ecs, Delete drops what it keeps about a deleted policy.
+type Reconciler interface {
+	Reconcile(policy *TagPolicy) (Result, error)
+	Delete(namespace string, name string)
+}
+
+type entry struct {
+	id         cron.EntryID
+	generation int64
+}
+
+// Operator watches TagPolicy resources, reconciles each policy when it changes and on its schedule,
+// and writes the result to the status of the policy.
+type Operator struct {
+	client     dynamic.Interface
+	namespace  string
+	resync     time.Duration
+	reconciler Reconciler
+	cron       *cron.Cron
+	store      cache.Store
+	entries    map[string]entry
+	running    map[string]bool
+	mtx        sync.Mutex
+}
+
+// New creates an operator watching namespace, all namespaces when it is empty.
+func New(client dynamic.Interface, namespace string, resync time.Duration, reconciler Reconciler) *Operator {
+	return &Operator{
+		client:     client,
+		namespace:  namespace,
+		resync:     resync,
+		reconciler: reconciler,
+		cron:       cron.New(cron.WithSeconds()),
+		entries:    map[string]entry{},
+		running:    map[string]bool{},
+	}
+}
+
+// Run watches policies until stopCh is closed.
+func (o *Operator) Run(stopCh <-chan struct{}) error {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(o.client, o.resync, o.namespace, nil)
+	informer := factory.ForResource(GroupVersionResource).Informer()
+	o.store = informer.GetStore()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: o.onChange,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			o.onChange(newObj)
+		},
+		DeleteFunc: o.onDelete,
+	})
+
+	o.cron.Start()
+	defer o.cron.Stop()
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		return fmt.Errorf("failed to sync %s informer", Resource)
+	}
+	log.Logger.Infof("watching %s in namespace %q", Resource, o.namespace)
+	<-stopCh
+	return nil
+}
+
+// onChange reschedules a policy whose spec changed and reconciles it right away, status updates
+// and resyncs leave the generation unchanged and are ignored. A policy with an invalid schedule is not reconciled.
+func (o *Operator) onChange(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	policy, err := FromUnstructured(u)
+	if err != nil {
+		log.Logger.Errorf("%v", err)
+		return
+	}
+	key := policy.Key()
+
+	o.mtx.Lock()
+	old, ok := o.entries[key]
+	if ok && old.generation == policy.Generation {
+		o.mtx.Unlock()
+		return
+	}
+	if ok && old.id != 0 {
+		o.cron.Remove(old.id)
+	}
+	e := entry{generation: policy.Generation}
+	var scheduleErr error
+	if policy.Spec.Schedule != "" {
+		e.id, scheduleErr = o.cron.AddFunc(policy.Spec.Schedule, func() {
+			o.reconcile(key)
+		})
+	}
+	o.entries[key] = e
+	o.mtx.Unlock()
+
+	if scheduleErr != nil {
+		log.Logger.Errorf("%s %s has an invalid schedule: %v", Kind, key, scheduleErr)
+		o.updateStatus(policy, Result{}, fmt.Errorf("invalid schedule %s: %v", policy.Spec.Schedule, scheduleErr))
+		return
+	}
+	go o.reconcile(key)
+}
+
+func (o *Operator) onDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	key := u.GetNamespace() + "/" + u.GetName()
+
+	o.mtx.Lock()
+	if e, ok := o.entries[key]; ok && e.id != 0 {
+		o.cron.Remove(e.id)
+	}
+	delete(o.entries, key)
+	o.mtx.Unlock()
+	o.reconciler.Delete(u.GetNamespace(), u.GetName())
+	log.Logger.Infof("%s %s deleted", Kind, key)
+}
+
+// reconcile runs the reconciler for the latest version of the policy, a policy still being reconciled is skipped.
+func (o *Operator) reconcile(key string) {
+	obj, exists, err := o.store.GetByKey(key)
+	if err != nil || !exists {
+		return
+	}
+	policy, err := FromUnstructured(obj.(*unstructured.Unstructured))
+	if err != nil {
+		log.Logger.Errorf("%v", err)
+		return
+	}
+
+	o.mtx.Lock()
+	if o.running[key] {
+		o.mtx.Unlock()
+		log.Logger.Warnf("%s %s is still being reconciled", Kind, key)
+		return
+	}
+	o.running[key] = true
+	o.mtx.Unlock()
+	defer func() {
+		o.mtx.Lock()
+		delete(o.running, key)
+		o.mtx.Unlock()
+	}()
+
+	result, err := o.reconciler.Reconcile(policy)
+	if err != nil {
+		log.Logger.Errorf("failed to reconcile %s %s: %v", Kind, key, err)
+	}
+	o.updateStatus(policy, result, err)
+}
+
+func (o *Operator) updateStatus(policy *TagPolicy, result Result, reconcileErr error) {
+	now := metav1.Now()
+	status := policy.Status
+	status.ObservedGeneration = policy.Generation
+	status.MatchedInstances = result.Matched
+	status.ChangedInstances = result.Changed
+	status.LastRun = &now
+	status.Error = ""
+	if reconcileErr != nil {
+		status.Error = reconcileErr.Error()
+	}
+	if result.Changed > 0 && !result.DryRun {
+		status.LastApplied = &now
+	}
+	statusObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		log.Logger.Errorf("failed to convert status of %s %s: %v", Kind, policy.Key(), err)
+		return
+	}
+
+	client := o.client.Resource(GroupVersionResource).Namespace(policy.Namespace)
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		u, err := client.Get(context.TODO(), policy.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedField(u.Object, statusObj, "status"); err != nil {
+			return err
+		}
+		_, err = client.UpdateStatus(context.TODO(), u, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		log.Logger.Errorf("failed to update status of %s %s: %v", Kind, policy.Key(), err)
+	}
+}
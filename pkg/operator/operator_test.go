@@ -0,0 +1,255 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/fake"
+
+	"github.com/allanhung/alicloud-monitoring/pkg/log"
+)
+
+func TestMain(m *testing.M) {
+	log.InitLogger("error", "", "text", log.Rotation{})
+	os.Exit(m.Run())
+}
+
+// stubReconciler counts the reconciles of every policy and returns a fixed result.
+type stubReconciler struct {
+	mtx     sync.Mutex
+	result  Result
+	err     error
+	calls   map[string]int
+	deleted []string
+}
+
+func newStubReconciler(result Result, err error) *stubReconciler {
+	return &stubReconciler{result: result, err: err, calls: map[string]int{}}
+}
+
+func (r *stubReconciler) Reconcile(policy *TagPolicy) (Result, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.calls[policy.Key()]++
+	return r.result, r.err
+}
+
+func (r *stubReconciler) Delete(namespace string, name string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.deleted = append(r.deleted, namespace+"/"+name)
+}
+
+func (r *stubReconciler) Calls(key string) int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.calls[key]
+}
+
+func (r *stubReconciler) Deleted() []string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]string{}, r.deleted...)
+}
+
+func newPolicy(t *testing.T, name string, generation int64, schedule string) *unstructured.Unstructured {
+	policy := &TagPolicy{
+		TypeMeta:   metav1.TypeMeta{APIVersion: Group + "/" + Version, Kind: Kind},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "infra", Name: name, Generation: generation},
+		Spec: TagPolicySpec{
+			Selector: Selector{NamePatterns: []string{"worker-k8s.*"}},
+			Tags:     map[string]string{"stack": "kubernetes"},
+			Schedule: schedule,
+		},
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(policy)
+	if err != nil {
+		t.Fatalf("failed to convert policy: %v", err)
+	}
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func startOperator(t *testing.T, reconciler Reconciler, objects ...runtime.Object) (*Operator, dynamic.ResourceInterface) {
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{GroupVersionResource: Kind + "List"}, objects...)
+	o := New(client, "", 0, reconciler)
+	stopCh := make(chan struct{})
+	go o.Run(stopCh)
+	t.Cleanup(func() { close(stopCh) })
+	return o, client.Resource(GroupVersionResource).Namespace("infra")
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return condition(), nil
+	})
+	if err != nil {
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func getStatus(t *testing.T, client dynamic.ResourceInterface, name string) TagPolicyStatus {
+	u, err := client.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get policy %s: %v", name, err)
+	}
+	policy, err := FromUnstructured(u)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return policy.Status
+}
+
+func TestReconcileWritesStatus(t *testing.T) {
+	reconciler := newStubReconciler(Result{Matched: 3, Changed: 2}, nil)
+	_, client := startOperator(t, reconciler, newPolicy(t, "workers", 1, ""))
+
+	var status TagPolicyStatus
+	waitFor(t, "status write-back", func() bool {
+		status = getStatus(t, client, "workers")
+		return status.LastRun != nil
+	})
+	if status.ObservedGeneration != 1 || status.MatchedInstances != 3 || status.ChangedInstances != 2 || status.Error != "" {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if status.LastApplied == nil {
+		t.Errorf("LastApplied not set after changes were applied")
+	}
+	// the status update leaves the generation unchanged and must not trigger another reconcile
+	time.Sleep(200 * time.Millisecond)
+	if calls := reconciler.Calls("infra/workers"); calls != 1 {
+		t.Errorf("reconciled %d times, want 1", calls)
+	}
+}
+
+func TestReconcileDryRunStatus(t *testing.T) {
+	reconciler := newStubReconciler(Result{Matched: 3, Changed: 2, DryRun: true}, nil)
+	_, client := startOperator(t, reconciler, newPolicy(t, "workers", 1, ""))
+
+	var status TagPolicyStatus
+	waitFor(t, "status write-back", func() bool {
+		status = getStatus(t, client, "workers")
+		return status.LastRun != nil
+	})
+	if status.ChangedInstances != 2 || status.LastApplied != nil {
+		t.Errorf("dry run status %+v, want changed instances without LastApplied", status)
+	}
+}
+
+func TestReconcileErrorStatus(t *testing.T) {
+	reconciler := newStubReconciler(Result{Matched: 3}, fmt.Errorf("4 changes exceed the maximum of 2, waiting for approval"))
+	_, client := startOperator(t, reconciler, newPolicy(t, "workers", 1, ""))
+
+	var status TagPolicyStatus
+	waitFor(t, "status write-back", func() bool {
+		status = getStatus(t, client, "workers")
+		return status.LastRun != nil
+	})
+	if !strings.Contains(status.Error, "waiting for approval") || status.LastApplied != nil {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestReconcileSkipsUnchangedGeneration(t *testing.T) {
+	reconciler := newStubReconciler(Result{Matched: 1, Changed: 1}, nil)
+	_, client := startOperator(t, reconciler, newPolicy(t, "workers", 1, ""))
+	waitFor(t, "first status", func() bool {
+		return getStatus(t, client, "workers").ObservedGeneration == 1
+	})
+
+	// a metadata update keeps the generation
+	u, err := client.Get(context.TODO(), "workers", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	u.SetLabels(map[string]string{"team": "infra"})
+	if _, err := client.Update(context.TODO(), u, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if calls := reconciler.Calls("infra/workers"); calls != 1 {
+		t.Errorf("reconciled %d times after a metadata update, want 1", calls)
+	}
+
+	// a spec update bumps the generation
+	u, err = client.Get(context.TODO(), "workers", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	unstructured.SetNestedField(u.Object, "production", "spec", "tags", "Environment")
+	u.SetGeneration(2)
+	if _, err := client.Update(context.TODO(), u, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	waitFor(t, "second status", func() bool {
+		return getStatus(t, client, "workers").ObservedGeneration == 2
+	})
+	if calls := reconciler.Calls("infra/workers"); calls != 2 {
+		t.Errorf("reconciled %d times after a spec update, want 2", calls)
+	}
+}
+
+func TestReconcileOnSchedule(t *testing.T) {
+	reconciler := newStubReconciler(Result{}, nil)
+	startOperator(t, reconciler, newPolicy(t, "workers", 1, "* * * * * *"))
+
+	waitFor(t, "scheduled reconciles", func() bool {
+		return reconciler.Calls("infra/workers") >= 3
+	})
+}
+
+func TestInvalidSchedule(t *testing.T) {
+	reconciler := newStubReconciler(Result{}, nil)
+	_, client := startOperator(t, reconciler, newPolicy(t, "workers", 1, "every hour"))
+
+	var status TagPolicyStatus
+	waitFor(t, "status write-back", func() bool {
+		status = getStatus(t, client, "workers")
+		return status.LastRun != nil
+	})
+	if !strings.Contains(status.Error, "invalid schedule every hour") {
+		t.Errorf("status error %q, want invalid schedule", status.Error)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if calls := reconciler.Calls("infra/workers"); calls != 0 {
+		t.Errorf("policy with invalid schedule reconciled %d times", calls)
+	}
+}
+
+func TestDeleteStopsSchedule(t *testing.T) {
+	reconciler := newStubReconciler(Result{}, nil)
+	o, client := startOperator(t, reconciler, newPolicy(t, "workers", 1, "* * * * * *"))
+	waitFor(t, "first reconcile", func() bool {
+		return reconciler.Calls("infra/workers") >= 1
+	})
+
+	if err := client.Delete(context.TODO(), "workers", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	waitFor(t, "delete", func() bool {
+		deleted := reconciler.Deleted()
+		return len(deleted) == 1 && deleted[0] == "infra/workers"
+	})
+	o.mtx.Lock()
+	entries := len(o.entries)
+	o.mtx.Unlock()
+	if entries != 0 {
+		t.Errorf("%d schedule entries left after delete", entries)
+	}
+	calls := reconciler.Calls("infra/workers")
+	time.Sleep(1500 * time.Millisecond)
+	if after := reconciler.Calls("infra/workers"); after != calls {
+		t.Errorf("deleted policy reconciled %d more times", after-calls)
+	}
+}
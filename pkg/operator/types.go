@@ -0,0 +1,65 @@
+package operator
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	Group    = "alicloud-monitoring.allanhung.github.io"
+	Version  = "v1alpha1"
+	Kind     = "TagPolicy"
+	Resource = "tagpolicies"
+)
+
+var GroupVersionResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: Resource}
+
+// Selector selects ecs instances, all set fields must match. NamePatterns are regular expressions of which one must match.
+type Selector struct {
+	InstanceIds  []string          `json:"instanceIds,omitempty"`
+	NamePatterns []string          `json:"namePatterns,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+}
+
+type TagPolicySpec struct {
+	Selector Selector          `json:"selector"`
+	Tags     map[string]string `json:"tags"`
+	// Schedule is a cron expression with seconds, without it the policy is only reconciled when it changes.
+	Schedule string `json:"schedule,omitempty"`
+	DryRun   bool   `json:"dryRun,omitempty"`
+}
+
+type TagPolicyStatus struct {
+	ObservedGeneration int64        `json:"observedGeneration,omitempty"`
+	MatchedInstances   int          `json:"matchedInstances"`
+	ChangedInstances   int          `json:"changedInstances"`
+	LastRun            *metav1.Time `json:"lastRun,omitempty"`
+	LastApplied        *metav1.Time `json:"lastApplied,omitempty"`
+	Error              string       `json:"error,omitempty"`
+}
+
+// TagPolicy declares the tags every selected ecs instance must have.
+type TagPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TagPolicySpec   `json:"spec"`
+	Status TagPolicyStatus `json:"status,omitempty"`
+}
+
+// Key is the namespace/name of the policy.
+func (p *TagPolicy) Key() string {
+	return p.Namespace + "/" + p.Name
+}
+
+func FromUnstructured(u *unstructured.Unstructured) (*TagPolicy, error) {
+	p := &TagPolicy{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, p); err != nil {
+		return nil, fmt.Errorf("failed to convert %s %s/%s: %v", Kind, u.GetNamespace(), u.GetName(), err)
+	}
+	return p, nil
+}
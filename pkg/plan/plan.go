@@ -0,0 +1,202 @@
+package plan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	StatusPending = "pending"
+	StatusApplied = "applied"
+)
+
+// Change is the tag change of one instance, Add is set before Remove is removed.
+type Change struct {
+	InstanceId   string            `json:"instanceId"`
+	InstanceName string            `json:"instanceName"`
+	PreviousTags map[string]string `json:"previousTags"`
+	Add          map[string]string `json:"add,omitempty"`
+	Remove       []string          `json:"remove,omitempty"`
+}
+
+// Result returns the tags of the instance after the change, with remove false only Add is applied.
+func (c Change) Result(remove bool) map[string]string {
+	tags := map[string]string{}
+	for k, v := range c.PreviousTags {
+		tags[k] = v
+	}
+	for k, v := range c.Add {
+		tags[k] = v
+	}
+	if remove {
+		for _, k := range c.Remove {
+			delete(tags, k)
+		}
+	}
+	return tags
+}
+
+// String describes the change as -key=value for removed and +key=value for added tags.
+func (c Change) String() string {
+	changes := []string{}
+	for _, k := range c.Remove {
+		changes = append(changes, fmt.Sprintf("-%s=%s", k, c.PreviousTags[k]))
+	}
+	for k, v := range c.Add {
+		changes = append(changes, fmt.Sprintf("+%s=%s", k, v))
+	}
+	sort.Strings(changes)
+	return strings.Join(changes, " ")
+}
+
+// SameChanges reports whether a and b change the same tags of the same instances, in any order.
+func SameChanges(a []Change, b []Change) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	keysA := changeKeys(a)
+	keysB := changeKeys(b)
+	for i := range keysA {
+		if keysA[i] != keysB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func changeKeys(changes []Change) []string {
+	keys := []string{}
+	for _, c := range changes {
+		keys = append(keys, c.InstanceId+" "+c.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Plan holds the changes of a run that was blocked for changing too many resources.
+type Plan struct {
+	Id         string    `json:"id"`
+	Job        string    `json:"job"`
+	Region     string    `json:"region"`
+	Created    time.Time `json:"created"`
+	MaxChanges int       `json:"maxChanges"`
+	Status     string    `json:"status"`
+	Applied    time.Time `json:"applied,omitempty"`
+	Changes    []Change  `json:"changes"`
+}
+
+// New creates a pending plan, the id is derived from job without path separators as it names the plan file.
+func New(job string, region string, maxChanges int, changes []Change) *Plan {
+	created := time.Now()
+	return &Plan{
+		Id:         fmt.Sprintf("%s-%s", strings.Replace(job, "/", "-", -1), created.Format("20060102-150405")),
+		Job:        job,
+		Region:     region,
+		Created:    created,
+		MaxChanges: maxChanges,
+		Status:     StatusPending,
+		Changes:    changes,
+	}
+}
+
+func Load(path string) (*Plan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan %s: %v", path, err)
+	}
+	p := &Plan{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("failed to parse plan %s: %v", path, err)
+	}
+	return p, nil
+}
+
+func (p *Plan) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %v", err)
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save plan %s: %v", path, err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to save plan %s: %v", path, err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to save plan %s: %v", path, err)
+	}
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return fmt.Errorf("failed to save plan %s: %v", path, err)
+	}
+	return nil
+}
+
+// Guard blocks runs changing more than MaxChanges resources, 0 disables it. Blocked plans are written to Dir
+// and posted to Webhook.
+type Guard struct {
+	MaxChanges int
+	Dir        string
+	Webhook    string
+}
+
+func (g Guard) Exceeded(changes int) bool {
+	return g.MaxChanges > 0 && changes > g.MaxChanges
+}
+
+// Validate refuses a maximum without Dir and Webhook, the plans of blocked runs could not be applied.
+func (g Guard) Validate() error {
+	if g.MaxChanges > 0 && g.Dir == "" && g.Webhook == "" {
+		return fmt.Errorf("maxchanges %d needs a plan directory or a plan webhook, blocked runs could not be approved", g.MaxChanges)
+	}
+	return nil
+}
+
+// Submit stores the pending plan and returns the path it was written to, empty without Dir or when saving failed.
+func (g Guard) Submit(p *Plan) (string, error) {
+	path := ""
+	if g.Dir != "" {
+		if err := os.MkdirAll(g.Dir, 0755); err != nil {
+			return "", fmt.Errorf("failed to create plan directory %s: %v", g.Dir, err)
+		}
+		path = filepath.Join(g.Dir, p.Id+".json")
+		if err := p.Save(path); err != nil {
+			return "", err
+		}
+	}
+	if g.Webhook != "" {
+		if err := postPlan(g.Webhook, p); err != nil {
+			return path, err
+		}
+	}
+	return path, nil
+}
+
+func postPlan(url string, p *Plan) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %v", err)
+	}
+	httpClient := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post plan: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to post plan: unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package plan
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "plan")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestChangeResult(t *testing.T) {
+	change := Change{
+		InstanceId:   "i-1",
+		PreviousTags: map[string]string{"env": "prd", "Team": "infra"},
+		Add:          map[string]string{"Environment": "production", "Team": "web"},
+		Remove:       []string{"env"},
+	}
+	tests := []struct {
+		name   string
+		remove bool
+		want   map[string]string
+	}{
+		{"add only", false, map[string]string{"env": "prd", "Team": "web", "Environment": "production"}},
+		{"add and remove", true, map[string]string{"Team": "web", "Environment": "production"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := change.Result(tt.remove); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Result() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if change.PreviousTags["Team"] != "infra" || change.PreviousTags["env"] != "prd" {
+		t.Errorf("Result() changed PreviousTags to %v", change.PreviousTags)
+	}
+}
+
+func TestChangeString(t *testing.T) {
+	change := Change{
+		PreviousTags: map[string]string{"env": "prd"},
+		Add:          map[string]string{"Environment": "production", "Team": "web"},
+		Remove:       []string{"env"},
+	}
+	want := "+Environment=production +Team=web -env=prd"
+	if got := change.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestSameChanges(t *testing.T) {
+	add := func(id string, value string) Change {
+		return Change{InstanceId: id, PreviousTags: map[string]string{}, Add: map[string]string{"Environment": value}}
+	}
+	tests := []struct {
+		name string
+		a    []Change
+		b    []Change
+		want bool
+	}{
+		{"empty", nil, []Change{}, true},
+		{"same", []Change{add("i-1", "production")}, []Change{add("i-1", "production")}, true},
+		{"other order", []Change{add("i-1", "production"), add("i-2", "staging")}, []Change{add("i-2", "staging"), add("i-1", "production")}, true},
+		{"other length", []Change{add("i-1", "production")}, []Change{add("i-1", "production"), add("i-2", "staging")}, false},
+		{"other instance", []Change{add("i-1", "production")}, []Change{add("i-2", "production")}, false},
+		{"other value", []Change{add("i-1", "production")}, []Change{add("i-1", "staging")}, false},
+		{
+			name: "other remove",
+			a:    []Change{{InstanceId: "i-1", PreviousTags: map[string]string{"env": "prd"}, Remove: []string{"env"}}},
+			b:    []Change{{InstanceId: "i-1", PreviousTags: map[string]string{"env": "stg"}, Remove: []string{"env"}}},
+			want: false,
+		},
+		{"swapped", []Change{add("i-1", "production"), add("i-2", "staging")}, []Change{add("i-1", "staging"), add("i-2", "production")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SameChanges(tt.a, tt.b); got != tt.want {
+				t.Errorf("SameChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExceeded(t *testing.T) {
+	tests := []struct {
+		maxChanges int
+		changes    int
+		want       bool
+	}{
+		{0, 1000, false},
+		{10, 0, false},
+		{10, 10, false},
+		{10, 11, true},
+	}
+	for _, tt := range tests {
+		guard := Guard{MaxChanges: tt.maxChanges}
+		if got := guard.Exceeded(tt.changes); got != tt.want {
+			t.Errorf("Exceeded(%d) with maximum %d = %v, want %v", tt.changes, tt.maxChanges, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		guard   Guard
+		wantErr bool
+	}{
+		{"disabled", Guard{}, false},
+		{"disabled with dir", Guard{Dir: "/plans"}, false},
+		{"dir", Guard{MaxChanges: 10, Dir: "/plans"}, false},
+		{"webhook", Guard{MaxChanges: 10, Webhook: "http://localhost/plans"}, false},
+		{"no target", Guard{MaxChanges: 10}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.guard.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func testPlan() *Plan {
+	return New("updatek8stags", "cn-hangzhou", 1, []Change{
+		{InstanceId: "i-1", PreviousTags: map[string]string{}, Add: map[string]string{"Environment": "production"}},
+		{InstanceId: "i-2", PreviousTags: map[string]string{}, Add: map[string]string{"Environment": "staging"}},
+	})
+}
+
+func TestSubmitCreatesDir(t *testing.T) {
+	dir := filepath.Join(tempDir(t), "plans", "pending")
+	p := testPlan()
+	path, err := Guard{MaxChanges: 1, Dir: dir}.Submit(p)
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if want := filepath.Join(dir, p.Id+".json"); path != want {
+		t.Errorf("Submit() path = %s, want %s", path, want)
+	}
+	saved, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if saved.Status != StatusPending || saved.Job != "updatek8stags" || !SameChanges(saved.Changes, p.Changes) {
+		t.Errorf("Load() = %+v, want the submitted plan", saved)
+	}
+}
+
+func TestSubmitJobWithSlashes(t *testing.T) {
+	dir := tempDir(t)
+	p := New("tagpolicy/infra/k8s-workers", "cn-hangzhou", 1, testPlan().Changes)
+	path, err := Guard{MaxChanges: 1, Dir: dir}.Submit(p)
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if filepath.Dir(path) != dir {
+		t.Errorf("Submit() path = %s, want a file in %s", path, dir)
+	}
+	if p.Job != "tagpolicy/infra/k8s-workers" {
+		t.Errorf("New() job = %s, want the job name unchanged", p.Job)
+	}
+}
+
+func TestSubmitWebhook(t *testing.T) {
+	var posted Plan
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&posted); err != nil {
+			t.Errorf("failed to decode plan: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	p := testPlan()
+	path, err := Guard{MaxChanges: 1, Webhook: server.URL}.Submit(p)
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if path != "" {
+		t.Errorf("Submit() path = %s, want none without dir", path)
+	}
+	if posted.Id != p.Id || len(posted.Changes) != 2 {
+		t.Errorf("posted plan = %+v, want %s with 2 changes", posted, p.Id)
+	}
+}
+
+func TestSubmitWebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	dir := tempDir(t)
+	p := testPlan()
+	path, err := Guard{MaxChanges: 1, Dir: dir, Webhook: server.URL}.Submit(p)
+	if err == nil {
+		t.Fatalf("Submit() did not fail")
+	}
+	if path == "" {
+		t.Fatalf("Submit() path is empty, want the saved plan")
+	}
+	if _, err := Load(path); err != nil {
+		t.Errorf("Load() error = %v", err)
+	}
+}
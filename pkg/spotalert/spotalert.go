@@ -0,0 +1,267 @@
+package spotalert
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ConditionDiscount = "discount"
+	ConditionPrice    = "price"
+)
+
+// State of an alert, the values are exported as the value of the alert metric.
+type State int
+
+const (
+	StateOK State = iota
+	StatePending
+	StateFiring
+)
+
+func (s State) String() string {
+	switch s {
+	case StatePending:
+		return "pending"
+	case StateFiring:
+		return "firing"
+	default:
+		return "ok"
+	}
+}
+
+// Rule applies to the instance types and zones matching the Type and Zone regular expressions, all when empty.
+// The discount alert fires when the discount from the list price is below MinDiscount percent and resolves once it is
+// Hysteresis percentage points above it. The price alert fires when the spot price is above MaxPrice and resolves once it
+// is Hysteresis percent below it. An alert fires only after its condition held for For, a threshold of 0 is disabled.
+type Rule struct {
+	Type        string        `mapstructure:"type"`
+	Zone        string        `mapstructure:"zone"`
+	MinDiscount float64       `mapstructure:"minDiscount"`
+	MaxPrice    float64       `mapstructure:"maxPrice"`
+	Hysteresis  float64       `mapstructure:"hysteresis"`
+	For         time.Duration `mapstructure:"for"`
+}
+
+// Config is the "spotalert" section of the config file, the first rule matching a type and zone is used.
+type Config struct {
+	Rules []Rule `mapstructure:"rules"`
+}
+
+type rule struct {
+	Rule
+	typeRe *regexp.Regexp
+	zoneRe *regexp.Regexp
+}
+
+func (r rule) matches(instanceType string, zone string) bool {
+	return (r.typeRe == nil || r.typeRe.MatchString(instanceType)) && (r.zoneRe == nil || r.zoneRe.MatchString(zone))
+}
+
+// Alert is the state of one condition of an instance type in a zone.
+type Alert struct {
+	Type      string
+	Zone      string
+	Condition string
+	Value     float64
+	Threshold float64
+	State     State
+	// Since is when the condition started to hold, or when the alert fired once it is firing.
+	Since time.Time
+	seen  bool
+}
+
+func (a Alert) String() string {
+	if a.Condition == ConditionDiscount {
+		return fmt.Sprintf("%s in %s discount %.2f%% (minimum %.2f%%)", a.Type, a.Zone, a.Value, a.Threshold)
+	}
+	return fmt.Sprintf("%s in %s spot price %.4f (maximum %.4f)", a.Type, a.Zone, a.Value, a.Threshold)
+}
+
+// Transition is an alert that started firing or was resolved.
+type Transition struct {
+	Alert    Alert
+	Resolved bool
+}
+
+// Evaluator keeps the state of the alerts between runs.
+type Evaluator struct {
+	rules  []rule
+	alerts map[string]*Alert
+	mtx    sync.Mutex
+}
+
+func NewEvaluator(cfg Config) (*Evaluator, error) {
+	e := &Evaluator{alerts: map[string]*Alert{}}
+	for _, r := range cfg.Rules {
+		compiled := rule{Rule: r}
+		if r.Type != "" {
+			re, err := regexp.Compile(r.Type)
+			if err != nil {
+				return nil, fmt.Errorf("invalid spot alert type %s: %v", r.Type, err)
+			}
+			compiled.typeRe = re
+		}
+		if r.Zone != "" {
+			re, err := regexp.Compile(r.Zone)
+			if err != nil {
+				return nil, fmt.Errorf("invalid spot alert zone %s: %v", r.Zone, err)
+			}
+			compiled.zoneRe = re
+		}
+		if r.MinDiscount < 0 || r.MaxPrice < 0 || r.Hysteresis < 0 || r.For < 0 {
+			return nil, fmt.Errorf("spot alert rule for type %q zone %q has a negative threshold", r.Type, r.Zone)
+		}
+		e.rules = append(e.rules, compiled)
+	}
+	return e, nil
+}
+
+// Enabled reports whether any rule is configured, a nil Evaluator is disabled.
+func (e *Evaluator) Enabled() bool {
+	return e != nil && len(e.rules) > 0
+}
+
+// Discount is the discount of the spot price from the list price in percent.
+func Discount(spotPrice float64, listPrice float64) float64 {
+	if listPrice <= 0 {
+		return 0
+	}
+	return (1 - spotPrice/listPrice) * 100
+}
+
+// Headroom is how far the spot price is below the price limit in percent of the limit, negative above it.
+func Headroom(priceLimit float64, spotPrice float64) float64 {
+	if priceLimit <= 0 {
+		return 0
+	}
+	return (priceLimit - spotPrice) / priceLimit * 100
+}
+
+// Evaluate updates the alerts of the instance type in the zone and returns the ones that fired or resolved.
+func (e *Evaluator) Evaluate(instanceType string, zone string, spotPrice float64, listPrice float64, now time.Time) []Transition {
+	if !e.Enabled() {
+		return nil
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	var r *rule
+	for i := range e.rules {
+		if e.rules[i].matches(instanceType, zone) {
+			r = &e.rules[i]
+			break
+		}
+	}
+	if r == nil {
+		return nil
+	}
+	transitions := []Transition{}
+	if r.MinDiscount > 0 && listPrice > 0 {
+		discount := Discount(spotPrice, listPrice)
+		breach := discount < r.MinDiscount
+		clear := discount >= r.MinDiscount+r.Hysteresis
+		if t := e.update(instanceType, zone, ConditionDiscount, discount, r.MinDiscount, breach, clear, r.For, now); t != nil {
+			transitions = append(transitions, *t)
+		}
+	} else if r.MinDiscount > 0 {
+		// without a list price the discount is unknown, the alert keeps its state until the next price
+		if a, ok := e.alerts[alertKey(instanceType, zone, ConditionDiscount)]; ok {
+			a.seen = true
+		}
+	}
+	if r.MaxPrice > 0 {
+		breach := spotPrice > r.MaxPrice
+		clear := spotPrice <= r.MaxPrice*(1-r.Hysteresis/100)
+		if t := e.update(instanceType, zone, ConditionPrice, spotPrice, r.MaxPrice, breach, clear, r.For, now); t != nil {
+			transitions = append(transitions, *t)
+		}
+	}
+	return transitions
+}
+
+func alertKey(instanceType string, zone string, condition string) string {
+	return instanceType + "/" + zone + "/" + condition
+}
+
+// update moves an alert between the states, a pending alert is dropped as soon as the condition no longer holds
+// while a firing alert keeps firing until the value is past the hysteresis.
+func (e *Evaluator) update(instanceType string, zone string, condition string, value float64, threshold float64, breach bool, clear bool, wait time.Duration, now time.Time) *Transition {
+	key := alertKey(instanceType, zone, condition)
+	a, ok := e.alerts[key]
+	if !ok {
+		a = &Alert{Type: instanceType, Zone: zone, Condition: condition}
+		e.alerts[key] = a
+	}
+	a.Value = value
+	a.Threshold = threshold
+	a.seen = true
+
+	switch {
+	case breach && a.State == StateOK:
+		a.State = StatePending
+		a.Since = now
+	case !breach && a.State == StatePending:
+		a.State = StateOK
+	case clear && a.State == StateFiring:
+		a.State = StateOK
+		return &Transition{Alert: *a, Resolved: true}
+	}
+	if a.State == StatePending && breach && now.Sub(a.Since) >= wait {
+		a.State = StateFiring
+		a.Since = now
+		return &Transition{Alert: *a}
+	}
+	return nil
+}
+
+// EndRun resolves the alerts of types and zones that were not evaluated since the last run and returns the
+// firing ones among them.
+func (e *Evaluator) EndRun() []Transition {
+	if !e.Enabled() {
+		return nil
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	transitions := []Transition{}
+	for key, a := range e.alerts {
+		if a.seen {
+			a.seen = false
+			continue
+		}
+		if a.State == StateFiring {
+			a.State = StateOK
+			transitions = append(transitions, Transition{Alert: *a, Resolved: true})
+		}
+		delete(e.alerts, key)
+	}
+	return transitions
+}
+
+// Alerts returns all alerts sorted by type, zone and condition.
+func (e *Evaluator) Alerts() []Alert {
+	if e == nil {
+		return nil
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	alerts := []Alert{}
+	for _, a := range e.alerts {
+		alerts = append(alerts, *a)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Type != alerts[j].Type {
+			return alerts[i].Type < alerts[j].Type
+		}
+		if alerts[i].Zone != alerts[j].Zone {
+			return alerts[i].Zone < alerts[j].Zone
+		}
+		return alerts[i].Condition < alerts[j].Condition
+	})
+	return alerts
+}
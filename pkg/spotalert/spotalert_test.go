@@ -0,0 +1,221 @@
+package spotalert
+
+import (
+	"testing"
+	"time"
+)
+
+// step evaluates one run of a single type and zone at the offset from the start of the test.
+type step struct {
+	at        time.Duration
+	spotPrice float64
+	listPrice float64
+	// skip leaves the type and zone out of the run, as when it is no longer offered.
+	skip bool
+	want State
+	// fired and resolved are the expected transitions of the run, including the ones of EndRun.
+	fired    int
+	resolved int
+}
+
+func TestEvaluator(t *testing.T) {
+	discount := Rule{MinDiscount: 50, Hysteresis: 10}
+	tests := []struct {
+		name      string
+		rule      Rule
+		condition string
+		steps     []step
+	}{
+		{
+			name:      "discount fires without for",
+			rule:      discount,
+			condition: ConditionDiscount,
+			steps: []step{
+				{spotPrice: 0.3, listPrice: 1, want: StateOK},
+				{at: time.Minute, spotPrice: 0.6, listPrice: 1, want: StateFiring, fired: 1},
+				{at: 2 * time.Minute, spotPrice: 0.6, listPrice: 1, want: StateFiring},
+			},
+		},
+		{
+			name:      "discount waits for",
+			rule:      Rule{MinDiscount: 50, For: 5 * time.Minute},
+			condition: ConditionDiscount,
+			steps: []step{
+				{spotPrice: 0.6, listPrice: 1, want: StatePending},
+				{at: 4 * time.Minute, spotPrice: 0.6, listPrice: 1, want: StatePending},
+				{at: 5 * time.Minute, spotPrice: 0.6, listPrice: 1, want: StateFiring, fired: 1},
+			},
+		},
+		{
+			name:      "pending dropped before for",
+			rule:      Rule{MinDiscount: 50, For: 5 * time.Minute},
+			condition: ConditionDiscount,
+			steps: []step{
+				{spotPrice: 0.6, listPrice: 1, want: StatePending},
+				{at: 2 * time.Minute, spotPrice: 0.4, listPrice: 1, want: StateOK},
+				{at: 4 * time.Minute, spotPrice: 0.6, listPrice: 1, want: StatePending},
+				{at: 8 * time.Minute, spotPrice: 0.6, listPrice: 1, want: StatePending},
+				{at: 9 * time.Minute, spotPrice: 0.6, listPrice: 1, want: StateFiring, fired: 1},
+			},
+		},
+		{
+			name:      "discount hysteresis",
+			rule:      discount,
+			condition: ConditionDiscount,
+			steps: []step{
+				{spotPrice: 0.6, listPrice: 1, want: StateFiring, fired: 1},
+				// 55% is above the minimum but within the hysteresis
+				{at: time.Minute, spotPrice: 0.45, listPrice: 1, want: StateFiring},
+				{at: 2 * time.Minute, spotPrice: 0.35, listPrice: 1, want: StateOK, resolved: 1},
+				{at: 3 * time.Minute, spotPrice: 0.45, listPrice: 1, want: StateOK},
+			},
+		},
+		{
+			name:      "price hysteresis",
+			rule:      Rule{MaxPrice: 1, Hysteresis: 10},
+			condition: ConditionPrice,
+			steps: []step{
+				{spotPrice: 1, want: StateOK},
+				{at: time.Minute, spotPrice: 1.2, want: StateFiring, fired: 1},
+				{at: 2 * time.Minute, spotPrice: 0.95, want: StateFiring},
+				{at: 3 * time.Minute, spotPrice: 0.85, want: StateOK, resolved: 1},
+			},
+		},
+		{
+			name:      "missing type resolved at end of run",
+			rule:      discount,
+			condition: ConditionDiscount,
+			steps: []step{
+				{spotPrice: 0.6, listPrice: 1, want: StateFiring, fired: 1},
+				{at: time.Minute, skip: true, want: StateOK, resolved: 1},
+			},
+		},
+		{
+			name:      "missing pending type dropped silently",
+			rule:      Rule{MinDiscount: 50, For: 5 * time.Minute},
+			condition: ConditionDiscount,
+			steps: []step{
+				{spotPrice: 0.6, listPrice: 1, want: StatePending},
+				{at: time.Minute, skip: true, want: StateOK},
+			},
+		},
+		{
+			name:      "missing list price keeps state",
+			rule:      discount,
+			condition: ConditionDiscount,
+			steps: []step{
+				{spotPrice: 0.6, listPrice: 1, want: StateFiring, fired: 1},
+				{at: time.Minute, spotPrice: 0.6, listPrice: 0, want: StateFiring},
+				{at: 2 * time.Minute, spotPrice: 0.6, listPrice: 0, want: StateFiring},
+				{at: 3 * time.Minute, spotPrice: 0.6, listPrice: 1, want: StateFiring},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewEvaluator(Config{Rules: []Rule{tt.rule}})
+			if err != nil {
+				t.Fatalf("NewEvaluator() error = %v", err)
+			}
+			start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			for i, s := range tt.steps {
+				transitions := []Transition{}
+				if !s.skip {
+					transitions = append(transitions, e.Evaluate("ecs.g6.large", "cn-hangzhou-h", s.spotPrice, s.listPrice, start.Add(s.at))...)
+				}
+				transitions = append(transitions, e.EndRun()...)
+				fired, resolved := 0, 0
+				for _, tr := range transitions {
+					if tr.Alert.Condition != tt.condition {
+						t.Errorf("step %d: transition of condition %s, want %s", i, tr.Alert.Condition, tt.condition)
+					}
+					if tr.Resolved {
+						resolved++
+					} else {
+						fired++
+					}
+				}
+				if fired != s.fired || resolved != s.resolved {
+					t.Errorf("step %d: %d fired and %d resolved, want %d and %d", i, fired, resolved, s.fired, s.resolved)
+				}
+				if got := state(e, tt.condition); got != s.want {
+					t.Errorf("step %d: state = %s, want %s", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+// state returns the state of the condition, ok when the alert is not tracked.
+func state(e *Evaluator, condition string) State {
+	for _, a := range e.Alerts() {
+		if a.Condition == condition {
+			return a.State
+		}
+	}
+	return StateOK
+}
+
+func TestEvaluatorRules(t *testing.T) {
+	e, err := NewEvaluator(Config{Rules: []Rule{
+		{Type: "^ecs\\.g6\\.", Zone: "-h$", MaxPrice: 2},
+		{Type: "^ecs\\.g6\\.", MaxPrice: 1},
+	}})
+	if err != nil {
+		t.Fatalf("NewEvaluator() error = %v", err)
+	}
+	now := time.Now()
+	if got := e.Evaluate("ecs.g6.large", "cn-hangzhou-h", 1.5, 0, now); len(got) != 0 {
+		t.Errorf("Evaluate() in matching zone = %v, want the first rule not firing", got)
+	}
+	if got := e.Evaluate("ecs.g6.large", "cn-hangzhou-i", 1.5, 0, now); len(got) != 1 || got[0].Alert.Threshold != 1 {
+		t.Errorf("Evaluate() in other zone = %v, want the second rule firing", got)
+	}
+	if got := e.Evaluate("ecs.c6.large", "cn-hangzhou-i", 10, 0, now); got != nil {
+		t.Errorf("Evaluate() of unmatched type = %v, want nil", got)
+	}
+}
+
+func TestNewEvaluator(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr bool
+	}{
+		{"valid", Rule{Type: "^ecs\\.", Zone: "cn-.*", MinDiscount: 50}, false},
+		{"invalid type", Rule{Type: "("}, true},
+		{"invalid zone", Rule{Zone: "("}, true},
+		{"negative threshold", Rule{MaxPrice: -1}, true},
+		{"negative for", Rule{For: -time.Minute}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewEvaluator(Config{Rules: []Rule{tt.rule}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewEvaluator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	var e *Evaluator
+	if e.Enabled() || e.Evaluate("ecs.g6.large", "cn-hangzhou-h", 1, 1, time.Now()) != nil || e.EndRun() != nil {
+		t.Errorf("nil Evaluator is not disabled")
+	}
+}
+
+func TestDiscountAndHeadroom(t *testing.T) {
+	if got := Discount(0.25, 1); got != 75 {
+		t.Errorf("Discount() = %v, want 75", got)
+	}
+	if got := Discount(0.25, 0); got != 0 {
+		t.Errorf("Discount() without list price = %v, want 0", got)
+	}
+	if got := Headroom(2, 1.5); got != 25 {
+		t.Errorf("Headroom() = %v, want 25", got)
+	}
+	if got := Headroom(1, 1.5); got != -50 {
+		t.Errorf("Headroom() above limit = %v, want -50", got)
+	}
+	if got := Headroom(0, 1.5); got != 0 {
+		t.Errorf("Headroom() without limit = %v, want 0", got)
+	}
+}
@@ -0,0 +1,163 @@
+package tagnorm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Value is a canonical tag value and the synonyms rewritten to it.
+type Value struct {
+	Value    string   `mapstructure:"value"`
+	Synonyms []string `mapstructure:"synonyms"`
+}
+
+// Key is a canonical tag key, the aliases rewritten to it and the canonical values of the key.
+type Key struct {
+	Key     string   `mapstructure:"key"`
+	Aliases []string `mapstructure:"aliases"`
+	Values  []Value  `mapstructure:"values"`
+}
+
+// Config is the "normalize" section of the config file. Keys, aliases, values and synonyms are compared case insensitive,
+// they are lists because the config file loader lowercases map keys.
+type Config struct {
+	Keys []Key `mapstructure:"keys"`
+}
+
+// DefaultConfig matches the Environment key case insensitive.
+func DefaultConfig() Config {
+	return Config{
+		Keys: []Key{{Key: "Environment"}},
+	}
+}
+
+type Normalizer struct {
+	keys   map[string]string
+	values map[string]map[string]string
+}
+
+func NewNormalizer(cfg Config) (*Normalizer, error) {
+	n := &Normalizer{
+		keys:   map[string]string{},
+		values: map[string]map[string]string{},
+	}
+	for _, k := range cfg.Keys {
+		if k.Key == "" {
+			return nil, fmt.Errorf("normalize key without name")
+		}
+		for _, alias := range append([]string{k.Key}, k.Aliases...) {
+			if other, ok := n.keys[strings.ToLower(alias)]; ok && other != k.Key {
+				return nil, fmt.Errorf("tag key alias %s used by %s and %s", alias, other, k.Key)
+			}
+			n.keys[strings.ToLower(alias)] = k.Key
+		}
+		values := map[string]string{}
+		for _, v := range k.Values {
+			for _, synonym := range append([]string{v.Value}, v.Synonyms...) {
+				if other, ok := values[strings.ToLower(synonym)]; ok && other != v.Value {
+					return nil, fmt.Errorf("tag value synonym %s of %s used by %s and %s", synonym, k.Key, other, v.Value)
+				}
+				values[strings.ToLower(synonym)] = v.Value
+			}
+		}
+		n.values[k.Key] = values
+	}
+	return n, nil
+}
+
+// CanonicalKey returns the canonical form of key, keys that are not configured are returned unchanged.
+func (n *Normalizer) CanonicalKey(key string) string {
+	if n == nil {
+		return key
+	}
+	if canonical, ok := n.keys[strings.ToLower(key)]; ok {
+		return canonical
+	}
+	return key
+}
+
+// CanonicalValue returns the canonical form of value of the canonical key, unknown values are returned unchanged.
+func (n *Normalizer) CanonicalValue(key string, value string) string {
+	if n == nil {
+		return value
+	}
+	if canonical, ok := n.values[key][strings.ToLower(value)]; ok {
+		return canonical
+	}
+	return value
+}
+
+// Normalize returns the tags with canonical keys and values. When several keys have the same canonical key
+// the tag already using the canonical key wins, otherwise the first key in sort order.
+func (n *Normalizer) Normalize(tags map[string]string) map[string]string {
+	keys := []string{}
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	normalized := map[string]string{}
+	for _, k := range keys {
+		canonical := n.CanonicalKey(k)
+		if _, ok := normalized[canonical]; ok && k != canonical {
+			continue
+		}
+		normalized[canonical] = n.CanonicalValue(canonical, tags[k])
+	}
+	return normalized
+}
+
+// Plan is the rewrite of the tags of one resource to their canonical form.
+type Plan struct {
+	// Add holds the canonical tags to set.
+	Add map[string]string
+	// Remove holds the non-canonical keys to remove after Add is set.
+	Remove []string
+	// Conflicts maps canonical keys left alone because their aliases disagree on the value to a description.
+	Conflicts map[string]string
+}
+
+func (p Plan) Empty() bool {
+	return len(p.Add) == 0 && len(p.Remove) == 0
+}
+
+// Plan computes the changes rewriting tags to their canonical form.
+func (n *Normalizer) Plan(tags map[string]string) Plan {
+	plan := Plan{Add: map[string]string{}, Conflicts: map[string]string{}}
+	sources := map[string][]string{}
+	for k := range tags {
+		canonical := n.CanonicalKey(k)
+		sources[canonical] = append(sources[canonical], k)
+	}
+	canonicalKeys := []string{}
+	for canonical := range sources {
+		canonicalKeys = append(canonicalKeys, canonical)
+	}
+	sort.Strings(canonicalKeys)
+	for _, canonical := range canonicalKeys {
+		keys := sources[canonical]
+		sort.Strings(keys)
+		values := map[string]bool{}
+		for _, k := range keys {
+			values[n.CanonicalValue(canonical, tags[k])] = true
+		}
+		if len(values) > 1 {
+			pairs := []string{}
+			for _, k := range keys {
+				pairs = append(pairs, k+"="+tags[k])
+			}
+			plan.Conflicts[canonical] = fmt.Sprintf("different values: %s", strings.Join(pairs, ", "))
+			continue
+		}
+		value := n.CanonicalValue(canonical, tags[keys[0]])
+		if current, ok := tags[canonical]; !ok || current != value {
+			plan.Add[canonical] = value
+		}
+		for _, k := range keys {
+			if k != canonical {
+				plan.Remove = append(plan.Remove, k)
+			}
+		}
+	}
+	return plan
+}
@@ -0,0 +1,139 @@
+package tagnorm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testNormalizer(t *testing.T) *Normalizer {
+	n, err := NewNormalizer(Config{
+		Keys: []Key{
+			{
+				Key:     "Environment",
+				Aliases: []string{"env"},
+				Values: []Value{
+					{Value: "production", Synonyms: []string{"prd", "prod"}},
+					{Value: "staging", Synonyms: []string{"stg"}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewNormalizer() error = %v", err)
+	}
+	return n
+}
+
+func TestNewNormalizer(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"default", DefaultConfig(), false},
+		{"key without name", Config{Keys: []Key{{Aliases: []string{"env"}}}}, true},
+		{"alias used twice", Config{Keys: []Key{{Key: "Environment", Aliases: []string{"env"}}, {Key: "Env", Aliases: []string{"ENV"}}}}, true},
+		{"synonym used twice", Config{Keys: []Key{{Key: "Environment", Values: []Value{{Value: "production", Synonyms: []string{"prd"}}, {Value: "staging", Synonyms: []string{"PRD"}}}}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewNormalizer(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewNormalizer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	n := testNormalizer(t)
+	tests := []struct {
+		name string
+		tags map[string]string
+		want map[string]string
+	}{
+		{"key case", map[string]string{"environment": "production"}, map[string]string{"Environment": "production"}},
+		{"value case", map[string]string{"Environment": "Prod"}, map[string]string{"Environment": "production"}},
+		{"alias and synonym", map[string]string{"env": "prd"}, map[string]string{"Environment": "production"}},
+		{"canonical", map[string]string{"Environment": "staging", "Team": "infra"}, map[string]string{"Environment": "staging", "Team": "infra"}},
+		{"unknown value", map[string]string{"env": "qa"}, map[string]string{"Environment": "qa"}},
+		{"canonical key wins", map[string]string{"Environment": "stg", "env": "prd"}, map[string]string{"Environment": "staging"}},
+		{"first alias wins", map[string]string{"env": "prd", "environment": "stg"}, map[string]string{"Environment": "production"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := n.Normalize(tt.tags); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Normalize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlan(t *testing.T) {
+	n := testNormalizer(t)
+	tests := []struct {
+		name string
+		tags map[string]string
+		want Plan
+	}{
+		{
+			name: "canonical",
+			tags: map[string]string{"Environment": "production", "Team": "infra"},
+			want: Plan{Add: map[string]string{}, Conflicts: map[string]string{}},
+		},
+		{
+			name: "value synonym",
+			tags: map[string]string{"Environment": "Prod"},
+			want: Plan{Add: map[string]string{"Environment": "production"}, Conflicts: map[string]string{}},
+		},
+		{
+			name: "key case",
+			tags: map[string]string{"environment": "production"},
+			want: Plan{Add: map[string]string{"Environment": "production"}, Remove: []string{"environment"}, Conflicts: map[string]string{}},
+		},
+		{
+			name: "alias and synonym",
+			tags: map[string]string{"env": "prd"},
+			want: Plan{Add: map[string]string{"Environment": "production"}, Remove: []string{"env"}, Conflicts: map[string]string{}},
+		},
+		{
+			name: "aliases agree",
+			tags: map[string]string{"Environment": "Prod", "environment": "production", "env": "prd"},
+			want: Plan{Add: map[string]string{"Environment": "production"}, Remove: []string{"env", "environment"}, Conflicts: map[string]string{}},
+		},
+		{
+			name: "aliases already canonical",
+			tags: map[string]string{"Environment": "production", "env": "prd"},
+			want: Plan{Add: map[string]string{}, Remove: []string{"env"}, Conflicts: map[string]string{}},
+		},
+		{
+			name: "conflict",
+			tags: map[string]string{"Environment": "production", "env": "stg", "Team": "infra"},
+			want: Plan{
+				Add:       map[string]string{},
+				Conflicts: map[string]string{"Environment": "different values: Environment=production, env=stg"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := n.Plan(tt.tags)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Plan() = %+v, want %+v", got, tt.want)
+			}
+			if got.Empty() != (len(tt.want.Add) == 0 && len(tt.want.Remove) == 0) {
+				t.Errorf("Plan().Empty() = %v", got.Empty())
+			}
+		})
+	}
+}
+
+func TestNilNormalizer(t *testing.T) {
+	var n *Normalizer
+	if got := n.CanonicalKey("env"); got != "env" {
+		t.Errorf("CanonicalKey() = %q, want %q", got, "env")
+	}
+	if got := n.CanonicalValue("env", "prd"); got != "prd" {
+		t.Errorf("CanonicalValue() = %q, want %q", got, "prd")
+	}
+}